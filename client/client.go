@@ -0,0 +1,263 @@
+// Package client is a Go client of the splunk-event-reader API, returning the response types of the model package.
+//
+//	c := client.New("http://splunk-event-reader:8080")
+//	transactions, err := c.Transactions(ctx, "annotations", client.TransactionsOptions{TimeRange: client.TimeRange{Earliest: "-30m"}})
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Financial-Times/splunk-event-reader/model"
+)
+
+const (
+	defaultMaxAttempts = 3
+	defaultBackoff     = 500 * time.Millisecond
+	defaultTimeout     = time.Minute
+
+	// WarningHeader carries the warnings of the Splunk searches a response is built from
+	WarningHeader = "X-Splunk-Warning"
+)
+
+// ErrNotFound is returned when no event matches the request
+var ErrNotFound = errors.New("no matching event")
+
+// Client calls the splunk-event-reader API, retrying the requests failing with a network error or a 502, 503 or 504
+// status; it is safe for concurrent use
+type Client struct {
+	baseURL     string
+	httpClient  *http.Client
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// TimeRange is the time window of a search, as Splunk relative times (e.g. -30m) or epoch seconds; the service
+// default window is used for an empty value
+type TimeRange struct {
+	Earliest string
+	Latest   string
+}
+
+// TransactionsOptions selects the transactions of a content type
+type TransactionsOptions struct {
+	TimeRange
+	UUIDs    []string
+	Services []string
+	Events   []string
+	Levels   []string
+	// IsValid keeps the transactions with an event of this validity when set
+	IsValid *bool
+}
+
+// EventsOptions selects the last events of a content type
+type EventsOptions struct {
+	Earliest string
+	// Events are the events searched for; PublishEnd if not set
+	Events   []string
+	Services []string
+	Levels   []string
+}
+
+// Error is the error of a request the service failed, with the message of its error body
+type Error struct {
+	StatusCode int
+	Message    string
+	Warnings   []string
+}
+
+// New returns a client of the service at baseURL, e.g. http://localhost:8080
+func New(baseURL string, options ...Option) *Client {
+	client := &Client{
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		httpClient:  &http.Client{Timeout: defaultTimeout},
+		maxAttempts: defaultMaxAttempts,
+		backoff:     defaultBackoff,
+	}
+	for _, option := range options {
+		option(client)
+	}
+	return client
+}
+
+// WithHTTPClient makes the client send its requests with httpClient
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(client *Client) {
+		client.httpClient = httpClient
+	}
+}
+
+// WithRetries sets the number of attempts of a request, and the backoff before the second attempt, doubled for each
+// further attempt; a single attempt disables the retries
+func WithRetries(maxAttempts int, backoff time.Duration) Option {
+	return func(client *Client) {
+		if maxAttempts < 1 {
+			maxAttempts = 1
+		}
+		client.maxAttempts = maxAttempts
+		client.backoff = backoff
+	}
+}
+
+func (err *Error) Error() string {
+	if err.Message == "" {
+		return fmt.Sprintf("splunk-event-reader responded with status %d", err.StatusCode)
+	}
+	return fmt.Sprintf("splunk-event-reader responded with status %d: %s", err.StatusCode, err.Message)
+}
+
+// Temporary tells whether the request may succeed if sent again
+func (err *Error) Temporary() bool {
+	return err.StatusCode == http.StatusBadGateway || err.StatusCode == http.StatusServiceUnavailable || err.StatusCode == http.StatusGatewayTimeout
+}
+
+// Transactions returns the open transactions of a content type
+func (client *Client) Transactions(ctx context.Context, contentType string, options TransactionsOptions) ([]model.TransactionEvent, error) {
+	var transactions []model.TransactionEvent
+	err := client.get(ctx, "/"+url.PathEscape(contentType)+"/transactions", options.params(), &transactions)
+	return transactions, err
+}
+
+// TransactionsByContentType returns the open transactions of several content types, by content type
+func (client *Client) TransactionsByContentType(ctx context.Context, contentTypes []string, options TransactionsOptions) (map[string][]model.TransactionEvent, error) {
+	params := options.params()
+	params["contentType"] = contentTypes
+	var transactions map[string][]model.TransactionEvent
+	err := client.get(ctx, "/transactions", params, &transactions)
+	return transactions, err
+}
+
+// LastEvent returns the last event of a content type, or ErrNotFound
+func (client *Client) LastEvent(ctx context.Context, contentType string, options EventsOptions) (*model.PublishEvent, error) {
+	params := options.params()
+	params.Set("lastEvent", "true")
+	event := &model.PublishEvent{}
+	if err := client.get(ctx, "/"+url.PathEscape(contentType)+"/events", params, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// LastEvents returns up to limit latest events of a content type, latest first
+func (client *Client) LastEvents(ctx context.Context, contentType string, limit int, options EventsOptions) ([]model.PublishEvent, error) {
+	params := options.params()
+	params.Set("limit", strconv.Itoa(limit))
+	var events []model.PublishEvent
+	err := client.get(ctx, "/"+url.PathEscape(contentType)+"/events", params, &events)
+	return events, err
+}
+
+// LastEventByContentType returns the last event of several content types, by content type; the content types without
+// events are left out
+func (client *Client) LastEventByContentType(ctx context.Context, contentTypes []string, options EventsOptions) (map[string]model.PublishEvent, error) {
+	params := options.params()
+	params.Set("lastEvent", "true")
+	params["contentType"] = contentTypes
+	var events map[string]model.PublishEvent
+	err := client.get(ctx, "/events", params, &events)
+	return events, err
+}
+
+func (options TransactionsOptions) params() url.Values {
+	params := url.Values{}
+	setParam(params, "earliestTime", options.Earliest)
+	setParam(params, "latestTime", options.Latest)
+	addParams(params, "uuid", options.UUIDs)
+	addParams(params, "service", options.Services)
+	addParams(params, "event", options.Events)
+	addParams(params, "level", options.Levels)
+	if options.IsValid != nil {
+		params.Set("isValid", strconv.FormatBool(*options.IsValid))
+	}
+	return params
+}
+
+func (options EventsOptions) params() url.Values {
+	params := url.Values{}
+	setParam(params, "earliestTime", options.Earliest)
+	addParams(params, "event", options.Events)
+	addParams(params, "service", options.Services)
+	addParams(params, "level", options.Levels)
+	return params
+}
+
+func setParam(params url.Values, name string, value string) {
+	if value != "" {
+		params.Set(name, value)
+	}
+}
+
+func addParams(params url.Values, name string, values []string) {
+	if len(values) > 0 {
+		params[name] = values
+	}
+}
+
+// get sends a GET request, retrying the temporary failures, and decodes the response body into result
+func (client *Client) get(ctx context.Context, path string, params url.Values, result interface{}) error {
+	requestURL := client.baseURL + path
+	if len(params) > 0 {
+		requestURL += "?" + params.Encode()
+	}
+
+	backoff := client.backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = client.do(ctx, requestURL, result)
+		if err == nil || !retry || attempt >= client.maxAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// do sends a single request, and tells whether its failure is worth a retry
+func (client *Client) do(ctx context.Context, requestURL string, result interface{}) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &Error{StatusCode: resp.StatusCode, Warnings: resp.Header[WarningHeader]}
+		body, _ := ioutil.ReadAll(resp.Body)
+		errorResponse := model.ErrorResponse{}
+		if json.Unmarshal(body, &errorResponse) == nil {
+			apiErr.Message = errorResponse.Message
+		} else {
+			apiErr.Message = strings.TrimSpace(string(body))
+		}
+		return apiErr.Temporary(), apiErr
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return false, fmt.Errorf("invalid splunk-event-reader response: %v", err)
+	}
+	return false, nil
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Financial-Times/splunk-event-reader/model"
+)
+
+func TestClient_Transactions(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/annotations/transactions", r.URL.Path)
+		query = r.URL.Query()
+		w.Write([]byte(`[{"transaction_id":"tid_1","uuid":"uuid_1","closed_txn":"0","eventcount":1,"events":[{"content_type":"Annotations","event":"PublishStart","level":"info","service_name":"cms-notifier","@time":"2017-09-19T14:00:02Z","transaction_id":"tid_1","uuid":"uuid_1"}],"start_time":"2017-09-19T14:00:02Z"}]`))
+	}))
+	defer server.Close()
+
+	valid := false
+	transactions, err := New(server.URL).Transactions(context.Background(), "annotations", TransactionsOptions{
+		TimeRange: TimeRange{Earliest: "-30m", Latest: "-5m"},
+		UUIDs:     []string{"uuid_1", "uuid_2"},
+		IsValid:   &valid,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, url.Values{"earliestTime": {"-30m"}, "latestTime": {"-5m"}, "uuid": {"uuid_1", "uuid_2"}, "isValid": {"false"}}, query)
+	assert.Equal(t, []model.TransactionEvent{{
+		TransactionID: "tid_1",
+		UUID:          "uuid_1",
+		ClosedTxn:     "0",
+		EventCount:    1,
+		StartTime:     "2017-09-19T14:00:02Z",
+		Events:        []model.PublishEvent{{ContentType: "Annotations", Event: "PublishStart", Level: "info", ServiceName: "cms-notifier", Time: "2017-09-19T14:00:02Z", TransactionID: "tid_1", UUID: "uuid_1"}},
+	}}, transactions)
+}
+
+func TestClient_LastEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/events":
+			assert.Equal(t, url.Values{"lastEvent": {"true"}, "contentType": {"annotations", "lists"}}, r.URL.Query())
+			w.Write([]byte(`{"annotations":{"event":"PublishEnd","transaction_id":"tid_1"}}`))
+		case r.URL.Query().Get("limit") != "":
+			assert.Equal(t, url.Values{"limit": {"2"}, "event": {"PublishStart"}}, r.URL.Query())
+			w.Write([]byte(`[{"event":"PublishStart","transaction_id":"tid_2"},{"event":"PublishStart","transaction_id":"tid_1"}]`))
+		case r.URL.Query().Get("earliestTime") == "-1m":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			assert.Equal(t, url.Values{"lastEvent": {"true"}}, r.URL.Query())
+			w.Write([]byte(`{"event":"PublishEnd","transaction_id":"tid_1"}`))
+		}
+	}))
+	defer server.Close()
+	client := New(server.URL + "/")
+
+	event, err := client.LastEvent(context.Background(), "annotations", EventsOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, &model.PublishEvent{Event: "PublishEnd", TransactionID: "tid_1"}, event)
+
+	_, err = client.LastEvent(context.Background(), "annotations", EventsOptions{Earliest: "-1m"})
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	events, err := client.LastEvents(context.Background(), "annotations", 2, EventsOptions{Events: []string{"PublishStart"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []model.PublishEvent{{Event: "PublishStart", TransactionID: "tid_2"}, {Event: "PublishStart", TransactionID: "tid_1"}}, events)
+
+	byContentType, err := client.LastEventByContentType(context.Background(), []string{"annotations", "lists"}, EventsOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]model.PublishEvent{"annotations": {Event: "PublishEnd", TransactionID: "tid_1"}}, byContentType)
+}
+
+func TestClient_Errors(t *testing.T) {
+	var requests int32
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Add(WarningHeader, "Search head is busy")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"message":"splunk search failed"}`))
+	}))
+	defer server.Close()
+	client := New(server.URL, WithRetries(3, time.Millisecond))
+
+	_, err := client.Transactions(context.Background(), "annotations", TransactionsOptions{})
+	apiErr := &Error{}
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, &Error{StatusCode: http.StatusServiceUnavailable, Message: "splunk search failed", Warnings: []string{"Search head is busy"}}, apiErr)
+	assert.True(t, apiErr.Temporary())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests), "temporary failures are retried")
+
+	status = http.StatusBadRequest
+	atomic.StoreInt32(&requests, 0)
+	_, err = client.Transactions(context.Background(), "annotations", TransactionsOptions{})
+	assert.EqualError(t, err, "splunk-event-reader responded with status 400: splunk search failed")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	status = http.StatusBadGateway
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	atomic.StoreInt32(&requests, 0)
+	_, err = client.Transactions(ctx, "annotations", TransactionsOptions{})
+	assert.Error(t, err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requests), "a cancelled context sends no request")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// eventCursor marks the latest event seen by a consumer of the transactions endpoint
+type eventCursor struct {
+	IndexTime int64  `json:"i"`
+	Time      string `json:"t"`
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func decodeCursor(value string) (*eventCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	cursor := eventCursor{}
+	if err = json.Unmarshal(data, &cursor); err != nil || cursor.IndexTime <= 0 {
+		return nil, errInvalidCursor
+	}
+	if _, err = time.Parse(time.RFC3339Nano, cursor.Time); err != nil {
+		return nil, errInvalidCursor
+	}
+	return &cursor, nil
+}
+
+func (cursor *eventCursor) encode() string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// earliestTime returns an absolute search start that still includes the earlier events of transactions spanning the cursor
+func (cursor *eventCursor) earliestTime(lookback time.Duration) string {
+	t, _ := time.Parse(time.RFC3339Nano, cursor.Time)
+	return strconv.FormatInt(t.Add(-lookback).Unix(), 10)
+}
+
+// precedes tells whether the event was indexed after the cursor, the event time breaking the ties of the events indexed
+// in the same second
+func (cursor *eventCursor) precedes(event publishEvent) bool {
+	indexTime, err := strconv.ParseInt(event.IndexTime, 10, 64)
+	if err != nil || indexTime < cursor.IndexTime {
+		return false
+	}
+	if indexTime > cursor.IndexTime {
+		return true
+	}
+	eventTime, err := time.Parse(time.RFC3339Nano, event.Time)
+	if err != nil {
+		return false
+	}
+	cursorTime, _ := time.Parse(time.RFC3339Nano, cursor.Time)
+	return eventTime.After(cursorTime)
+}
+
+// nextCursor returns a cursor pointing at the latest indexed event of the transactions, or the previous cursor if there are none
+func nextCursor(previous *eventCursor, transactions []transactionEvent) *eventCursor {
+	next := eventCursor{}
+	if previous != nil {
+		next = *previous
+	}
+	for _, transaction := range transactions {
+		for _, event := range transaction.Events {
+			indexTime, err := strconv.ParseInt(event.IndexTime, 10, 64)
+			if err != nil {
+				continue
+			}
+			if _, err = time.Parse(time.RFC3339Nano, event.Time); err != nil {
+				continue
+			}
+			if next.IndexTime == 0 || next.precedes(event) {
+				next = eventCursor{IndexTime: indexTime, Time: event.Time}
+			}
+		}
+	}
+	if next.IndexTime == 0 {
+		return nil
+	}
+	return &next
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	health "github.com/Financial-Times/go-fthealth/v1_1"
+)
+
+const (
+	backendSplunk     = "splunk"
+	backendOpenSearch = "opensearch"
+	backendFile       = "file"
+)
+
+// maxThroughputBuckets limits the throughput computed from events, so that a small span over a long time window
+// does not build huge series
+const maxThroughputBuckets = 1000
+
+// relativeTimeRegex matches the relative times of the queries, e.g. -10m
+var relativeTimeRegex = regexp.MustCompile(`^-(\d+)([smhd])$`)
+
+// ErrNoResults returned when the query yields no results
+var ErrNoResults = errors.New("No results")
+
+// EventReader reads the monitoring events of the publishing pipeline from a log store. The searches also return their
+// metadata, whose warnings tell when the results may be incomplete, even along with ErrNoResults.
+type EventReader interface {
+	GetTransactions(query monitoringQuery) ([]transactionEvent, error)
+	GetTransactionsWithMetadata(query monitoringQuery) ([]transactionEvent, searchMetadata, error)
+	GetTransactionsByContentType(query monitoringQuery, contentTypes []string) (map[string][]transactionEvent, searchMetadata, error)
+	GetLastEvent(query monitoringQuery) (*publishEvent, searchMetadata, error)
+	GetLastEvents(query monitoringQuery, limit int) ([]publishEvent, searchMetadata, error)
+	GetLastEventByContentType(query monitoringQuery, contentTypes []string) (map[string]publishEvent, searchMetadata, error)
+	GetErrors(query monitoringQuery) ([]errorReport, searchMetadata, error)
+	GetThroughput(query monitoringQuery, span string) ([]timeSeries, searchMetadata, error)
+	// StreamEvents calls warn when some events may be missing from the stream, like the searches return warnings
+	StreamEvents(ctx context.Context, query monitoringQuery, send func(event publishEvent) error, warn func(warning string)) error
+	IsHealthy() healthStatus
+	// HealthChecks are the backend specific checks, in addition to IsHealthy
+	HealthChecks() []health.Check
+}
+
+type monitoringQuery struct {
+	ContentType  string
+	EarliestTime string
+	LatestTime   string
+	UUIDs        []string
+	Services     []string
+	Events       []string
+	Levels       []string
+	IsValid      string
+	// IncludeClosed returns closed transactions too, not only the open ones
+	IncludeClosed bool
+	// Cursor limits the results to transactions updated since a previous query; closed transactions are kept
+	Cursor *eventCursor
+}
+
+// isReturned tells whether the transaction is returned by the query, closed transactions only being returned on demand
+// or to replace previously returned versions
+func isReturned(transaction transactionEvent, query monitoringQuery) bool {
+	return transaction.ClosedTxn != "1" || query.IncludeClosed || query.Cursor != nil
+}
+
+// dropClosedTransactions removes the closed transactions the query does not return, from transactions merged from
+// searches that returned them all
+func dropClosedTransactions(transactions []transactionEvent, query monitoringQuery) []transactionEvent {
+	kept := []transactionEvent{}
+	for _, transaction := range transactions {
+		if isReturned(transaction, query) {
+			kept = append(kept, transaction)
+		}
+	}
+	return kept
+}
+
+// assembleTransactions groups the events of a search by transaction, and keeps the transactions matching the query
+// that have at least one event of each content type
+func assembleTransactions(events []publishEvent, query monitoringQuery, contentTypes []string) map[string][]transactionEvent {
+	transactions := make(map[string][]transactionEvent)
+	for _, contentType := range contentTypes {
+		transactions[contentType] = []transactionEvent{}
+	}
+
+	txMap := make(map[string]*transactionEvent)
+	for _, event := range events {
+
+		transaction := txMap[event.TransactionID]
+
+		if transaction == nil {
+			transaction = &transactionEvent{
+				TransactionID: event.TransactionID,
+				ClosedTxn:     "0",
+			}
+
+			txMap[event.TransactionID] = transaction
+		}
+
+		if event.UUID != "" {
+			transaction.UUID = event.UUID
+		}
+
+		transaction.Events = append(transaction.Events, event)
+		transaction.EventCount++
+		if event.Event == "PublishStart" {
+			transaction.StartTime = event.Time
+		}
+		if event.Event == "PublishEnd" {
+			transaction.ClosedTxn = "1"
+		}
+	}
+
+	for _, transaction := range txMap {
+		if isReturned(*transaction, query) && hasMatchingEvent(*transaction, query) {
+			// if transaction has at least one event with the required content type: keep it
+			for _, contentType := range contentTypes {
+				for _, event := range transaction.Events {
+					if strings.EqualFold(event.ContentType, contentType) {
+						transactions[contentType] = append(transactions[contentType], *transaction)
+						break
+					}
+				}
+			}
+		}
+	}
+	return transactions
+}
+
+// groupErrorReports groups error events by service and event, most frequent first
+func groupErrorReports(events []publishEvent) []errorReport {
+	reports := []errorReport{}
+	reportIndex := make(map[[2]string]int)
+	for _, event := range events {
+		key := [2]string{event.ServiceName, event.Event}
+		i, found := reportIndex[key]
+		if !found {
+			i = len(reports)
+			reportIndex[key] = i
+			reports = append(reports, errorReport{ServiceName: event.ServiceName, Event: event.Event, SampleTransactionIDs: []string{}})
+		}
+
+		report := &reports[i]
+		report.Count++
+		if len(report.SampleTransactionIDs) < maxErrorSamples && !contains(report.SampleTransactionIDs, event.TransactionID) {
+			report.SampleTransactionIDs = append(report.SampleTransactionIDs, event.TransactionID)
+		}
+		if isLaterEventTime(event.Time, report.LatestTime) {
+			report.LatestTime = event.Time
+		}
+	}
+
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].Count > reports[j].Count
+	})
+	return reports
+}
+
+// isLaterEventTime reports whether the @time a is later than b; the times are parsed, as the same instant has several
+// RFC3339 forms, e.g. with another zone or precision, which do not sort as strings. A time that does not parse is earlier
+// than any valid one.
+func isLaterEventTime(a string, b string) bool {
+	timeA, errA := time.Parse(time.RFC3339Nano, a)
+	timeB, errB := time.Parse(time.RFC3339Nano, b)
+	if errA != nil || errB != nil {
+		return errA == nil
+	}
+	return timeA.After(timeB)
+}
+
+// throughputSeries counts the transactions in the bucket of their first event, as the Splunk timechart does,
+// for the backends that compute throughput from the PublishStart and PublishEnd events
+func throughputSeries(events []publishEvent, spanDuration time.Duration) []timeSeries {
+	type transactionStats struct {
+		start  time.Time
+		closed bool
+	}
+	stats := make(map[string]*transactionStats)
+	for _, event := range events {
+		t, err := time.Parse(time.RFC3339Nano, event.Time)
+		if err != nil {
+			continue
+		}
+		transaction, found := stats[event.TransactionID]
+		if !found {
+			transaction = &transactionStats{start: t}
+			stats[event.TransactionID] = transaction
+		}
+		if t.Before(transaction.start) {
+			transaction.start = t
+		}
+		transaction.closed = transaction.closed || event.Event == "PublishEnd"
+	}
+
+	started := make(map[int64]float64)
+	completed := make(map[int64]float64)
+	var first, last int64 = math.MaxInt64, math.MinInt64
+	for _, transaction := range stats {
+		bucket := transaction.start.Truncate(spanDuration).Unix() * 1000
+		started[bucket]++
+		if transaction.closed {
+			completed[bucket]++
+		}
+		if bucket < first {
+			first = bucket
+		}
+		if bucket > last {
+			last = bucket
+		}
+	}
+
+	startedSeries := timeSeries{Target: "started", Datapoints: [][2]float64{}}
+	completedSeries := timeSeries{Target: "completed", Datapoints: [][2]float64{}}
+	openSeries := timeSeries{Target: "open", Datapoints: [][2]float64{}}
+	step := spanDuration.Milliseconds()
+	if len(stats) > 0 && (last-first)/step < maxThroughputBuckets {
+		for bucket := first; bucket <= last; bucket += step {
+			millis := float64(bucket)
+			startedSeries.Datapoints = append(startedSeries.Datapoints, [2]float64{started[bucket], millis})
+			completedSeries.Datapoints = append(completedSeries.Datapoints, [2]float64{completed[bucket], millis})
+			openSeries.Datapoints = append(openSeries.Datapoints, [2]float64{started[bucket] - completed[bucket], millis})
+		}
+	}
+	return []timeSeries{startedSeries, completedSeries, openSeries}
+}
+
+// updatedSince keeps the transactions having at least one event indexed after the cursor
+func updatedSince(transactions []transactionEvent, cursor *eventCursor) []transactionEvent {
+	updated := []transactionEvent{}
+	for _, transaction := range transactions {
+		for _, event := range transaction.Events {
+			if cursor.precedes(event) {
+				updated = append(updated, transaction)
+				break
+			}
+		}
+	}
+	return updated
+}
+
+func parseSpan(span string) (time.Duration, error) {
+	if !spanRegex.MatchString(span) {
+		return 0, fmt.Errorf("invalid span %s", span)
+	}
+	if strings.HasSuffix(span, "d") {
+		days, _ := strconv.Atoi(strings.TrimSuffix(span, "d"))
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(span)
+}
+
+func isValidBackend(backend string) bool {
+	return backend == backendSplunk || backend == backendOpenSearch || backend == backendFile
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsLaterEventTime(t *testing.T) {
+	tests := []struct {
+		a        string
+		b        string
+		expected bool
+	}{
+		{a: "2017-09-19T15:10:03.000Z", b: "2017-09-19T15:10:02.000Z", expected: true},
+		{a: "2017-09-19T15:10:02.000Z", b: "2017-09-19T15:10:03.000Z", expected: false},
+		// later as a string, but earlier in time
+		{a: "2017-09-19T16:10:03.000+02:00", b: "2017-09-19T15:10:02.000Z", expected: false},
+		{a: "2017-09-19T15:10:03Z", b: "2017-09-19T15:10:03.000Z", expected: false},
+		{a: "2017-09-19T15:10:03.5Z", b: "2017-09-19T15:10:03Z", expected: true},
+		{a: "2017-09-19T15:10:03Z", b: "", expected: true},
+		{a: "", b: "2017-09-19T15:10:03Z", expected: false},
+		{a: "INVALID", b: "", expected: false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, isLaterEventTime(test.a, test.b), "%s > %s", test.a, test.b)
+	}
+}
+
+func TestGroupErrorReports(t *testing.T) {
+	reports := groupErrorReports([]publishEvent{
+		{ServiceName: "annotations-rw", Event: "SaveNeo4j", TransactionID: "tid_1", Time: "2017-09-19T15:10:03.000Z"},
+		{ServiceName: "annotations-rw", Event: "SaveNeo4j", TransactionID: "tid_2", Time: "2017-09-19T17:10:02.000+02:00"},
+		{ServiceName: "annotations-rw", Event: "SaveNeo4j", TransactionID: "tid_2", Time: "2017-09-19T15:10:01.000Z"},
+		{ServiceName: "annotations-mapper", Event: "Map", TransactionID: "tid_3", Time: "2017-09-19T15:10:00.000Z"},
+	})
+	assert.Equal(t, []errorReport{
+		{ServiceName: "annotations-rw", Event: "SaveNeo4j", Count: 3, SampleTransactionIDs: []string{"tid_1", "tid_2"}, LatestTime: "2017-09-19T15:10:03.000Z"},
+		{ServiceName: "annotations-mapper", Event: "Map", Count: 1, SampleTransactionIDs: []string{"tid_3"}, LatestTime: "2017-09-19T15:10:00.000Z"},
+	}, reports)
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	health "github.com/Financial-Times/go-fthealth/v1_1"
+)
+
+const (
+	defaultFilePollInterval     = time.Second
+	syntheticTransactionPrefix  = "SYNTHETIC"
+	carouselTransactionFragment = "carousel"
+)
+
+type fileConfig struct {
+	dir string
+	// replayTime is the time the service starts at, so that recorded events are found by the relative time windows;
+	// the current time is used if not set
+	replayTime         time.Time
+	streamPollInterval time.Duration
+}
+
+// fileService reads the monitoring events from the JSON and NDJSON files of a directory, for local development and demos.
+// The files are read again for each query, so that they can be changed while the service runs.
+type fileService struct {
+	Config  fileConfig
+	started time.Time
+}
+
+// fileEvent is an event of the files, which are filtered on monitoring_event like in Splunk
+type fileEvent struct {
+	publishEvent
+	MonitoringEvent string `json:"monitoring_event"`
+}
+
+// eventFilter selects the events of a query, as the search templates do
+type eventFilter struct {
+	earliestTime     time.Time
+	latestTime       time.Time
+	contentTypes     []string
+	includeUntyped   bool
+	excludeSynthetic bool
+	uuids            []string
+	services         []string
+	events           []string
+	levels           []string
+}
+
+func newFileService(config fileConfig) EventReader {
+	if config.streamPollInterval == 0 {
+		config.streamPollInterval = defaultFilePollInterval
+	}
+	return &fileService{Config: config, started: time.Now()}
+}
+
+// now is the replay time, moving on with the clock
+func (service *fileService) now() time.Time {
+	if service.Config.replayTime.IsZero() {
+		return time.Now()
+	}
+	return service.Config.replayTime.Add(time.Since(service.started))
+}
+
+func (service *fileService) GetTransactions(query monitoringQuery) ([]transactionEvent, error) {
+	transactions, _, err := service.GetTransactionsWithMetadata(query)
+	return transactions, err
+}
+
+func (service *fileService) GetTransactionsWithMetadata(query monitoringQuery) ([]transactionEvent, searchMetadata, error) {
+	transactions, metadata, err := service.searchTransactions(query, []string{query.ContentType})
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	return transactions[query.ContentType], metadata, nil
+}
+
+func (service *fileService) GetTransactionsByContentType(query monitoringQuery, contentTypes []string) (map[string][]transactionEvent, searchMetadata, error) {
+	return service.searchTransactions(query, contentTypes)
+}
+
+func (service *fileService) searchTransactions(query monitoringQuery, contentTypes []string) (map[string][]transactionEvent, searchMetadata, error) {
+	earliestTime := query.EarliestTime
+	if earliestTime == "" && query.Cursor != nil {
+		earliestTime = query.Cursor.earliestTime(defaultCursorLookback)
+	} else if earliestTime == "" {
+		earliestTime = defaultEarliestTime
+	}
+	filter, err := service.timeFilter(earliestTime, query.LatestTime)
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	filter.contentTypes = contentTypes
+	filter.includeUntyped = true
+	filter.excludeSynthetic = true
+	filter.uuids = query.UUIDs
+
+	events, metadata, err := service.search(filter)
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+
+	transactions := assembleTransactions(events, query, contentTypes)
+	if query.Cursor != nil {
+		for contentType, contentTypeTransactions := range transactions {
+			transactions[contentType] = updatedSince(contentTypeTransactions, query.Cursor)
+		}
+	}
+	return transactions, metadata, nil
+}
+
+func (service *fileService) GetLastEvent(query monitoringQuery) (*publishEvent, searchMetadata, error) {
+	events, metadata, err := service.GetLastEvents(query, 1)
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	if len(events) > 0 {
+		return &events[0], metadata, nil
+	}
+	return nil, metadata, ErrNoResults
+}
+
+func (service *fileService) GetLastEvents(query monitoringQuery, limit int) ([]publishEvent, searchMetadata, error) {
+	events, metadata, err := service.lastEvents(query, []string{query.ContentType})
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, metadata, nil
+}
+
+func (service *fileService) GetLastEventByContentType(query monitoringQuery, contentTypes []string) (map[string]publishEvent, searchMetadata, error) {
+	events, metadata, err := service.lastEvents(query, contentTypes)
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	lastEvents := make(map[string]publishEvent)
+	for _, event := range events {
+		for _, contentType := range contentTypes {
+			if _, found := lastEvents[contentType]; !found && strings.EqualFold(event.ContentType, contentType) {
+				lastEvents[contentType] = event
+			}
+		}
+	}
+	return lastEvents, metadata, nil
+}
+
+func (service *fileService) lastEvents(query monitoringQuery, contentTypes []string) ([]publishEvent, searchMetadata, error) {
+	filter, err := service.timeFilter(query.EarliestTime, "")
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	filter.contentTypes = contentTypes
+	filter.events = query.Events
+	if len(filter.events) == 0 {
+		filter.events = []string{defaultLastEvent}
+	}
+	filter.uuids = query.UUIDs
+	filter.services = query.Services
+	filter.levels = query.Levels
+	return service.search(filter)
+}
+
+func (service *fileService) GetErrors(query monitoringQuery) ([]errorReport, searchMetadata, error) {
+	filter, err := service.timeFilter(orDefault(query.EarliestTime, defaultEarliestTime), query.LatestTime)
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	filter.contentTypes = []string{query.ContentType}
+	filter.includeUntyped = true
+	filter.excludeSynthetic = true
+
+	events, metadata, err := service.search(filter)
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	// the events of the shared services have no content type, so they only count in the transactions of the content type
+	typed := make(map[string]bool)
+	for _, event := range events {
+		if event.ContentType != "" {
+			typed[event.TransactionID] = true
+		}
+	}
+	var errorEvents []publishEvent
+	for _, event := range events {
+		if !typed[event.TransactionID] {
+			continue
+		}
+		if strings.EqualFold(event.Level, "error") || strings.EqualFold(event.IsValid, "false") {
+			errorEvents = append(errorEvents, event)
+		}
+	}
+	return groupErrorReports(errorEvents), metadata, nil
+}
+
+func (service *fileService) GetThroughput(query monitoringQuery, span string) ([]timeSeries, searchMetadata, error) {
+	spanDuration, err := parseSpan(span)
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	filter, err := service.timeFilter(orDefault(query.EarliestTime, defaultEarliestTime), query.LatestTime)
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	filter.contentTypes = []string{query.ContentType}
+	filter.includeUntyped = true
+	filter.excludeSynthetic = true
+	filter.events = []string{"PublishStart", "PublishEnd"}
+
+	events, metadata, err := service.search(filter)
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	return throughputSeries(events, spanDuration), metadata, nil
+}
+
+// StreamEvents sends the events of the files as their time is reached, so that recorded events are replayed
+func (service *fileService) StreamEvents(ctx context.Context, query monitoringQuery, send func(event publishEvent) error, warn func(warning string)) error {
+	filter, err := service.timeFilter(streamEarliestTime, "")
+	if err != nil {
+		return err
+	}
+	filter.contentTypes = []string{query.ContentType}
+	filter.includeUntyped = true
+	filter.excludeSynthetic = true
+	filter.uuids = query.UUIDs
+	filter.services = query.Services
+	filter.events = query.Events
+
+	for {
+		filter.latestTime = service.now()
+		events, _, err := service.search(filter)
+		if err != nil {
+			return err
+		}
+		for i := len(events) - 1; i >= 0; i-- {
+			if err = send(events[i]); err != nil {
+				return err
+			}
+		}
+		filter.earliestTime = filter.latestTime.Add(time.Nanosecond)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(service.Config.streamPollInterval):
+		}
+	}
+}
+
+func (service *fileService) IsHealthy() healthStatus {
+	if _, err := ioutil.ReadDir(service.Config.dir); err != nil {
+		return healthStatus{message: "Events directory error", err: err, time: time.Now()}
+	}
+	return healthStatus{message: "Events directory is ok", time: time.Now()}
+}
+
+func (service *fileService) HealthChecks() []health.Check {
+	return nil
+}
+
+// search returns the events of the files matching the filter, latest first, along with the details of the search
+func (service *fileService) search(filter eventFilter) ([]publishEvent, searchMetadata, error) {
+	started := time.Now()
+	events, err := readEventsDir(service.Config.dir)
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	var matching []publishEvent
+	for _, event := range events {
+		if filter.matches(event) {
+			matching = append(matching, event.publishEvent)
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return isLaterEventTime(matching[i].Time, matching[j].Time)
+	})
+	return matching, searchMetadata{Sids: []string{}, Duration: time.Since(started).Seconds(), EventCount: len(matching)}, nil
+}
+
+func (service *fileService) timeFilter(earliestTime string, latestTime string) (eventFilter, error) {
+	now := service.now()
+	earliest, err := resolveTime(earliestTime, now)
+	if err != nil {
+		return eventFilter{}, err
+	}
+	latest, err := resolveTime(latestTime, now)
+	if err != nil {
+		return eventFilter{}, err
+	}
+	if latest.IsZero() {
+		latest = now
+	}
+	return eventFilter{earliestTime: earliest, latestTime: latest}, nil
+}
+
+func (filter eventFilter) matches(event fileEvent) bool {
+	if event.MonitoringEvent != "true" {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339Nano, event.Time)
+	if err != nil || t.Before(filter.earliestTime) || t.After(filter.latestTime) {
+		return false
+	}
+	if !(filter.includeUntyped && event.ContentType == "") && !matchesAny(event.ContentType, filter.contentTypes) {
+		return false
+	}
+	if filter.excludeSynthetic && (strings.HasPrefix(event.TransactionID, syntheticTransactionPrefix) || strings.Contains(event.TransactionID, carouselTransactionFragment)) {
+		return false
+	}
+	return matchesAny(event.UUID, filter.uuids) && matchesAny(event.ServiceName, filter.services) &&
+		matchesAny(event.Event, filter.events) && matchesAny(event.Level, filter.levels)
+}
+
+// readEventsDir reads the events of the .json files, either Splunk responses or arrays of events, and of the .ndjson files
+func readEventsDir(dir string) ([]fileEvent, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var events []fileEvent
+	for _, file := range files {
+		path := filepath.Join(dir, file.Name())
+		var fileEvents []fileEvent
+		switch filepath.Ext(file.Name()) {
+		case ".json":
+			fileEvents, err = readJSONEvents(path)
+		case ".ndjson":
+			fileEvents, err = readNDJSONEvents(path)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid events file %s: %v", path, err)
+		}
+		events = append(events, fileEvents...)
+	}
+	return events, nil
+}
+
+func readJSONEvents(path string) ([]fileEvent, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var events []fileEvent
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &events)
+	} else {
+		response := struct {
+			Results []fileEvent `json:"results"`
+		}{}
+		err = json.Unmarshal(data, &response)
+		events = response.Results
+	}
+	return withIndexTime(events), err
+}
+
+func readNDJSONEvents(path string) ([]fileEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []fileEvent
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		event := fileEvent{}
+		if err = json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		events = append(events, event)
+	}
+	return withIndexTime(events), scanner.Err()
+}
+
+// withIndexTime sets the index time of the events without one to their event time, for the cursors
+func withIndexTime(events []fileEvent) []fileEvent {
+	for i := range events {
+		if events[i].IndexTime != "" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339Nano, events[i].Time); err == nil {
+			events[i].IndexTime = strconv.FormatInt(t.Unix(), 10)
+		}
+	}
+	return events
+}
+
+// resolveTime returns the time of a relative (-10m), epoch or RFC3339 query time, or the zero time if not set
+func resolveTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if relativeTimeRegex.MatchString(value) {
+		duration, err := parseSpan(strings.TrimPrefix(value, "-"))
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(-duration), nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %s", value)
+	}
+	return t, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const ndjsonEventsSample = `{"@time":"2017-09-19T14:01:00Z","content_type":"Annotations","event":"PublishStart","level":"info","monitoring_event":"true","service_name":"cms-notifier","transaction_id":"tid_2","uuid":"uuid_2"}
+{"@time":"2017-09-19T14:01:05Z","content_type":"Annotations","event":"SaveNeo4j","level":"error","monitoring_event":"true","service_name":"annotations-rw","transaction_id":"tid_2","uuid":"uuid_2"}
+
+{"@time":"2017-09-19T14:01:10Z","content_type":"Annotations","event":"PublishEnd","level":"info","monitoring_event":"true","service_name":"annotations-rw","transaction_id":"tid_2","uuid":"uuid_2"}
+{"@time":"2017-09-19T14:02:00Z","content_type":"Annotations","event":"PublishStart","level":"info","monitoring_event":"true","service_name":"cms-notifier","transaction_id":"SYNTHETIC-REQ-MON_1","uuid":"uuid_3"}
+{"@time":"2017-09-19T14:02:00Z","content_type":"Annotations","event":"PublishEnd","level":"info","monitoring_event":"false","service_name":"cms-notifier","transaction_id":"tid_4","uuid":"uuid_4"}
+`
+
+func newTestEventsDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "events")
+	assert.NoError(t, err)
+	sample, err := ioutil.ReadFile("testdata/splunk_response_sample.json")
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "splunk_response_sample.json"), sample, 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "events.ndjson"), []byte(ndjsonEventsSample), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("not events"), 0600))
+	return dir
+}
+
+func newTestFileService(dir string) EventReader {
+	replayTime, _ := time.Parse(time.RFC3339, "2017-09-19T14:05:00Z")
+	return newFileService(fileConfig{dir: dir, replayTime: replayTime, streamPollInterval: time.Millisecond})
+}
+
+func TestFileService_GetTransactions(t *testing.T) {
+	dir := newTestEventsDir(t)
+	defer os.RemoveAll(dir)
+	service := newTestFileService(dir)
+
+	transactions, metadata, err := service.GetTransactionsWithMetadata(monitoringQuery{ContentType: "annotations"})
+	assert.NoError(t, err)
+	assert.Equal(t, 9, metadata.EventCount)
+	assert.Len(t, transactions, 1)
+	assert.Equal(t, "tid_hamoil09hg", transactions[0].TransactionID)
+	assert.Equal(t, "27355ee6-e280-4fb8-b825-8f14be1be9d3", transactions[0].UUID)
+	assert.Equal(t, 6, transactions[0].EventCount)
+	assert.Equal(t, "2017-09-19T14:00:04Z", transactions[0].Events[0].Time)
+	assert.Equal(t, "1505829604", transactions[0].Events[0].IndexTime)
+
+	transactions, err = service.GetTransactions(monitoringQuery{ContentType: "annotations", IncludeClosed: true, UUIDs: []string{"uuid_2"}})
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 1)
+	assert.Equal(t, "tid_2", transactions[0].TransactionID)
+	assert.Equal(t, "1", transactions[0].ClosedTxn)
+
+	// the window is relative to the replay time
+	transactions, err = service.GetTransactions(monitoringQuery{ContentType: "annotations", EarliestTime: "-1m"})
+	assert.NoError(t, err)
+	assert.Empty(t, transactions)
+
+	transactions, err = service.GetTransactions(monitoringQuery{ContentType: "content"})
+	assert.NoError(t, err)
+	assert.Empty(t, transactions)
+}
+
+func TestFileService_GetLastEvents(t *testing.T) {
+	dir := newTestEventsDir(t)
+	defer os.RemoveAll(dir)
+	service := newTestFileService(dir)
+
+	event, _, err := service.GetLastEvent(monitoringQuery{ContentType: "annotations"})
+	assert.NoError(t, err)
+	assert.Equal(t, "tid_2", event.TransactionID)
+
+	events, _, err := service.GetLastEvents(monitoringQuery{ContentType: "annotations", Events: []string{"PublishStart", "Forwarding"}}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SYNTHETIC-REQ-MON_1", "tid_2"}, []string{events[0].TransactionID, events[1].TransactionID})
+
+	_, _, err = service.GetLastEvent(monitoringQuery{ContentType: "content"})
+	assert.True(t, errors.Is(err, ErrNoResults))
+
+	// the latest event is logged with an offset, and sorts earlier as a string
+	offset := `{"@time":"2017-09-19T10:03:00-04:00","content_type":"Annotations","event":"PublishEnd","level":"info","monitoring_event":"true","service_name":"annotations-rw","transaction_id":"tid_5","uuid":"uuid_5"}`
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "offset.ndjson"), []byte(offset), 0600))
+	event, _, err = service.GetLastEvent(monitoringQuery{ContentType: "annotations"})
+	assert.NoError(t, err)
+	assert.Equal(t, "tid_5", event.TransactionID)
+}
+
+func TestFileService_GetErrorsAndThroughput(t *testing.T) {
+	dir := newTestEventsDir(t)
+	defer os.RemoveAll(dir)
+	service := newTestFileService(dir)
+	// the errors of the shared services, without content type, only count in the transactions of the content type
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "shared.ndjson"), []byte(`{"@time":"2017-09-19T14:01:06Z","event":"Publish","level":"error","monitoring_event":"true","service_name":"publish-service","transaction_id":"tid_2"}
+{"@time":"2017-09-19T14:01:07Z","event":"Publish","level":"error","monitoring_event":"true","service_name":"publish-service","transaction_id":"tid_6"}
+`), 0600))
+
+	reports, _, err := service.GetErrors(monitoringQuery{ContentType: "annotations"})
+	assert.NoError(t, err)
+	assert.Equal(t, []errorReport{
+		{ServiceName: "publish-service", Event: "Publish", Count: 1, SampleTransactionIDs: []string{"tid_2"}, LatestTime: "2017-09-19T14:01:06Z"},
+		{ServiceName: "annotations-rw", Event: "SaveNeo4j", Count: 1, SampleTransactionIDs: []string{"tid_2"}, LatestTime: "2017-09-19T14:01:05Z"},
+	}, reports)
+
+	series, _, err := service.GetThroughput(monitoringQuery{ContentType: "annotations"}, "1m")
+	assert.NoError(t, err)
+	assert.Equal(t, []timeSeries{
+		{Target: "started", Datapoints: [][2]float64{{1, 1505829660000}}},
+		{Target: "completed", Datapoints: [][2]float64{{1, 1505829660000}}},
+		{Target: "open", Datapoints: [][2]float64{{0, 1505829660000}}},
+	}, series)
+}
+
+func TestFileService_StreamEvents(t *testing.T) {
+	dir := newTestEventsDir(t)
+	defer os.RemoveAll(dir)
+	service := newTestFileService(dir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var transactionIDs []string
+	err := service.StreamEvents(ctx, monitoringQuery{ContentType: "annotations"}, func(event publishEvent) error {
+		transactionIDs = append(transactionIDs, event.TransactionID)
+		return nil
+	}, func(warning string) {})
+	assert.NoError(t, err)
+	assert.Empty(t, transactionIDs)
+
+	// the events of the last minute of the replay time come in as they are added
+	recent, err := ioutil.ReadFile(filepath.Join(dir, "events.ndjson"))
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "events.ndjson"), append(recent, []byte(`{"@time":"2017-09-19T14:05:00.001Z","content_type":"Annotations","event":"PublishStart","monitoring_event":"true","transaction_id":"tid_5"}`)...), 0600))
+	ctx, cancel = context.WithCancel(context.Background())
+	err = service.StreamEvents(ctx, monitoringQuery{ContentType: "annotations"}, func(event publishEvent) error {
+		transactionIDs = append(transactionIDs, event.TransactionID)
+		cancel()
+		return nil
+	}, func(warning string) {})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tid_5"}, transactionIDs)
+}
+
+func TestFileService_InvalidFiles(t *testing.T) {
+	dir := newTestEventsDir(t)
+	defer os.RemoveAll(dir)
+	service := newTestFileService(dir)
+	assert.NoError(t, service.IsHealthy().err)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "invalid.ndjson"), []byte("{\"event\":\"PublishEnd\"}\n{"), 0600))
+	_, err := service.GetTransactions(monitoringQuery{ContentType: "annotations"})
+	assert.EqualError(t, err, "invalid events file "+filepath.Join(dir, "invalid.ndjson")+": line 2: unexpected end of JSON input")
+
+	assert.Error(t, newTestFileService(filepath.Join(dir, "missing")).IsHealthy().err)
+}
+
+func TestResolveTime(t *testing.T) {
+	now := time.Date(2017, 9, 19, 14, 5, 0, 0, time.UTC)
+	tests := []struct {
+		value    string
+		expected time.Time
+	}{
+		{"", time.Time{}},
+		{"-10m", now.Add(-10 * time.Minute)},
+		{"-1d", now.Add(-24 * time.Hour)},
+		{"1505829604", time.Unix(1505829604, 0)},
+		{"2017-09-19T14:00:04.5Z", time.Date(2017, 9, 19, 14, 0, 4, 500000000, time.UTC)},
+	}
+	for _, test := range tests {
+		actual, err := resolveTime(test.value, now)
+		assert.NoError(t, err)
+		assert.True(t, test.expected.Equal(actual), test.value)
+	}
+
+	_, err := resolveTime("yesterday", now)
+	assert.Error(t, err)
+}
@@ -1,34 +1,90 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 
 	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/splunk-event-reader/model"
 )
 
 const (
 	uuidPathVar            = "uuid"
 	earliestTimePathVar    = "earliestTime"
 	latestTimePathVar      = "latestTime"
+	cursorPathVar          = "cursor"
+	servicePathVar         = "service"
+	eventPathVar           = "event"
+	timeoutPathVar         = "timeout"
+	limitPathVar           = "limit"
+	levelPathVar           = "level"
+	isValidPathVar         = "isValid"
+	spanPathVar            = "span"
+	defaultThroughputSpan  = "1m"
+	maxEventsLimit         = 1000
+	maxSearchUUIDs         = 5000
+	maxSearchBodySize      = 1 << 20
+	envelopePathVar        = "envelope"
+	envelopeMediaType      = "application/vnd.ft-splunk-event-reader.envelope+json"
+	v2MediaType            = "application/vnd.ft-splunk-event-reader.v2+json"
+	versionPathVar         = "version"
+	cacheHit               = "hit"
+	cacheMiss              = "miss"
+	cacheNone              = "none"
+	warningHeader          = "X-Splunk-Warning"
+	defaultAwaitTimeout    = 60 * time.Second
+	maxAwaitTimeout        = 5 * time.Minute
+	nextCursorHeader       = "X-Next-Cursor"
 	lastEventPathVar       = "lastEvent"
 	contentTypePathVar     = "contentType"
 	contentTypeAnnotations = "annotations"
 )
 
+// defaultContentTypes are the content types accepted by default; the others are opted in with --content-types
+var defaultContentTypes = []string{contentTypeAnnotations}
+
+var awaitPollInterval = 5 * time.Second
+
 var (
-	contentTypes    = []string{contentTypeAnnotations}
+	contentTypes    = defaultContentTypes
 	timePeriodRegex = regexp.MustCompile(`^-\d+[msh]$`)
+	fieldValueRegex = regexp.MustCompile(`^[\w.-]+$`)
+	spanRegex       = regexp.MustCompile(`^[1-9]\d*[smhd]$`)
 )
 
+type transactionsSearchRequest struct {
+	UUIDs        []string `json:"uuids"`
+	EarliestTime string   `json:"earliestTime,omitempty"`
+	LatestTime   string   `json:"latestTime,omitempty"`
+	Services     []string `json:"services,omitempty"`
+	Levels       []string `json:"levels,omitempty"`
+	Events       []string `json:"events,omitempty"`
+	IsValid      string   `json:"isValid,omitempty"`
+}
+
+// responseEnvelope wraps the response data with the details of how it was obtained
+type responseEnvelope struct {
+	Data  interface{} `json:"data"`
+	Count int         `json:"count"`
+	Cache string      `json:"cache"`
+	searchMetadata
+}
+
 type requestHandler struct {
-	splunkService SplunkServiceI
-	log           *logger.UPPLogger
+	eventReader EventReader
+	pollers     map[string]*transactionsPoller
+	log         *logger.UPPLogger
 }
 
 func (handler *requestHandler) getTransactions(writer http.ResponseWriter, request *http.Request) {
@@ -38,52 +94,459 @@ func (handler *requestHandler) getTransactions(writer http.ResponseWriter, reque
 	defer request.Body.Close()
 
 	contentType := mux.Vars(request)[contentTypePathVar]
-	uuids := request.URL.Query()[uuidPathVar]
-	earliestTime := request.URL.Query().Get(earliestTimePathVar)
-	latestTime := request.URL.Query().Get(latestTimePathVar)
 
 	if !isValidContentType(contentType) {
-		log.Errorf("Invalid content type %s", contentType)
-		writer.WriteHeader(http.StatusBadRequest)
+		handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Invalid content type %s", contentType))
+		return
+	}
+
+	query, err := parseTransactionsQuery(request.URL.Query())
+	if err != nil {
+		handler.writeError(writer, http.StatusBadRequest, err)
+		return
+	}
+	query.ContentType = contentType
+
+	transactions, metadata, cache, err := handler.getTransactionsFromSnapshotOrSplunk(query)
+
+	if err != nil {
+		handler.writeError(writer, backendErrorStatus(err), err)
+		return
+	}
+
+	if cursor := nextCursor(query.Cursor, transactions); cursor != nil {
+		writer.Header().Set(nextCursorHeader, cursor.encode())
+	}
+
+	msg, err := marshalResponse(writer, request, transactions, len(transactions), metadata, cache)
+	if err != nil {
+		log.Error(err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if _, err = writer.Write([]byte(msg)); err != nil {
+		log.Error(err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+}
+
+func (handler *requestHandler) searchTransactions(writer http.ResponseWriter, request *http.Request) {
+
+	log := handler.log
+
+	defer request.Body.Close()
+
+	contentType := mux.Vars(request)[contentTypePathVar]
+
+	if !isValidContentType(contentType) {
+		handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Invalid content type %s", contentType))
+		return
+	}
+
+	body := transactionsSearchRequest{}
+	decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxSearchBodySize))
+	if err := decoder.Decode(&body); err != nil {
+		handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Invalid search request body: %v", err))
+		return
+	}
+
+	if len(body.UUIDs) == 0 || len(body.UUIDs) > maxSearchUUIDs {
+		handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Search request must have between 1 and %d UUIDs, it has %d", maxSearchUUIDs, len(body.UUIDs)))
+		return
+	}
+
+	query, err := parseTransactionsQuery(body.params())
+	if err != nil {
+		handler.writeError(writer, http.StatusBadRequest, err)
+		return
+	}
+	query.ContentType = contentType
+
+	transactions, metadata, err := handler.eventReader.GetTransactionsWithMetadata(query)
+
+	if err != nil {
+		handler.writeError(writer, backendErrorStatus(err), err)
+		return
+	}
+
+	msg, err := marshalResponse(writer, request, transactions, len(transactions), metadata, cacheNone)
+	if err != nil {
+		log.Error(err)
+		writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	if _, err = writer.Write([]byte(msg)); err != nil {
+		log.Error(err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+}
+
+// params maps the search request to the /transactions query params, so that it is validated the same way
+func (body transactionsSearchRequest) params() url.Values {
+	params := url.Values{}
+	params[uuidPathVar] = body.UUIDs
+	params[servicePathVar] = body.Services
+	params[levelPathVar] = body.Levels
+	params[eventPathVar] = body.Events
+	if body.EarliestTime != "" {
+		params.Set(earliestTimePathVar, body.EarliestTime)
+	}
+	if body.LatestTime != "" {
+		params.Set(latestTimePathVar, body.LatestTime)
+	}
+	if body.IsValid != "" {
+		params.Set(isValidPathVar, body.IsValid)
+	}
+	return params
+}
+
+func (handler *requestHandler) getTransactionsByContentType(writer http.ResponseWriter, request *http.Request) {
+
+	log := handler.log
+
+	defer request.Body.Close()
+
+	contentTypes, err := parseContentTypes(request.URL.Query())
+	if err != nil {
+		handler.writeError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	query, err := parseTransactionsQuery(request.URL.Query())
+	if err != nil {
+		handler.writeError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	transactions, metadata, err := handler.eventReader.GetTransactionsByContentType(query, contentTypes)
+
+	if err != nil {
+		handler.writeError(writer, backendErrorStatus(err), err)
+		return
+	}
+	writeWarnings(writer, metadata)
+
+	var all []transactionEvent
+	for _, contentTypeTransactions := range transactions {
+		all = append(all, contentTypeTransactions...)
+	}
+	if cursor := nextCursor(query.Cursor, all); cursor != nil {
+		writer.Header().Set(nextCursorHeader, cursor.encode())
+	}
+
+	msg, err := marshalData(writer, request, transactions)
+	if err != nil {
+		log.Error(err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if _, err = writer.Write([]byte(msg)); err != nil {
+		log.Error(err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+}
+
+func (handler *requestHandler) getLastEvent(writer http.ResponseWriter, request *http.Request) {
+
+	log := handler.log
+
+	defer request.Body.Close()
+
+	contentType := mux.Vars(request)[contentTypePathVar]
+
+	if !isValidContentType(contentType) {
+		handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Invalid content type %s", contentType))
+		return
+	}
+
+	query, limit, err := parseEventsQuery(request.URL.Query())
+	if err != nil {
+		handler.writeError(writer, http.StatusBadRequest, err)
+		return
+	}
+	query.ContentType = contentType
+
+	var result interface{}
+	var metadata searchMetadata
+	if limit > 0 {
+		result, metadata, err = handler.eventReader.GetLastEvents(query, limit)
+	} else {
+		result, metadata, err = handler.eventReader.GetLastEvent(query)
+	}
+
+	if err != nil {
+		if errors.Is(err, ErrNoResults) {
+			// the event may be missing because of the warnings
+			writeWarnings(writer, metadata)
+			writer.WriteHeader(http.StatusNotFound)
+		} else {
+			handler.writeError(writer, backendErrorStatus(err), err)
+		}
+		return
+	}
+	writeWarnings(writer, metadata)
+
+	msg, err := marshalData(writer, request, result)
+	if err != nil {
+		log.Error(err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if _, err = writer.Write([]byte(msg)); err != nil {
+		log.Error(err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+}
+
+func (handler *requestHandler) getLastEventByContentType(writer http.ResponseWriter, request *http.Request) {
+
+	log := handler.log
+
+	defer request.Body.Close()
+
+	contentTypes, err := parseContentTypes(request.URL.Query())
+	if err != nil {
+		handler.writeError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	query, limit, err := parseEventsQuery(request.URL.Query())
+	if err == nil && limit > 0 {
+		err = errors.New("limit is not supported for multiple content types")
+	}
+	if err != nil {
+		handler.writeError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	events, metadata, err := handler.eventReader.GetLastEventByContentType(query, contentTypes)
+
+	if err != nil {
+		handler.writeError(writer, backendErrorStatus(err), err)
+		return
+	}
+	writeWarnings(writer, metadata)
+
+	msg, err := marshalData(writer, request, events)
+	if err != nil {
+		log.Error(err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if _, err = writer.Write([]byte(msg)); err != nil {
+		log.Error(err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+}
+
+// parseContentTypes reads the content types of the multi content type endpoints
+func parseContentTypes(params url.Values) ([]string, error) {
+	contentTypes := params[contentTypePathVar]
+	if len(contentTypes) == 0 {
+		return nil, errors.New("at least one contentType param is required")
+	}
+	for _, contentType := range contentTypes {
+		if !isValidContentType(contentType) {
+			return nil, fmt.Errorf("invalid content type %s", contentType)
+		}
+	}
+	return contentTypes, nil
+}
+
+// parseTransactionsQuery reads the /transactions params, apart from the content type
+func parseTransactionsQuery(params url.Values) (monitoringQuery, error) {
+	uuids := params[uuidPathVar]
+	earliestTime := params.Get(earliestTimePathVar)
+	latestTime := params.Get(latestTimePathVar)
+	cursorValue := params.Get(cursorPathVar)
+	services := params[servicePathVar]
+	levels := params[levelPathVar]
+	events := params[eventPathVar]
+	isValid := params.Get(isValidPathVar)
+
 	for _, uuid := range uuids {
 		if !isValidUUID(uuid) {
-			log.Errorf("Invalid UUID %s", uuid)
-			writer.WriteHeader(http.StatusBadRequest)
-			return
+			return monitoringQuery{}, fmt.Errorf("invalid UUID %s", uuid)
 		}
 	}
 
 	if earliestTime != "" && !isValidTimePeriod(earliestTime) {
-		log.Errorf("Invalid earliest time parameter %s", earliestTime)
-		writer.WriteHeader(http.StatusBadRequest)
-		return
+		return monitoringQuery{}, fmt.Errorf("invalid earliest time parameter %s", earliestTime)
 	}
 
 	if latestTime != "" && !isValidTimePeriod(latestTime) {
-		log.Errorf("Invalid latest time parameter %s", latestTime)
-		writer.WriteHeader(http.StatusBadRequest)
-		return
+		return monitoringQuery{}, fmt.Errorf("invalid latest time parameter %s", latestTime)
+	}
+
+	for _, value := range append(append(services, levels...), events...) {
+		if !isValidFieldValue(value) {
+			return monitoringQuery{}, fmt.Errorf("invalid filter value %s", value)
+		}
+	}
+
+	if isValid != "" && isValid != "true" && isValid != "false" {
+		return monitoringQuery{}, fmt.Errorf("invalid isValid parameter %s", isValid)
 	}
 
-	query := monitoringQuery{ContentType: contentType, UUIDs: uuids}
+	query := monitoringQuery{UUIDs: uuids, Services: services, Levels: levels, Events: events, IsValid: isValid}
 	if earliestTime != "" {
 		query.EarliestTime = earliestTime
 	}
 	if latestTime != "" {
 		query.LatestTime = latestTime
 	}
-	transactions, err := handler.splunkService.GetTransactions(query)
+	if cursorValue != "" {
+		cursor, err := decodeCursor(cursorValue)
+		if err != nil {
+			return monitoringQuery{}, fmt.Errorf("invalid cursor %s", cursorValue)
+		}
+		query.Cursor = cursor
+	}
+	return query, nil
+}
+
+// parseEventsQuery reads the /events params, apart from the content type; the limit is 0 if the single last event is requested
+func parseEventsQuery(params url.Values) (monitoringQuery, int, error) {
+	earliestTime := params.Get(earliestTimePathVar)
+	lastEvent := params.Get(lastEventPathVar)
+	limitValue := params.Get(limitPathVar)
+	events := params[eventPathVar]
+	services := params[servicePathVar]
+	levels := params[levelPathVar]
+
+	// a limit returns a list of events, otherwise the single last event is returned for existing lastEvent=true consumers
+	if (limitValue == "" || lastEvent != "") && !isValidLastEventFlag(lastEvent) {
+		return monitoringQuery{}, 0, fmt.Errorf("lastEvent param must be true for the /events endpoint, value is %s", lastEvent)
+	}
+
+	limit := 0
+	if limitValue != "" {
+		var err error
+		limit, err = strconv.Atoi(limitValue)
+		if err != nil || limit < 1 || limit > maxEventsLimit {
+			return monitoringQuery{}, 0, fmt.Errorf("invalid limit %s", limitValue)
+		}
+	}
+
+	if earliestTime != "" && !isValidTimePeriod(earliestTime) {
+		return monitoringQuery{}, 0, fmt.Errorf("invalid interval %s", earliestTime)
+	}
 
+	for _, value := range append(append(events, services...), levels...) {
+		if !isValidFieldValue(value) {
+			return monitoringQuery{}, 0, fmt.Errorf("invalid filter value %s", value)
+		}
+	}
+
+	query := monitoringQuery{Events: events, Services: services, Levels: levels}
+	if earliestTime != "" {
+		query.EarliestTime = earliestTime
+	}
+	return query, limit, nil
+}
+
+func (handler *requestHandler) getErrors(writer http.ResponseWriter, request *http.Request) {
+
+	log := handler.log
+
+	defer request.Body.Close()
+
+	contentType := mux.Vars(request)[contentTypePathVar]
+	earliestTime := request.URL.Query().Get(earliestTimePathVar)
+	latestTime := request.URL.Query().Get(latestTimePathVar)
+
+	if !isValidContentType(contentType) {
+		handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Invalid content type %s", contentType))
+		return
+	}
+
+	if earliestTime != "" && !isValidTimePeriod(earliestTime) {
+		handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Invalid earliest time parameter %s", earliestTime))
+		return
+	}
+
+	if latestTime != "" && !isValidTimePeriod(latestTime) {
+		handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Invalid latest time parameter %s", latestTime))
+		return
+	}
+
+	reports, metadata, err := handler.eventReader.GetErrors(monitoringQuery{ContentType: contentType, EarliestTime: earliestTime, LatestTime: latestTime})
+	if err != nil {
+		handler.writeError(writer, backendErrorStatus(err), err)
+		return
+	}
+	writeWarnings(writer, metadata)
+
+	msg, err := json.Marshal(reports)
 	if err != nil {
 		log.Error(err)
 		writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	msg, err := json.Marshal(transactions)
+	if _, err = writer.Write([]byte(msg)); err != nil {
+		log.Error(err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (handler *requestHandler) getThroughput(writer http.ResponseWriter, request *http.Request) {
+
+	log := handler.log
+
+	defer request.Body.Close()
+
+	contentType := mux.Vars(request)[contentTypePathVar]
+	earliestTime := request.URL.Query().Get(earliestTimePathVar)
+	latestTime := request.URL.Query().Get(latestTimePathVar)
+	span := request.URL.Query().Get(spanPathVar)
+
+	if !isValidContentType(contentType) {
+		handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Invalid content type %s", contentType))
+		return
+	}
+
+	if earliestTime != "" && !isValidTimePeriod(earliestTime) {
+		handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Invalid earliest time parameter %s", earliestTime))
+		return
+	}
+
+	if latestTime != "" && !isValidTimePeriod(latestTime) {
+		handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Invalid latest time parameter %s", latestTime))
+		return
+	}
+
+	if span == "" {
+		span = defaultThroughputSpan
+	} else if !spanRegex.MatchString(span) {
+		handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Invalid span %s", span))
+		return
+	}
+
+	series, metadata, err := handler.eventReader.GetThroughput(monitoringQuery{ContentType: contentType, EarliestTime: earliestTime, LatestTime: latestTime}, span)
+	if err != nil {
+		handler.writeError(writer, backendErrorStatus(err), err)
+		return
+	}
+	writeWarnings(writer, metadata)
+
+	msg, err := json.Marshal(series)
 	if err != nil {
 		log.Error(err)
 		writer.WriteHeader(http.StatusInternalServerError)
@@ -95,54 +558,139 @@ func (handler *requestHandler) getTransactions(writer http.ResponseWriter, reque
 		writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-
 }
 
-func (handler *requestHandler) getLastEvent(writer http.ResponseWriter, request *http.Request) {
+func (handler *requestHandler) streamEvents(writer http.ResponseWriter, request *http.Request) {
 
 	log := handler.log
 
 	defer request.Body.Close()
 
 	contentType := mux.Vars(request)[contentTypePathVar]
-	earliestTime := request.URL.Query().Get(earliestTimePathVar)
-	lastEvent := request.URL.Query().Get(lastEventPathVar)
+	uuids := request.URL.Query()[uuidPathVar]
+	services := request.URL.Query()[servicePathVar]
+	events := request.URL.Query()[eventPathVar]
 
 	if !isValidContentType(contentType) {
-		log.Errorf("Invalid content type %s", contentType)
-		writer.WriteHeader(http.StatusBadRequest)
+		handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Invalid content type %s", contentType))
 		return
 	}
 
-	if !isValidLastEventFlag(lastEvent) {
-		log.Errorf("lastEvent param must be true for the /events endpoint, value is %s", lastEvent)
-		writer.WriteHeader(http.StatusBadRequest)
+	for _, uuid := range uuids {
+		if !isValidUUID(uuid) {
+			handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Invalid UUID %s", uuid))
+			return
+		}
+	}
+
+	for _, value := range append(services, events...) {
+		if !isValidFieldValue(value) {
+			handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Invalid filter value %s", value))
+			return
+		}
+	}
+
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		log.Error("Streaming is not supported by the response writer")
+		writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	if earliestTime != "" && !isValidTimePeriod(earliestTime) {
-		log.Errorf("Invalid interval %s", earliestTime)
-		writer.WriteHeader(http.StatusBadRequest)
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	query := monitoringQuery{ContentType: contentType, UUIDs: uuids, Services: services, Events: events}
+	err := handler.eventReader.StreamEvents(request.Context(), query, func(event publishEvent) error {
+		msg, err := json.Marshal(versionedData(request, event))
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(writer, "data: %s\n\n", msg); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}, func(warning string) {
+		handler.writeStreamEvent(writer, "warning", model.ErrorResponse{Message: warning})
+		flusher.Flush()
+	})
+
+	if err != nil && request.Context().Err() == nil {
+		log.WithError(err).Errorf("Streaming the %s events failed", contentType)
+		handler.writeStreamError(writer, backendErrorStatus(err))
+		flusher.Flush()
+	}
+}
+
+// writeStreamError sends an error event closing the stream; its data only tells the class of the failure, the
+// details of the backend error being logged
+func (handler *requestHandler) writeStreamError(writer http.ResponseWriter, status int) {
+	handler.writeStreamEvent(writer, "error", model.ErrorResponse{Message: backendErrorMessage(status)})
+}
+
+// writeStreamEvent sends a named event, whose data is the message
+func (handler *requestHandler) writeStreamEvent(writer http.ResponseWriter, event string, data model.ErrorResponse) {
+	msg, err := json.Marshal(data)
+	if err != nil {
+		handler.log.Error(err)
 		return
 	}
+	if _, err = fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", event, msg); err != nil {
+		handler.log.Error(err)
+	}
+}
 
-	query := monitoringQuery{ContentType: contentType}
-	if earliestTime != "" {
-		query.EarliestTime = earliestTime
+func (handler *requestHandler) awaitPublish(writer http.ResponseWriter, request *http.Request) {
+
+	log := handler.log
+	start := time.Now()
+
+	defer request.Body.Close()
+
+	contentType := mux.Vars(request)[contentTypePathVar]
+	uuid := mux.Vars(request)[uuidPathVar]
+	timeoutValue := request.URL.Query().Get(timeoutPathVar)
+
+	if !isValidContentType(contentType) {
+		handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Invalid content type %s", contentType))
+		return
 	}
-	publishEvent, err := handler.splunkService.GetLastEvent(query)
 
+	if !isValidUUID(uuid) {
+		handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Invalid UUID %s", uuid))
+		return
+	}
+
+	timeout := defaultAwaitTimeout
+	if timeoutValue != "" {
+		var err error
+		timeout, err = time.ParseDuration(timeoutValue)
+		if err != nil || timeout <= 0 || timeout > maxAwaitTimeout {
+			handler.writeError(writer, http.StatusBadRequest, fmt.Errorf("Invalid timeout %s", timeoutValue))
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(request.Context(), timeout)
+	defer cancel()
+
+	transaction, err := handler.awaitPublishEnd(ctx, contentType, uuid, start)
 	if err != nil {
-		if errors.Is(err, ErrNoResults) {
-			writer.WriteHeader(http.StatusNotFound)
-		} else {
-			log.Error(err)
-			writer.WriteHeader(http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			writer.WriteHeader(http.StatusRequestTimeout)
+		case errors.Is(err, context.Canceled):
+		default:
+			handler.writeError(writer, backendErrorStatus(err), err)
 		}
 		return
 	}
 
-	msg, err := json.Marshal(*publishEvent)
+	msg, err := marshalData(writer, request, transaction)
 	if err != nil {
 		log.Error(err)
 		writer.WriteHeader(http.StatusInternalServerError)
@@ -154,13 +702,173 @@ func (handler *requestHandler) getLastEvent(writer http.ResponseWriter, request
 		writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+}
+
+// awaitPublishEnd polls Splunk until a PublishEnd event of the content is logged after the start time, and returns its transaction
+func (handler *requestHandler) awaitPublishEnd(ctx context.Context, contentType string, uuid string, start time.Time) (*transactionEvent, error) {
+	query := monitoringQuery{ContentType: contentType, UUIDs: []string{uuid}, EarliestTime: strconv.FormatInt(start.Unix(), 10)}
+	for {
+		event, _, err := handler.eventReader.GetLastEvent(query)
+		if err != nil && !errors.Is(err, ErrNoResults) {
+			return nil, err
+		}
+
+		if event != nil {
+			// the transaction may have started before the call
+			transactions, err := handler.eventReader.GetTransactions(monitoringQuery{
+				ContentType:   contentType,
+				UUIDs:         []string{uuid},
+				EarliestTime:  strconv.FormatInt(start.Add(-defaultCursorLookback).Unix(), 10),
+				IncludeClosed: true,
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, transaction := range transactions {
+				if transaction.TransactionID == event.TransactionID {
+					return &transaction, nil
+				}
+			}
+			return nil, fmt.Errorf("transaction %s of PublishEnd event for %s not found", event.TransactionID, uuid)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(awaitPollInterval):
+		}
+	}
+}
+
+func (handler *requestHandler) getTransactionsFromSnapshotOrSplunk(query monitoringQuery) ([]transactionEvent, searchMetadata, string, error) {
+	cache := cacheNone
+	if poller, found := handler.pollers[query.ContentType]; found {
+		if transactions, metadata, ok := poller.transactions(query); ok {
+			return transactions, metadata, cacheHit, nil
+		}
+		cache = cacheMiss
+	}
+	transactions, metadata, err := handler.eventReader.GetTransactionsWithMetadata(query)
+	return transactions, metadata, cache, err
+}
+
+// marshalResponse reports the Splunk warnings in headers, and wraps the data in an envelope if the client asked for it
+// with the envelope param or the Accept header
+func marshalResponse(writer http.ResponseWriter, request *http.Request, data interface{}, count int, metadata searchMetadata, cache string) ([]byte, error) {
+	writeWarnings(writer, metadata)
+	if request.URL.Query().Get(envelopePathVar) != "true" && !strings.Contains(request.Header.Get("Accept"), envelopeMediaType) {
+		return marshalData(writer, request, data)
+	}
+	writer.Header().Set("Content-Type", envelopeMediaType)
+	return json.Marshal(responseEnvelope{Data: versionedData(request, data), Count: count, Cache: cache, searchMetadata: metadata})
+}
+
+// writeWarnings adds a warning header for each warning of the searches, on a single line
+func writeWarnings(writer http.ResponseWriter, metadata searchMetadata) {
+	for _, warning := range metadata.Warnings {
+		writer.Header().Add(warningHeader, strings.Join(strings.Fields(warning), " "))
+	}
+}
+
+// marshalData returns the response body of the data, in the v2 model if requested
+func marshalData(writer http.ResponseWriter, request *http.Request, data interface{}) ([]byte, error) {
+	if isV2Request(request) {
+		writer.Header().Set("Content-Type", v2MediaType)
+	}
+	return json.Marshal(versionedData(request, data))
+}
+
+// isV2Request tells whether the client asks for the v2 model, with parsed times and booleans, rather than the legacy one
+func isV2Request(request *http.Request) bool {
+	return request.URL.Query().Get(versionPathVar) == "2" || strings.Contains(request.Header.Get("Accept"), v2MediaType)
+}
+
+// versionedData converts the events and transactions of a response to the v2 model if requested
+func versionedData(request *http.Request, data interface{}) interface{} {
+	if !isV2Request(request) {
+		return data
+	}
+	switch value := data.(type) {
+	case publishEvent:
+		return value.V2()
+	case *publishEvent:
+		if value != nil {
+			return value.V2()
+		}
+	case []publishEvent:
+		return model.EventsV2(value)
+	case map[string]publishEvent:
+		events := make(map[string]model.Event, len(value))
+		for contentType, event := range value {
+			events[contentType] = event.V2()
+		}
+		return events
+	case transactionEvent:
+		return value.V2()
+	case *transactionEvent:
+		if value != nil {
+			return value.V2()
+		}
+	case []transactionEvent:
+		return model.TransactionsV2(value)
+	case map[string][]transactionEvent:
+		transactions := make(map[string][]model.Transaction, len(value))
+		for contentType, contentTypeTransactions := range value {
+			transactions[contentType] = model.TransactionsV2(contentTypeTransactions)
+		}
+		return transactions
+	}
+	return data
+}
 
+// writeError logs the error of a failed request, and writes it in the response body for the API clients; only the
+// validation errors are detailed, the body of the other ones telling the class of the failure
+func (handler *requestHandler) writeError(writer http.ResponseWriter, status int, err error) {
+	handler.log.Error(err)
+	message := err.Error()
+	if status != http.StatusBadRequest {
+		message = backendErrorMessage(status)
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	if encodeErr := json.NewEncoder(writer).Encode(model.ErrorResponse{Message: message}); encodeErr != nil {
+		handler.log.Error(encodeErr)
+	}
+}
+
+// backendErrorMessage tells the class of a failed backend call, without the details of the backend error
+func backendErrorMessage(status int) string {
+	return fmt.Sprintf("Reading the events failed: %s", http.StatusText(status))
+}
+
+// backendErrorStatus maps a failed backend call to the response status, by the class of the failure
+func backendErrorStatus(err error) int {
+	var backendErr interface{ httpStatus() int }
+	if errors.As(err, &backendErr) {
+		return backendErr.httpStatus()
+	}
+	return http.StatusInternalServerError
 }
 
 func isValidLastEventFlag(lastEvent string) bool {
 	return lastEvent == "true"
 }
 
+// setContentTypes sets the content types accepted by the endpoints and commands; they are used in the searches,
+// so they are restricted to field value characters
+func setContentTypes(supported []string) error {
+	if len(supported) == 0 {
+		return errors.New("at least one content type is required")
+	}
+	for _, contentType := range supported {
+		if !isValidFieldValue(contentType) {
+			return fmt.Errorf("invalid content type %s", contentType)
+		}
+	}
+	contentTypes = supported
+	return nil
+}
+
 func isValidContentType(contentType string) bool {
 	for _, ct := range contentTypes {
 		if contentType == ct {
@@ -174,6 +882,10 @@ func isValidTimePeriod(interval string) bool {
 	return timePeriodRegex.MatchString(interval)
 }
 
+func isValidFieldValue(value string) bool {
+	return fieldValueRegex.MatchString(value)
+}
+
 func isValidUUID(id string) bool {
 	_, err := uuid.Parse(id)
 	return err == nil
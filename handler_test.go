@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/splunk-event-reader/client"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRouter(eventReader EventReader) *mux.Router {
+	rh := requestHandler{eventReader: eventReader, log: logger.NewUPPLogger("test", "INFO")}
+	router := mux.NewRouter()
+	router.HandleFunc("/transactions", rh.getTransactionsByContentType).Methods("GET")
+	router.HandleFunc("/events", rh.getLastEventByContentType).Methods("GET")
+	router.HandleFunc("/{contentType}/transactions", rh.getTransactions).Methods("GET")
+	router.HandleFunc("/{contentType}/events", rh.getLastEvent).Methods("GET")
+	router.HandleFunc("/{contentType}/errors", rh.getErrors).Methods("GET")
+	router.HandleFunc("/{contentType}/transactions/search", rh.searchTransactions).Methods("POST")
+	router.HandleFunc("/{contentType}/stats/throughput", rh.getThroughput).Methods("GET")
+	router.HandleFunc("/{contentType}/events/stream", rh.streamEvents).Methods("GET")
+	router.HandleFunc("/{contentType}/content/{uuid}/await", rh.awaitPublish).Methods("GET")
+	return router
+}
+
+func TestRequestHandler_StreamEvents(t *testing.T) {
+	splunk := &mockEventReader{events: []publishEvent{
+		{ContentType: "Annotations", Event: "PublishStart", TransactionID: "tid_test", UUID: "27355ee6-e280-4fb8-b825-8f14be1be9d3"},
+		{ContentType: "Annotations", Event: "PublishEnd", TransactionID: "tid_test", UUID: "27355ee6-e280-4fb8-b825-8f14be1be9d3"},
+	}}
+
+	req := httptest.NewRequest("GET", "/annotations/events/stream?uuid=27355ee6-e280-4fb8-b825-8f14be1be9d3&service=annotations-rw-neo4j&event=PublishEnd", nil)
+	w := httptest.NewRecorder()
+	newTestRouter(splunk).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, `data: {"content_type":"Annotations","event":"PublishStart","level":"","service_name":"","@time":"","transaction_id":"tid_test","uuid":"27355ee6-e280-4fb8-b825-8f14be1be9d3"}`+"\n\n"+
+		`data: {"content_type":"Annotations","event":"PublishEnd","level":"","service_name":"","@time":"","transaction_id":"tid_test","uuid":"27355ee6-e280-4fb8-b825-8f14be1be9d3"}`+"\n\n", w.Body.String())
+	assert.Equal(t, []monitoringQuery{{
+		ContentType: "annotations",
+		UUIDs:       []string{"27355ee6-e280-4fb8-b825-8f14be1be9d3"},
+		Services:    []string{"annotations-rw-neo4j"},
+		Events:      []string{"PublishEnd"},
+	}}, splunk.queries)
+}
+
+func TestRequestHandler_StreamEventsError(t *testing.T) {
+	splunk := &mockEventReader{
+		events: []publishEvent{{ContentType: "Annotations", Event: "PublishStart", TransactionID: "tid_test"}},
+		err:    &SplunkError{Class: classQuota, message: "Splunk search failed:\nsid=1234 search quota exceeded"},
+	}
+
+	req := httptest.NewRequest("GET", "/annotations/events/stream", nil)
+	w := httptest.NewRecorder()
+	newTestRouter(splunk).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `data: {"content_type":"Annotations","event":"PublishStart","level":"","service_name":"","@time":"","transaction_id":"tid_test","uuid":""}`+"\n\n"+
+		"event: error\n"+`data: {"message":"Reading the events failed: Service Unavailable"}`+"\n\n", w.Body.String())
+	assert.NotContains(t, w.Body.String(), "sid=1234", "the backend error details are only logged")
+
+	splunk = &mockEventReader{err: errors.New("unexpected end of JSON input")}
+	w = httptest.NewRecorder()
+	newTestRouter(splunk).ServeHTTP(w, httptest.NewRequest("GET", "/annotations/events/stream", nil))
+	assert.Equal(t, "event: error\n"+`data: {"message":"Reading the events failed: Internal Server Error"}`+"\n\n", w.Body.String())
+}
+
+func TestRequestHandler_StreamEventsWarning(t *testing.T) {
+	splunk := &mockEventReader{
+		events:   []publishEvent{{ContentType: "Annotations", Event: "PublishStart", TransactionID: "tid_test"}},
+		metadata: searchMetadata{Warnings: []string{"Results of region us are missing: Splunk request failed"}},
+	}
+
+	w := httptest.NewRecorder()
+	newTestRouter(splunk).ServeHTTP(w, httptest.NewRequest("GET", "/annotations/events/stream", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `data: {"content_type":"Annotations","event":"PublishStart","level":"","service_name":"","@time":"","transaction_id":"tid_test","uuid":""}`+"\n\n"+
+		"event: warning\n"+`data: {"message":"Results of region us are missing: Splunk request failed"}`+"\n\n", w.Body.String())
+}
+
+func TestRequestHandler_StreamEventsInvalidParams(t *testing.T) {
+	urls := []string{
+		"/INVALID_CONTENT_TYPE/events/stream",
+		"/annotations/events/stream?uuid=INVALID_UUID",
+		`/annotations/events/stream?service=x"+OR+index=*`,
+	}
+
+	for _, url := range urls {
+		req := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		newTestRouter(&mockEventReader{}).ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, url)
+	}
+}
+
+func TestRequestHandler_AwaitPublish(t *testing.T) {
+	awaitPollInterval = time.Millisecond
+
+	tests := []struct {
+		url            string
+		splunk         *mockEventReader
+		expectedStatus int
+	}{
+		{
+			url: "/annotations/content/27355ee6-e280-4fb8-b825-8f14be1be9d3/await",
+			splunk: &mockEventReader{
+				lastEvent: &publishEvent{Event: "PublishEnd", TransactionID: "tid_2"},
+				transactions: []transactionEvent{
+					{TransactionID: "tid_1", ClosedTxn: "0"},
+					{TransactionID: "tid_2", ClosedTxn: "1"},
+				},
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{url: "/annotations/content/27355ee6-e280-4fb8-b825-8f14be1be9d3/await?timeout=10ms", splunk: &mockEventReader{err: ErrNoResults}, expectedStatus: http.StatusRequestTimeout},
+		{url: "/annotations/content/27355ee6-e280-4fb8-b825-8f14be1be9d3/await?timeout=1h", splunk: &mockEventReader{}, expectedStatus: http.StatusBadRequest},
+		{url: "/annotations/content/INVALID_UUID/await", splunk: &mockEventReader{}, expectedStatus: http.StatusBadRequest},
+		{url: "/INVALID_CONTENT_TYPE/content/27355ee6-e280-4fb8-b825-8f14be1be9d3/await", splunk: &mockEventReader{}, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.url, nil)
+		w := httptest.NewRecorder()
+		newTestRouter(test.splunk).ServeHTTP(w, req)
+		assert.Equal(t, test.expectedStatus, w.Code, test.url)
+
+		if test.expectedStatus == http.StatusOK {
+			tx := transactionEvent{}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &tx))
+			assert.Equal(t, "tid_2", tx.TransactionID)
+			assert.Equal(t, []string{"27355ee6-e280-4fb8-b825-8f14be1be9d3"}, test.splunk.queries[0].UUIDs)
+			assert.True(t, test.splunk.queries[1].IncludeClosed)
+		}
+	}
+}
+
+func TestRequestHandler_GetLastEvents(t *testing.T) {
+	events := []publishEvent{
+		{Event: "Ingest", ServiceName: "native-ingester-metadata", TransactionID: "tid_2"},
+		{Event: "Ingest", ServiceName: "native-ingester-metadata", TransactionID: "tid_1"},
+	}
+
+	tests := []struct {
+		url            string
+		expectedStatus int
+		expectedQuery  monitoringQuery
+	}{
+		{url: "/annotations/events?event=Ingest&limit=5&service=native-ingester-metadata&level=info", expectedStatus: http.StatusOK,
+			expectedQuery: monitoringQuery{ContentType: "annotations", Events: []string{"Ingest"}, Services: []string{"native-ingester-metadata"}, Levels: []string{"info"}}},
+		{url: "/annotations/events?lastEvent=true&limit=5&earliestTime=-1h", expectedStatus: http.StatusOK,
+			expectedQuery: monitoringQuery{ContentType: "annotations", EarliestTime: "-1h"}},
+		{url: "/annotations/events?lastEvent=false&limit=5", expectedStatus: http.StatusBadRequest},
+		{url: "/annotations/events?limit=0", expectedStatus: http.StatusBadRequest},
+		{url: "/annotations/events?limit=1001", expectedStatus: http.StatusBadRequest},
+		{url: "/annotations/events?limit=INVALID", expectedStatus: http.StatusBadRequest},
+		{url: "/annotations/events?event=Ingest", expectedStatus: http.StatusBadRequest},
+		{url: "/annotations/events?limit=5&level=error+OR+true", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		splunk := &mockEventReader{events: events}
+		req := httptest.NewRequest("GET", test.url, nil)
+		w := httptest.NewRecorder()
+		newTestRouter(splunk).ServeHTTP(w, req)
+		assert.Equal(t, test.expectedStatus, w.Code, test.url)
+
+		if test.expectedStatus == http.StatusOK {
+			result := []publishEvent{}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+			assert.Equal(t, events, result)
+			assert.Equal(t, []monitoringQuery{test.expectedQuery}, splunk.queries)
+		}
+	}
+}
+
+func TestRequestHandler_GetThroughput(t *testing.T) {
+	series := []timeSeries{{Target: "started", Datapoints: [][2]float64{{3, 1505829600000}}}}
+
+	tests := []struct {
+		url            string
+		expectedStatus int
+	}{
+		{url: "/annotations/stats/throughput?earliestTime=-24h&span=15m", expectedStatus: http.StatusOK},
+		{url: "/annotations/stats/throughput", expectedStatus: http.StatusOK},
+		{url: "/annotations/stats/throughput?span=0m", expectedStatus: http.StatusBadRequest},
+		{url: "/annotations/stats/throughput?span=15m+|+delete", expectedStatus: http.StatusBadRequest},
+		{url: "/annotations/stats/throughput?earliestTime=-1year", expectedStatus: http.StatusBadRequest},
+		{url: "/INVALID_CONTENT_TYPE/stats/throughput", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.url, nil)
+		w := httptest.NewRecorder()
+		newTestRouter(&mockEventReader{series: series}).ServeHTTP(w, req)
+		assert.Equal(t, test.expectedStatus, w.Code, test.url)
+
+		if test.expectedStatus == http.StatusOK {
+			result := []timeSeries{}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+			assert.Equal(t, series, result)
+		}
+	}
+}
+
+func TestRequestHandler_MultipleContentTypes(t *testing.T) {
+	assert.NoError(t, setContentTypes([]string{"annotations", "article", "list"}))
+	defer setContentTypes(defaultContentTypes)
+	splunk := &mockEventReader{
+		transactions: []transactionEvent{{TransactionID: "tid_1", ClosedTxn: "0"}},
+		lastEvent:    &publishEvent{Event: "PublishEnd", TransactionID: "tid_2"},
+	}
+
+	tests := []struct {
+		url            string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{url: "/transactions?contentType=annotations&earliestTime=-30m", expectedStatus: http.StatusOK,
+			expectedBody: `{"annotations":[{"transaction_id":"tid_1","uuid":"","closed_txn":"0","eventcount":0,"events":null,"start_time":""}]}`},
+		{url: "/events?contentType=annotations&lastEvent=true", expectedStatus: http.StatusOK,
+			expectedBody: `{"annotations":{"content_type":"","event":"PublishEnd","level":"","service_name":"","@time":"","transaction_id":"tid_2","uuid":""}}`},
+		{url: "/transactions?contentType=annotations&contentType=article", expectedStatus: http.StatusOK,
+			expectedBody: `{"annotations":[{"transaction_id":"tid_1","uuid":"","closed_txn":"0","eventcount":0,"events":null,"start_time":""}],"article":[{"transaction_id":"tid_1","uuid":"","closed_txn":"0","eventcount":0,"events":null,"start_time":""}]}`},
+		{url: "/events?contentType=article&contentType=list&lastEvent=true", expectedStatus: http.StatusOK,
+			expectedBody: `{"article":{"content_type":"","event":"PublishEnd","level":"","service_name":"","@time":"","transaction_id":"tid_2","uuid":""},"list":{"content_type":"","event":"PublishEnd","level":"","service_name":"","@time":"","transaction_id":"tid_2","uuid":""}}`},
+		{url: "/transactions", expectedStatus: http.StatusBadRequest},
+		{url: "/transactions?contentType=annotations&contentType=INVALID_CONTENT_TYPE", expectedStatus: http.StatusBadRequest},
+		{url: "/transactions?contentType=annotations&uuid=INVALID_UUID", expectedStatus: http.StatusBadRequest},
+		{url: "/events?contentType=annotations", expectedStatus: http.StatusBadRequest},
+		{url: "/events?contentType=annotations&limit=5", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.url, nil)
+		w := httptest.NewRecorder()
+		newTestRouter(splunk).ServeHTTP(w, req)
+		assert.Equal(t, test.expectedStatus, w.Code, test.url)
+		if test.expectedStatus == http.StatusOK {
+			assert.JSONEq(t, test.expectedBody, w.Body.String())
+		}
+	}
+}
+
+func TestRequestHandler_SearchTransactions(t *testing.T) {
+	tests := []struct {
+		url            string
+		body           string
+		expectedStatus int
+		expectedQuery  monitoringQuery
+	}{
+		{url: "/annotations/transactions/search", body: `{"uuids":["27355ee6-e280-4fb8-b825-8f14be1be9d3"],"earliestTime":"-1h","services":["nativerw"],"isValid":"true"}`, expectedStatus: http.StatusOK,
+			expectedQuery: monitoringQuery{ContentType: "annotations", UUIDs: []string{"27355ee6-e280-4fb8-b825-8f14be1be9d3"}, EarliestTime: "-1h", Services: []string{"nativerw"}, IsValid: "true"}},
+		{url: "/annotations/transactions/search", body: `{"uuids":["INVALID_UUID"]}`, expectedStatus: http.StatusBadRequest},
+		{url: "/annotations/transactions/search", body: `{"uuids":[]}`, expectedStatus: http.StatusBadRequest},
+		{url: "/annotations/transactions/search", body: `{"uuids":["27355ee6-e280-4fb8-b825-8f14be1be9d3"],"earliestTime":"-1year"}`, expectedStatus: http.StatusBadRequest},
+		{url: "/annotations/transactions/search", body: `INVALID`, expectedStatus: http.StatusBadRequest},
+		{url: "/INVALID_CONTENT_TYPE/transactions/search", body: `{"uuids":["27355ee6-e280-4fb8-b825-8f14be1be9d3"]}`, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		splunk := &mockEventReader{transactions: []transactionEvent{{TransactionID: "tid_1", ClosedTxn: "0"}}}
+		req := httptest.NewRequest("POST", test.url, strings.NewReader(test.body))
+		w := httptest.NewRecorder()
+		newTestRouter(splunk).ServeHTTP(w, req)
+		assert.Equal(t, test.expectedStatus, w.Code, test.body)
+
+		if test.expectedStatus == http.StatusOK {
+			assert.Equal(t, []monitoringQuery{test.expectedQuery}, splunk.queries)
+			assert.JSONEq(t, `[{"transaction_id":"tid_1","uuid":"","closed_txn":"0","eventcount":0,"events":null,"start_time":""}]`, w.Body.String())
+		}
+	}
+}
+
+func TestRequestHandler_Warnings(t *testing.T) {
+	metadata := searchMetadata{Warnings: []string{"Search results might be incomplete:\nthe search process on peer idx-1 ended prematurely."}}
+
+	tests := []struct {
+		url            string
+		reader         *mockEventReader
+		expectedStatus int
+	}{
+		{url: "/annotations/transactions", reader: &mockEventReader{}, expectedStatus: http.StatusOK},
+		{url: "/transactions?contentType=annotations", reader: &mockEventReader{}, expectedStatus: http.StatusOK},
+		{url: "/annotations/events?lastEvent=true", reader: &mockEventReader{lastEvent: &publishEvent{TransactionID: "tid_1"}}, expectedStatus: http.StatusOK},
+		{url: "/annotations/events?lastEvent=true", reader: &mockEventReader{err: ErrNoResults}, expectedStatus: http.StatusNotFound},
+		{url: "/annotations/events?limit=5", reader: &mockEventReader{}, expectedStatus: http.StatusOK},
+		{url: "/events?contentType=annotations&lastEvent=true", reader: &mockEventReader{}, expectedStatus: http.StatusOK},
+		{url: "/annotations/errors", reader: &mockEventReader{}, expectedStatus: http.StatusOK},
+		{url: "/annotations/stats/throughput", reader: &mockEventReader{}, expectedStatus: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		test.reader.metadata = metadata
+		req := httptest.NewRequest("GET", test.url, nil)
+		w := httptest.NewRecorder()
+		newTestRouter(test.reader).ServeHTTP(w, req)
+		assert.Equal(t, test.expectedStatus, w.Code, test.url)
+		assert.Equal(t, []string{"Search results might be incomplete: the search process on peer idx-1 ended prematurely."}, w.Header().Values(warningHeader), test.url)
+	}
+}
+
+func TestRequestHandler_SplunkErrorStatus(t *testing.T) {
+	tests := []struct {
+		err            error
+		expectedStatus int
+	}{
+		{&SplunkError{Class: classAuth, message: "Splunk request failed: 401 Unauthorized for user admin"}, http.StatusBadGateway},
+		{newTransportError(errors.New("connection refused")), http.StatusBadGateway},
+		{&SplunkError{Class: classQuota}, http.StatusServiceUnavailable},
+		{&SplunkError{Class: classTimeout}, http.StatusGatewayTimeout},
+		{&SplunkError{Class: classSyntax}, http.StatusInternalServerError},
+		{errors.New("unclassified"), http.StatusInternalServerError},
+	}
+
+	for _, url := range []string{"/annotations/transactions", "/annotations/events?lastEvent=true", "/annotations/errors"} {
+		for _, test := range tests {
+			req := httptest.NewRequest("GET", url, nil)
+			w := httptest.NewRecorder()
+			newTestRouter(&mockEventReader{err: test.err}).ServeHTTP(w, req)
+			assert.Equal(t, test.expectedStatus, w.Code, "%s %v", url, test.err)
+			assert.JSONEq(t, fmt.Sprintf(`{"message":"Reading the events failed: %s"}`, http.StatusText(test.expectedStatus)), w.Body.String(), "%s %v", url, test.err)
+		}
+	}
+}
+
+func TestRequestHandler_ErrorBody(t *testing.T) {
+	req := httptest.NewRequest("GET", "/annotations/transactions?earliestTime=yesterday", nil)
+	w := httptest.NewRecorder()
+	newTestRouter(&mockEventReader{}).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"message":"invalid earliest time parameter yesterday"}`, w.Body.String())
+
+	req = httptest.NewRequest("GET", "/INVALID_CONTENT_TYPE/transactions", nil)
+	w = httptest.NewRecorder()
+	newTestRouter(&mockEventReader{}).ServeHTTP(w, req)
+	assert.JSONEq(t, `{"message":"Invalid content type INVALID_CONTENT_TYPE"}`, w.Body.String())
+}
+
+func TestRequestHandler_Client(t *testing.T) {
+	eventReader := &mockEventReader{transactions: []transactionEvent{{TransactionID: "tid_1", UUID: "27355ee6-e280-4fb8-b825-8f14be1be9d3", ClosedTxn: "0", EventCount: 1}}}
+	server := httptest.NewServer(newTestRouter(eventReader))
+	defer server.Close()
+	apiClient := client.New(server.URL, client.WithRetries(1, 0))
+
+	transactions, err := apiClient.Transactions(context.Background(), "annotations", client.TransactionsOptions{TimeRange: client.TimeRange{Earliest: "-30m"}, UUIDs: []string{"27355ee6-e280-4fb8-b825-8f14be1be9d3"}})
+	assert.NoError(t, err)
+	assert.Equal(t, eventReader.transactions, transactions)
+	assert.Equal(t, "-30m", eventReader.queries[0].EarliestTime)
+
+	_, err = apiClient.Transactions(context.Background(), "annotations", client.TransactionsOptions{UUIDs: []string{"INVALID_UUID"}})
+	assert.EqualError(t, err, "splunk-event-reader responded with status 400: invalid UUID INVALID_UUID")
+
+	eventReader.err = ErrNoResults
+	_, err = apiClient.LastEvent(context.Background(), "annotations", client.EventsOptions{})
+	assert.True(t, errors.Is(err, client.ErrNotFound))
+}
+
+func TestRequestHandler_V2Model(t *testing.T) {
+	eventReader := &mockEventReader{
+		transactions: []transactionEvent{{TransactionID: "tid_1", UUID: "uuid_1", ClosedTxn: "0", EventCount: 1, StartTime: "2017-09-19T14:00:02Z", Events: []publishEvent{{Event: "PublishStart", IsValid: "true", Time: "2017-09-19T14:00:02Z", TransactionID: "tid_1"}}}},
+		lastEvent:    &publishEvent{Event: "PublishEnd", Time: "2017-09-19T14:00:03Z", IndexTime: "1505829604", TransactionID: "tid_1"},
+	}
+	v2Transactions := `[{"transaction_id":"tid_1","uuid":"uuid_1","closed":false,"event_count":1,"start_time":"2017-09-19T14:00:02Z","events":[{"content_type":"","event":"PublishStart","valid":true,"level":"","service_name":"","time":"2017-09-19T14:00:02Z","transaction_id":"tid_1","uuid":""}]}]`
+
+	tests := []struct {
+		url          string
+		accept       string
+		expectedType string
+		expectedBody string
+	}{
+		{url: "/annotations/transactions", expectedBody: `[{"transaction_id":"tid_1","uuid":"uuid_1","closed_txn":"0","eventcount":1,"start_time":"2017-09-19T14:00:02Z","events":[{"content_type":"","event":"PublishStart","isValid":"true","level":"","service_name":"","@time":"2017-09-19T14:00:02Z","transaction_id":"tid_1","uuid":""}]}]`},
+		{url: "/annotations/transactions?version=2", expectedType: v2MediaType, expectedBody: v2Transactions},
+		{url: "/annotations/transactions", accept: v2MediaType, expectedType: v2MediaType, expectedBody: v2Transactions},
+		{url: "/annotations/transactions?envelope=true&version=2", expectedType: envelopeMediaType, expectedBody: `{"data":` + v2Transactions + `,"count":1,"cache":"none","sids":null,"splunk_duration_seconds":0,"event_count":0,"truncated":false}`},
+		{url: "/transactions?contentType=annotations", accept: v2MediaType, expectedType: v2MediaType, expectedBody: `{"annotations":` + v2Transactions + `}`},
+		{url: "/annotations/events?lastEvent=true&version=2", expectedType: v2MediaType, expectedBody: `{"content_type":"","event":"PublishEnd","level":"","service_name":"","time":"2017-09-19T14:00:03Z","index_time":"2017-09-19T14:00:04Z","transaction_id":"tid_1","uuid":""}`},
+		{url: "/annotations/events?lastEvent=true", expectedBody: `{"content_type":"","event":"PublishEnd","level":"","service_name":"","@time":"2017-09-19T14:00:03Z","indextime":"1505829604","transaction_id":"tid_1","uuid":""}`},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.url, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+		newTestRouter(eventReader).ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, test.url)
+		if test.expectedType != "" {
+			assert.Equal(t, test.expectedType, w.Header().Get("Content-Type"), test.url)
+		}
+		assert.JSONEq(t, test.expectedBody, w.Body.String(), test.url)
+	}
+}
+
+func TestSetContentTypes(t *testing.T) {
+	defer setContentTypes(defaultContentTypes)
+
+	assert.True(t, isValidContentType("annotations"))
+	assert.False(t, isValidContentType("article"), "only annotations are accepted by default")
+	assert.NoError(t, setContentTypes([]string{"annotations", "video"}))
+	assert.True(t, isValidContentType("video"))
+	assert.False(t, isValidContentType("article"))
+
+	req := httptest.NewRequest("GET", "/video/transactions", nil)
+	w := httptest.NewRecorder()
+	newTestRouter(&mockEventReader{}).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Error(t, setContentTypes(nil))
+	assert.Error(t, setContentTypes([]string{`video" OR index=*`}))
+	assert.True(t, isValidContentType("video"), "invalid content types are not set")
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -8,19 +12,32 @@ import (
 	"github.com/Financial-Times/service-status-go/gtg"
 )
 
-const healthPath = "/__health"
+const (
+	healthPath                 = "/__health"
+	stalledPipelineCachePeriod = time.Minute
+	// defaultStalledPipelineSeverity is the severity of the stalled pipeline checks configured without one
+	defaultStalledPipelineSeverity = 1
+)
 
 type healthService struct {
 	*sync.Mutex
-	checks []health.Check
-	config healthConfig
-	stop   chan bool
+	checks    []health.Check
+	gtgChecks []health.Check
+	config    healthConfig
+	stop      chan bool
 }
 
 type healthConfig struct {
-	appSystemCode string
-	appName       string
-	port          string
+	appSystemCode             string
+	appName                   string
+	port                      string
+	stalledPipelineThresholds map[string]stalledPipelineThreshold
+}
+
+// stalledPipelineThreshold is the max age of the last PublishEnd event of a content type, and the severity of the check
+type stalledPipelineThreshold struct {
+	maxAge   time.Duration
+	severity uint8
 }
 
 type healthStatus struct {
@@ -31,10 +48,14 @@ type healthStatus struct {
 
 var splunkHealth healthStatus
 
-func newHealthService(config healthConfig, check func() healthStatus) *healthService {
-	service := &healthService{&sync.Mutex{}, nil, config, nil}
-	service.checks = []health.Check{
-		service.splunkCheck(check),
+func newHealthService(config healthConfig, check func() healthStatus, lastEvent func(query monitoringQuery) (*publishEvent, searchMetadata, error)) *healthService {
+	service := &healthService{&sync.Mutex{}, nil, nil, config, nil}
+	splunkCheck := service.splunkCheck(check)
+	service.checks = []health.Check{splunkCheck}
+	// a stalled pipeline is not a problem of this pod, so it should not take it out of the load balancer
+	service.gtgChecks = []health.Check{splunkCheck}
+	for contentType, threshold := range config.stalledPipelineThresholds {
+		service.checks = append(service.checks, service.stalledPipelineCheck(contentType, threshold.maxAge, threshold.severity, lastEvent))
 	}
 	return service
 }
@@ -57,8 +78,95 @@ func (hs *healthService) splunkCheck(check func() healthStatus) health.Check {
 	}
 }
 
+// stalledPipelineCheck fails when the latest PublishEnd event of the content type is older than the threshold; the lock
+// is only held to read and write the cached result, not during the search, so that the other checks are not blocked
+func (hs *healthService) stalledPipelineCheck(contentType string, threshold time.Duration, severity uint8, lastEvent func(query monitoringQuery) (*publishEvent, searchMetadata, error)) health.Check {
+	var lastResult healthStatus
+	return health.Check{
+		ID:               "stalled-pipeline-" + contentType,
+		BusinessImpact:   fmt.Sprintf("Publishing of %s may have silently stopped. Content changes might not reach the readers", contentType),
+		Name:             fmt.Sprintf("Stalled %s publishing pipeline", contentType),
+		PanicGuide:       "https://dewey.ft.com/splunk-event-reader.html",
+		Severity:         severity,
+		TechnicalSummary: fmt.Sprintf("No %s PublishEnd monitoring event has been logged in the last %v. Check the publishing pipeline services and their monitoring events in Splunk.", contentType, threshold),
+		Checker: func() (msg string, err error) {
+			hs.Lock()
+			cached := lastResult
+			hs.Unlock()
+			if time.Now().Before(cached.time.Add(stalledPipelineCachePeriod)) {
+				return cached.message, cached.err
+			}
+
+			result := checkPublishEndAge(contentType, threshold, lastEvent)
+			hs.Lock()
+			lastResult = result
+			hs.Unlock()
+			return result.message, result.err
+		},
+	}
+}
+
+func checkPublishEndAge(contentType string, threshold time.Duration, lastEvent func(query monitoringQuery) (*publishEvent, searchMetadata, error)) healthStatus {
+	now := time.Now()
+	// limiting the search to the threshold keeps the query cheap when the pipeline is stalled
+	event, metadata, err := lastEvent(monitoringQuery{ContentType: contentType, EarliestTime: fmt.Sprintf("-%ds", int(threshold.Seconds()))})
+	if errors.Is(err, ErrNoResults) {
+		if len(metadata.Warnings) > 0 {
+			// the event may be in the results that are missing
+			return healthStatus{message: "Pipeline stalled", err: fmt.Errorf("no %s PublishEnd event in the last %v, the search has warnings: %s", contentType, threshold, strings.Join(metadata.Warnings, "; ")), time: now}
+		}
+		return healthStatus{message: "Pipeline stalled", err: fmt.Errorf("no %s PublishEnd event in the last %v", contentType, threshold), time: now}
+	}
+	if err != nil {
+		return healthStatus{message: "Splunk error", err: err, time: now}
+	}
+
+	eventTime, err := time.Parse(time.RFC3339Nano, event.Time)
+	if err != nil {
+		return healthStatus{message: "Invalid event time", err: fmt.Errorf("invalid time %q on transaction %s: %v", event.Time, event.TransactionID, err), time: now}
+	}
+	if age := now.Sub(eventTime); age > threshold {
+		return healthStatus{message: "Pipeline stalled", err: fmt.Errorf("last %s PublishEnd event is %v old, threshold is %v", contentType, age.Round(time.Second), threshold), time: now}
+	}
+	return healthStatus{message: fmt.Sprintf("Last %s PublishEnd event at %s", contentType, event.Time), time: now}
+}
+
+// parseStalledPipelineThresholds parses a comma separated list of contentType=duration pairs, each optionally followed by
+// the severity of the check, e.g. "annotations=1h,list=30m:2"
+func parseStalledPipelineThresholds(value string) (map[string]stalledPipelineThreshold, error) {
+	thresholds := make(map[string]stalledPipelineThreshold)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid stalled pipeline threshold %q, expected contentType=duration[:severity]", pair)
+		}
+		contentType := strings.TrimSpace(parts[0])
+		if !isValidContentType(contentType) {
+			return nil, fmt.Errorf("invalid content type %q in stalled pipeline threshold", contentType)
+		}
+		durationAndSeverity := strings.SplitN(parts[1], ":", 2)
+		maxAge, err := time.ParseDuration(strings.TrimSpace(durationAndSeverity[0]))
+		if err != nil || maxAge < time.Second {
+			return nil, fmt.Errorf("invalid duration in stalled pipeline threshold %q", pair)
+		}
+		severity := uint64(defaultStalledPipelineSeverity)
+		if len(durationAndSeverity) == 2 {
+			severity, err = strconv.ParseUint(strings.TrimSpace(durationAndSeverity[1]), 10, 8)
+			if err != nil || severity < 1 || severity > 3 {
+				return nil, fmt.Errorf("invalid severity in stalled pipeline threshold %q, expected 1, 2 or 3", pair)
+			}
+		}
+		thresholds[contentType] = stalledPipelineThreshold{maxAge: maxAge, severity: uint8(severity)}
+	}
+	return thresholds, nil
+}
+
 func (hs *healthService) gtgCheck() gtg.Status {
-	for _, check := range hs.checks {
+	for _, check := range hs.gtgChecks {
 		if _, err := check.Checker(); err != nil {
 			return gtg.Status{GoodToGo: false, Message: err.Error()}
 		}
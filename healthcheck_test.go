@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStalledPipelineThresholds(t *testing.T) {
+	assert.NoError(t, setContentTypes([]string{"annotations", "list"}))
+	defer setContentTypes(defaultContentTypes)
+
+	thresholds, err := parseStalledPipelineThresholds("annotations=1h")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]stalledPipelineThreshold{"annotations": {maxAge: time.Hour, severity: defaultStalledPipelineSeverity}}, thresholds)
+
+	thresholds, err = parseStalledPipelineThresholds("annotations=1h:2, list=30m")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]stalledPipelineThreshold{"annotations": {maxAge: time.Hour, severity: 2}, "list": {maxAge: 30 * time.Minute, severity: 1}}, thresholds)
+
+	thresholds, err = parseStalledPipelineThresholds("")
+	assert.NoError(t, err)
+	assert.Empty(t, thresholds)
+
+	_, err = parseStalledPipelineThresholds("INVALID_CONTENT_TYPE=1h")
+	assert.Error(t, err)
+
+	_, err = parseStalledPipelineThresholds("annotations=1year")
+	assert.Error(t, err)
+
+	_, err = parseStalledPipelineThresholds("annotations")
+	assert.Error(t, err)
+
+	_, err = parseStalledPipelineThresholds("annotations=1h:4")
+	assert.Error(t, err)
+
+	_, err = parseStalledPipelineThresholds("annotations=1h:high")
+	assert.Error(t, err)
+}
+
+func TestStalledPipelineCheck(t *testing.T) {
+	searching := make(chan bool)
+	searched := make(chan bool)
+	lastEvent := func(query monitoringQuery) (*publishEvent, searchMetadata, error) {
+		searching <- true
+		<-searched
+		return &publishEvent{Time: time.Now().Format(time.RFC3339Nano)}, searchMetadata{}, nil
+	}
+	hs := newHealthService(healthConfig{stalledPipelineThresholds: map[string]stalledPipelineThreshold{"annotations": {maxAge: time.Hour, severity: 2}}}, func() healthStatus {
+		return healthStatus{message: "Splunk is ok"}
+	}, lastEvent)
+	assert.Len(t, hs.checks, 2)
+	stalledCheck := hs.checks[1]
+	assert.Equal(t, uint8(2), stalledCheck.Severity)
+
+	done := make(chan error)
+	go func() {
+		_, err := stalledCheck.Checker()
+		done <- err
+	}()
+	<-searching
+	// the Splunk check is not blocked by the search of the stalled pipeline check
+	_, err := hs.checks[0].Checker()
+	assert.NoError(t, err)
+	close(searched)
+	assert.NoError(t, <-done)
+
+	// the result is cached
+	_, err = stalledCheck.Checker()
+	assert.NoError(t, err)
+}
+
+func TestCheckPublishEndAge(t *testing.T) {
+	tests := []struct {
+		event    *publishEvent
+		err      error
+		hasError bool
+	}{
+		{event: &publishEvent{Time: time.Now().Add(-time.Minute).Format(time.RFC3339Nano)}},
+		{event: &publishEvent{Time: time.Now().Add(-2 * time.Hour).Format(time.RFC3339Nano)}, hasError: true},
+		{event: &publishEvent{Time: "INVALID"}, hasError: true},
+		{err: ErrNoResults, hasError: true},
+		{err: errors.New("503 Service Unavailable"), hasError: true},
+	}
+
+	status := checkPublishEndAge("annotations", time.Hour, func(q monitoringQuery) (*publishEvent, searchMetadata, error) {
+		return nil, searchMetadata{Warnings: []string{"Results of region us are missing"}}, ErrNoResults
+	})
+	assert.EqualError(t, status.err, "no annotations PublishEnd event in the last 1h0m0s, the search has warnings: Results of region us are missing")
+
+	for _, test := range tests {
+		var query monitoringQuery
+		status := checkPublishEndAge("annotations", time.Hour, func(q monitoringQuery) (*publishEvent, searchMetadata, error) {
+			query = q
+			return test.event, searchMetadata{}, test.err
+		})
+		assert.Equal(t, "annotations", query.ContentType)
+		assert.Equal(t, "-3600s", query.EarliestTime)
+		if test.hasError {
+			assert.Error(t, status.err)
+		} else {
+			assert.NoError(t, status.err)
+		}
+	}
+}
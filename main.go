@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
@@ -83,12 +85,184 @@ func initApp() *cli.Cli {
 		EnvVar: "SPLUNK_PASSWORD",
 	})
 
-	splunkURL := app.String(cli.StringOpt{
+	splunkURLs := app.Strings(cli.StringsOpt{
 		Name:   "splunk-url",
-		Desc:   "Splunk REST API URL",
+		Value:  []string{},
+		Desc:   "Splunk REST API URL; several comma separated search heads can be given for failover",
 		EnvVar: "SPLUNK_URL",
 	})
 
+	headSelection := app.String(cli.StringOpt{
+		Name:   "splunk-head-selection",
+		Value:  headSelectionPrimary,
+		Desc:   "How searches are spread over the Splunk search heads: primary (the first available head in order) or round-robin",
+		EnvVar: "SPLUNK_HEAD_SELECTION",
+	})
+
+	stalledPipelineThresholds := app.String(cli.StringOpt{
+		Name:   "stalled-pipeline-thresholds",
+		Value:  "",
+		Desc:   "Comma separated contentType=duration[:severity] pairs; /__health fails when the last PublishEnd event is older than the duration, with the severity of the check, 1 by default (e.g. annotations=1h,list=30m:2)",
+		EnvVar: "STALLED_PIPELINE_THRESHOLDS",
+	})
+
+	supportedContentTypes := app.Strings(cli.StringsOpt{
+		Name:   "content-types",
+		Value:  defaultContentTypes,
+		Desc:   "Content types accepted by the endpoints, as found in the content_type field of the events (case insensitive), e.g. annotations,article,list",
+		EnvVar: "CONTENT_TYPES",
+	})
+
+	pollerContentTypes := app.Strings(cli.StringsOpt{
+		Name:   "transactions-poller-content-types",
+		Value:  []string{},
+		Desc:   "Content types for which open transactions are polled in the background and served from memory",
+		EnvVar: "TRANSACTIONS_POLLER_CONTENT_TYPES",
+	})
+
+	pollerInterval := app.String(cli.StringOpt{
+		Name:   "transactions-poller-interval",
+		Value:  "1m",
+		Desc:   "Interval between background transactions polls",
+		EnvVar: "TRANSACTIONS_POLLER_INTERVAL",
+	})
+
+	warningPolicy := app.String(cli.StringOpt{
+		Name:   "splunk-warning-policy",
+		Value:  "report",
+		Desc:   "What to do with the WARN messages of Splunk jobs: ignore, report (in the X-Splunk-Warning header and the response envelope) or fail",
+		EnvVar: "SPLUNK_WARNING_POLICY",
+	})
+
+	streamPollInterval := app.String(cli.StringOpt{
+		Name:   "stream-poll-interval",
+		Value:  "",
+		Desc:   "Interval between Splunk searches for the event stream; a real-time search is used if not set",
+		EnvVar: "STREAM_POLL_INTERVAL",
+	})
+
+	recordDir := app.String(cli.StringOpt{
+		Name:   "record-dir",
+		Value:  "",
+		Desc:   "Directory where the Splunk requests and responses are recorded, without the credentials, for replaying them in tests",
+		EnvVar: "SPLUNK_RECORD_DIR",
+	})
+
+	splunkRegions := app.String(cli.StringOpt{
+		Name:   "splunk-regions",
+		Value:  "",
+		Desc:   "Comma separated region=environment[@url] entries; queries are run in all the regions and their results merged (e.g. eu=upp-prod-delivery-eu,us=upp-prod-delivery-us)",
+		EnvVar: "SPLUNK_REGIONS",
+	})
+
+	retryMaxAttempts := app.Int(cli.IntOpt{
+		Name:   "splunk-retry-max-attempts",
+		Value:  defaultMaxAttempts,
+		Desc:   "Maximum number of attempts of a Splunk search; only transient failures are retried",
+		EnvVar: "SPLUNK_RETRY_MAX_ATTEMPTS",
+	})
+
+	retryBaseBackoff := app.String(cli.StringOpt{
+		Name:   "splunk-retry-base-backoff",
+		Value:  defaultBaseBackoff.String(),
+		Desc:   "Backoff before the second attempt of a Splunk search, doubled for each further attempt",
+		EnvVar: "SPLUNK_RETRY_BASE_BACKOFF",
+	})
+
+	retryMaxBackoff := app.String(cli.StringOpt{
+		Name:   "splunk-retry-max-backoff",
+		Value:  defaultMaxBackoff.String(),
+		Desc:   "Maximum backoff between attempts of a Splunk search",
+		EnvVar: "SPLUNK_RETRY_MAX_BACKOFF",
+	})
+
+	retryJitter := app.Float64(cli.Float64Opt{
+		Name:   "splunk-retry-jitter",
+		Value:  defaultBackoffJitter,
+		Desc:   "Fraction of each backoff that is randomised, between 0 and 1",
+		EnvVar: "SPLUNK_RETRY_JITTER",
+	})
+
+	retryBudget := app.String(cli.StringOpt{
+		Name:   "splunk-retry-budget",
+		Value:  "",
+		Desc:   "Overall time allowed for the attempts of a Splunk search, after which it is not retried; no limit if not set",
+		EnvVar: "SPLUNK_RETRY_BUDGET",
+	})
+
+	breakerWindow := app.Int(cli.IntOpt{
+		Name:   "splunk-breaker-window",
+		Value:  defaultBreakerWindow,
+		Desc:   "Number of latest Splunk searches the circuit breaker failure ratio is computed on; the breaker is disabled if 0",
+		EnvVar: "SPLUNK_BREAKER_WINDOW",
+	})
+
+	breakerFailureRatio := app.Float64(cli.Float64Opt{
+		Name:   "splunk-breaker-failure-ratio",
+		Value:  defaultBreakerFailureRatio,
+		Desc:   "Ratio of failed Splunk searches in the window that opens the circuit breaker",
+		EnvVar: "SPLUNK_BREAKER_FAILURE_RATIO",
+	})
+
+	breakerOpenDuration := app.String(cli.StringOpt{
+		Name:   "splunk-breaker-open-duration",
+		Value:  defaultBreakerOpenDuration.String(),
+		Desc:   "Time the circuit breaker stays open before trial searches are let through",
+		EnvVar: "SPLUNK_BREAKER_OPEN_DURATION",
+	})
+
+	breakerTrials := app.Int(cli.IntOpt{
+		Name:   "splunk-breaker-trials",
+		Value:  defaultBreakerTrials,
+		Desc:   "Number of successful trial searches that close the circuit breaker",
+		EnvVar: "SPLUNK_BREAKER_TRIALS",
+	})
+
+	backend := app.String(cli.StringOpt{
+		Name:   "backend",
+		Value:  backendSplunk,
+		Desc:   "Store the monitoring events are read from: splunk, opensearch or file",
+		EnvVar: "BACKEND",
+	})
+
+	openSearchURL := app.String(cli.StringOpt{
+		Name:   "opensearch-url",
+		Desc:   "OpenSearch or Elasticsearch URL, used with the opensearch backend",
+		EnvVar: "OPENSEARCH_URL",
+	})
+
+	openSearchIndex := app.String(cli.StringOpt{
+		Name:   "opensearch-index",
+		Value:  defaultOpenSearchIndex,
+		Desc:   "OpenSearch index name or pattern of the monitoring events",
+		EnvVar: "OPENSEARCH_INDEX",
+	})
+
+	openSearchUser := app.String(cli.StringOpt{
+		Name:   "opensearch-user",
+		Desc:   "OpenSearch user name",
+		EnvVar: "OPENSEARCH_USER",
+	})
+
+	openSearchPassword := app.String(cli.StringOpt{
+		Name:   "opensearch-password",
+		Desc:   "OpenSearch password",
+		EnvVar: "OPENSEARCH_PASSWORD",
+	})
+
+	eventsDir := app.String(cli.StringOpt{
+		Name:   "events-dir",
+		Desc:   "Directory of the JSON and NDJSON event files, used with the file backend",
+		EnvVar: "EVENTS_DIR",
+	})
+
+	eventsReplayTime := app.String(cli.StringOpt{
+		Name:   "events-replay-time",
+		Value:  "",
+		Desc:   "RFC3339 time the file backend starts at, so that recorded events are found by the relative time windows; the current time is used if not set",
+		EnvVar: "EVENTS_REPLAY_TIME",
+	})
+
 	logLevel := app.String(cli.StringOpt{
 		Name:   "logLevel",
 		Value:  "INFO",
@@ -97,26 +271,286 @@ func initApp() *cli.Cli {
 	})
 
 	uppLogger := logger.NewUPPLogger(*appSystemCode, *logLevel)
-	uppLogger.Infof("[Startup] splunk-event-reader is starting ")
+
+	app.Before = func() {
+		if err := setContentTypes(*supportedContentTypes); err != nil {
+			uppLogger.Fatalf("Invalid content types: %v", err)
+		}
+	}
+
+	// newEventReader builds the reader of the configured backend, and returns the Splunk regions searched if any
+	newEventReader := func() (EventReader, []regionConfig) {
+		var err error
+		if !isValidWarningPolicy(*warningPolicy) {
+			uppLogger.Fatalf("Invalid Splunk warning policy %s", *warningPolicy)
+		}
+		var streamInterval time.Duration
+		if *streamPollInterval != "" {
+			streamInterval, err = time.ParseDuration(*streamPollInterval)
+			if err != nil || streamInterval <= 0 {
+				uppLogger.Fatalf("Invalid stream poll interval %s", *streamPollInterval)
+			}
+		}
+		if !isValidBackend(*backend) {
+			uppLogger.Fatalf("Invalid backend %s", *backend)
+		}
+		var eventReader EventReader
+		var regions []regionConfig
+		switch *backend {
+		case backendOpenSearch:
+			if *openSearchURL == "" {
+				uppLogger.Fatalf("No OpenSearch URL given")
+			}
+			eventReader = newOpenSearchService(openSearchConfig{url: *openSearchURL, index: *openSearchIndex, user: *openSearchUser, password: *openSearchPassword, environment: *environment, streamPollInterval: streamInterval})
+		case backendFile:
+			if *eventsDir == "" {
+				uppLogger.Fatalf("No events directory given")
+			}
+			var replayTime time.Time
+			if *eventsReplayTime != "" {
+				replayTime, err = time.Parse(time.RFC3339, *eventsReplayTime)
+				if err != nil {
+					uppLogger.Fatalf("Invalid events replay time %s", *eventsReplayTime)
+				}
+			}
+			eventReader = newFileService(fileConfig{dir: *eventsDir, replayTime: replayTime, streamPollInterval: streamInterval})
+		default:
+			retry, err := parseRetryPolicy(*retryMaxAttempts, *retryBaseBackoff, *retryMaxBackoff, *retryJitter, *retryBudget)
+			if err != nil {
+				uppLogger.Fatalf("Invalid Splunk retry policy: %v", err)
+			}
+			openDuration, err := time.ParseDuration(*breakerOpenDuration)
+			if err != nil {
+				uppLogger.Fatalf("Invalid Splunk circuit breaker open duration %s", *breakerOpenDuration)
+			}
+			breaker := breakerConfig{window: *breakerWindow, failureRatio: *breakerFailureRatio, openDuration: openDuration, trials: *breakerTrials}
+			if err = breaker.validate(); err != nil {
+				uppLogger.Fatalf("Invalid Splunk circuit breaker: %v", err)
+			}
+			regions, err = parseSplunkRegions(*splunkRegions, *splunkURLs)
+			if err != nil {
+				uppLogger.Fatalf("Invalid Splunk regions: %v", err)
+			}
+			if len(*splunkURLs) == 0 && len(regions) == 0 {
+				uppLogger.Fatalf("No Splunk URL given")
+			}
+			if !isValidHeadSelection(*headSelection) {
+				uppLogger.Fatalf("Invalid Splunk search head selection %s", *headSelection)
+			}
+			accessConfig := splunkAccessConfig{user: *splunkUser, password: *splunkPassword, restURLs: *splunkURLs, headSelection: *headSelection, environment: *environment, index: *splunkIndex, warningPolicy: *warningPolicy, streamPollInterval: streamInterval, retryPolicy: retry, breaker: breaker, recordDir: *recordDir}
+			if len(regions) > 0 {
+				var regionalServices []regionalService
+				for _, region := range regions {
+					regionAccessConfig := accessConfig
+					regionAccessConfig.region = region.name
+					regionAccessConfig.environment = region.environment
+					regionAccessConfig.restURLs = region.restURLs
+					if *recordDir != "" {
+						regionAccessConfig.recordDir = filepath.Join(*recordDir, region.name)
+					}
+					regionalServices = append(regionalServices, regionalService{name: region.name, service: newSplunkService(regionAccessConfig)})
+				}
+				eventReader = newFederatedSplunkService(regionalServices, uppLogger)
+			} else {
+				eventReader = newSplunkService(accessConfig)
+			}
+		}
+		return eventReader, regions
+	}
 
 	app.Action = func() {
 
+		uppLogger.Infof("[Startup] splunk-event-reader is starting ")
 		uppLogger.Infof("System code: %s, App Name: %s, Port: %s", *appSystemCode, *appName, *port)
-		splunkService := newSplunkService(splunkAccessConfig{user: *splunkUser, password: *splunkPassword, restURL: *splunkURL, environment: *environment, index: *splunkIndex})
-		healthService := newHealthService(healthConfig{appSystemCode: *appSystemCode, appName: *appName, port: *port}, splunkService.IsHealthy)
+		thresholds, err := parseStalledPipelineThresholds(*stalledPipelineThresholds)
+		if err != nil {
+			uppLogger.Fatalf("Invalid stalled pipeline thresholds: %v", err)
+		}
+		eventReader, regions := newEventReader()
+		healthService := newHealthService(healthConfig{appSystemCode: *appSystemCode, appName: *appName, port: *port, stalledPipelineThresholds: thresholds}, eventReader.IsHealthy, eventReader.GetLastEvent)
+
+		if len(*splunkURLs) > 1 || len(regions) > 1 {
+			healthService.checks = append(healthService.checks, eventReader.HealthChecks()...)
+		}
+
+		pollers := make(map[string]*transactionsPoller)
+		if len(*pollerContentTypes) > 0 {
+			interval, err := time.ParseDuration(*pollerInterval)
+			if err != nil || interval <= 0 {
+				uppLogger.Fatalf("Invalid transactions poller interval %s", *pollerInterval)
+			}
+			for _, contentType := range *pollerContentTypes {
+				if !isValidContentType(contentType) {
+					uppLogger.Fatalf("Invalid transactions poller content type %s", contentType)
+				}
+				poller := newTransactionsPoller(contentType, interval, eventReader, uppLogger)
+				healthService.checks = append(healthService.checks, poller.healthCheck())
+				pollers[contentType] = poller
+				poller.start()
+			}
+		}
 
 		go func() {
 			routeRequests(healthService, *port, requestHandler{
-				splunkService: splunkService,
-				log:           uppLogger,
-			}, )
+				eventReader: eventReader,
+				pollers:     pollers,
+				log:         uppLogger,
+			})
 		}()
 
 		waitForSignal()
+		for _, poller := range pollers {
+			poller.close()
+		}
 		healthService.stop <- true
 
 	}
 
+	app.Command("transactions", "Prints the transactions of a content type without starting the HTTP server", func(cmd *cli.Cmd) {
+		contentType := cmd.String(cli.StringOpt{
+			Name:  "content-type",
+			Value: "annotations",
+			Desc:  "Content type of the transactions",
+		})
+		earliestTime := cmd.String(cli.StringOpt{
+			Name: "earliest",
+			Desc: "Earliest time of the search, e.g. -30m",
+		})
+		latestTime := cmd.String(cli.StringOpt{
+			Name: "latest",
+			Desc: "Latest time of the search",
+		})
+		uuids := cmd.Strings(cli.StringsOpt{
+			Name: "uuid",
+			Desc: "UUIDs of the content; repeat the option for several",
+		})
+		services := cmd.Strings(cli.StringsOpt{
+			Name: "service",
+			Desc: "Keeps the transactions with an event of one of these services",
+		})
+		events := cmd.Strings(cli.StringsOpt{
+			Name: "event",
+			Desc: "Keeps the transactions with one of these events",
+		})
+		includeClosed := cmd.Bool(cli.BoolOpt{
+			Name: "include-closed",
+			Desc: "Prints the closed transactions too, not only the open ones",
+		})
+		output := cmd.String(cli.StringOpt{
+			Name:  "output",
+			Value: outputJSON,
+			Desc:  "Output format: json, ndjson or table",
+		})
+
+		cmd.Action = func() {
+			uppLogger.Out = os.Stderr
+			if !isValidContentType(*contentType) {
+				uppLogger.Fatalf("Invalid content type %s", *contentType)
+			}
+			if !isValidOutputFormat(*output) {
+				uppLogger.Fatalf("Invalid output format %s", *output)
+			}
+			query, err := parseTransactionsQuery(queryParams(*earliestTime, *latestTime, *uuids, *services, *events, nil))
+			if err != nil {
+				uppLogger.Fatal(err)
+			}
+			query.ContentType = *contentType
+			query.IncludeClosed = *includeClosed
+
+			eventReader, _ := newEventReader()
+			transactions, metadata, err := eventReader.GetTransactionsWithMetadata(query)
+			if err != nil {
+				uppLogger.Fatal(err)
+			}
+			for _, warning := range metadata.Warnings {
+				uppLogger.Warn(warning)
+			}
+			if err = transactionsOutput(transactions).write(os.Stdout, *output); err != nil {
+				uppLogger.Fatal(err)
+			}
+		}
+	})
+
+	app.Command("last-event", "Prints the last event of a content type without starting the HTTP server", func(cmd *cli.Cmd) {
+		contentType := cmd.String(cli.StringOpt{
+			Name:  "content-type",
+			Value: "annotations",
+			Desc:  "Content type of the event",
+		})
+		earliestTime := cmd.String(cli.StringOpt{
+			Name: "earliest",
+			Desc: "Earliest time of the search, e.g. -30m",
+		})
+		events := cmd.Strings(cli.StringsOpt{
+			Name: "event",
+			Desc: "Events searched for; PublishEnd if not set",
+		})
+		services := cmd.Strings(cli.StringsOpt{
+			Name: "service",
+			Desc: "Services of the event",
+		})
+		levels := cmd.Strings(cli.StringsOpt{
+			Name: "level",
+			Desc: "Log levels of the event",
+		})
+		limit := cmd.Int(cli.IntOpt{
+			Name:  "limit",
+			Value: 0,
+			Desc:  "Number of latest events printed; the single last event is printed if not set",
+		})
+		output := cmd.String(cli.StringOpt{
+			Name:  "output",
+			Value: outputJSON,
+			Desc:  "Output format: json, ndjson or table",
+		})
+
+		cmd.Action = func() {
+			uppLogger.Out = os.Stderr
+			if !isValidContentType(*contentType) {
+				uppLogger.Fatalf("Invalid content type %s", *contentType)
+			}
+			if !isValidOutputFormat(*output) {
+				uppLogger.Fatalf("Invalid output format %s", *output)
+			}
+			params := queryParams(*earliestTime, "", nil, *services, *events, *levels)
+			if *limit != 0 {
+				params.Set(limitPathVar, strconv.Itoa(*limit))
+			} else {
+				params.Set(lastEventPathVar, "true")
+			}
+			query, eventsLimit, err := parseEventsQuery(params)
+			if err != nil {
+				uppLogger.Fatal(err)
+			}
+			query.ContentType = *contentType
+
+			eventReader, _ := newEventReader()
+			var result queryOutput
+			if eventsLimit > 0 {
+				events, metadata, err := eventReader.GetLastEvents(query, eventsLimit)
+				if err != nil {
+					uppLogger.Fatal(err)
+				}
+				for _, warning := range metadata.Warnings {
+					uppLogger.Warn(warning)
+				}
+				result = eventsOutput(events, events)
+			} else {
+				event, metadata, err := eventReader.GetLastEvent(query)
+				for _, warning := range metadata.Warnings {
+					uppLogger.Warn(warning)
+				}
+				if err != nil {
+					uppLogger.Fatal(err)
+				}
+				result = eventsOutput(event, []publishEvent{*event})
+			}
+			if err = result.write(os.Stdout, *output); err != nil {
+				uppLogger.Fatal(err)
+			}
+		}
+	})
+
 	return app
 }
 
@@ -141,8 +575,15 @@ func routeRequests(healthService *healthService, port string, rh requestHandler)
 	serveMux.HandleFunc(status.BuildInfoPath, status.BuildInfoHandler)
 
 	servicesRouter := mux.NewRouter()
+	servicesRouter.HandleFunc("/transactions", rh.getTransactionsByContentType).Methods("GET")
+	servicesRouter.HandleFunc("/events", rh.getLastEventByContentType).Methods("GET")
 	servicesRouter.HandleFunc("/{contentType}/transactions", rh.getTransactions).Methods("GET")
+	servicesRouter.HandleFunc("/{contentType}/transactions/search", rh.searchTransactions).Methods("POST")
 	servicesRouter.HandleFunc("/{contentType}/events", rh.getLastEvent).Methods("GET")
+	servicesRouter.HandleFunc("/{contentType}/events/stream", rh.streamEvents).Methods("GET")
+	servicesRouter.HandleFunc("/{contentType}/errors", rh.getErrors).Methods("GET")
+	servicesRouter.HandleFunc("/{contentType}/stats/throughput", rh.getThroughput).Methods("GET")
+	servicesRouter.HandleFunc("/{contentType}/content/{uuid}/await", rh.awaitPublish).Methods("GET")
 
 	var monitoringRouter http.Handler = servicesRouter
 	monitoringRouter = httphandlers.TransactionAwareRequestLoggingHandler(log, monitoringRouter)
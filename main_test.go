@@ -39,6 +39,8 @@ func TestMain(m *testing.M) {
 				inputFile = "testdata/splunk_publish_end_sample.json"
 			case strings.Contains(r.RequestURI, "transactions_sid/results"):
 				inputFile = "testdata/splunk_response_sample.json"
+			case strings.Contains(r.RequestURI, "errors_sid/results"):
+				inputJSON = []byte(`{"results":[{"service_name":"annotations-mapper","event":"Map","count":"1","transaction_ids":"tid_1","latest_time":"2017-09-19T14:00:00Z"}]}`)
 			case strings.Contains(r.RequestURI, "_sid"):
 				inputJSON = []byte(`{
 										"entry": [
@@ -56,6 +58,9 @@ func TestMain(m *testing.M) {
 			case strings.Contains(r.PostForm.Get("search"), "audit"):
 				inputJSON = []byte(`{"sid":"audit_sid"}`)
 				status = http.StatusCreated
+			case strings.Contains(r.PostForm.Get("search"), "| stats count, values(transaction_id)"):
+				inputJSON = []byte(`{"sid":"errors_sid"}`)
+				status = http.StatusCreated
 			case strings.Contains(r.PostForm.Get("search"), "head"):
 				inputJSON = []byte(`{"sid":"last_event_sid"}`)
 				status = http.StatusCreated
@@ -187,6 +192,7 @@ func Test_GetTransactions(t *testing.T) {
 	tests := []struct {
 		url            string
 		expectedStatus int
+		expectedEmpty  bool
 		flags          flags
 	}{
 		{url: "http://localhost:8080/annotations/transactions", expectedStatus: http.StatusOK},
@@ -196,13 +202,20 @@ func Test_GetTransactions(t *testing.T) {
 		{url: "http://localhost:8080/annotations/transactions?earliestTime=-1year", expectedStatus: http.StatusBadRequest},
 		{url: "http://localhost:8080/annotations/transactions?uuid=191b9e5e-3356-4ae9-801f-0ce8d34f6cbe&uuid=0dd0a85f-2926-4371-a0d8-2ae13d738476", expectedStatus: http.StatusOK},
 		{url: "http://localhost:8080/annotations/transactions?uuid=INVALID_UUID&uuid=0dd0a85f-2926-4371-a0d8-2ae13d738476", expectedStatus: http.StatusBadRequest},
+		{url: "http://localhost:8080/annotations/transactions?cursor=INVALID", expectedStatus: http.StatusBadRequest},
+		{url: "http://localhost:8080/annotations/transactions?service=nativerw&level=info&event=NativeSave&isValid=true", expectedStatus: http.StatusOK, expectedEmpty: true},
+		{url: "http://localhost:8080/annotations/transactions?service=nativerw&level=info&event=NativeSave", expectedStatus: http.StatusOK},
+		{url: "http://localhost:8080/annotations/transactions?isValid=maybe", expectedStatus: http.StatusBadRequest},
+		{url: "http://localhost:8080/annotations/transactions?service=a%22+OR+b", expectedStatus: http.StatusBadRequest},
 	}
 
 	for _, test := range tests {
 		testFlags = test.flags
 		expectedJSON, err := ioutil.ReadFile("testdata/splunk_transaction_output.json")
 		expectedTx := []transactionEvent{}
-		json.Unmarshal(expectedJSON, &expectedTx)
+		if !test.expectedEmpty {
+			json.Unmarshal(expectedJSON, &expectedTx)
+		}
 
 		client := &http.Client{}
 
@@ -226,6 +239,49 @@ func Test_GetTransactions(t *testing.T) {
 	}
 }
 
+func Test_GetTransactionsEnvelope(t *testing.T) {
+	expectedJSON, _ := ioutil.ReadFile("testdata/splunk_transaction_output.json")
+	expectedTx := []transactionEvent{}
+	json.Unmarshal(expectedJSON, &expectedTx)
+
+	tests := []struct {
+		url    string
+		accept string
+	}{
+		{url: "http://localhost:8080/annotations/transactions?envelope=true"},
+		{url: "http://localhost:8080/annotations/transactions", accept: envelopeMediaType},
+	}
+
+	for _, test := range tests {
+		client := &http.Client{}
+
+		req, _ := http.NewRequest("GET", test.url, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		res, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, envelopeMediaType, res.Header.Get("Content-Type"))
+
+		envelope := struct {
+			Data       []transactionEvent `json:"data"`
+			Count      int                `json:"count"`
+			Cache      string             `json:"cache"`
+			Sids       []string           `json:"sids"`
+			EventCount int                `json:"event_count"`
+		}{}
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&envelope))
+		res.Body.Close()
+
+		assert.Equal(t, expectedTx, envelope.Data)
+		assert.Equal(t, 1, envelope.Count)
+		assert.Equal(t, cacheNone, envelope.Cache)
+		assert.Equal(t, []string{"transactions_sid"}, envelope.Sids)
+		assert.Equal(t, 6, envelope.EventCount)
+	}
+}
+
 func Test_GetLastEvent(t *testing.T) {
 	tests := []struct {
 		url            string
@@ -268,3 +324,30 @@ func Test_GetLastEvent(t *testing.T) {
 		testFlags = flags{}
 	}
 }
+
+func Test_GetErrors(t *testing.T) {
+	tests := []struct {
+		url            string
+		expectedStatus int
+		flags          flags
+	}{
+		{url: "http://localhost:8080/annotations/errors?earliestTime=-1h", expectedStatus: http.StatusOK},
+		{url: "http://localhost:8080/annotations/errors", flags: flags{error: true}, expectedStatus: http.StatusInternalServerError},
+		{url: "http://localhost:8080/INVALID_CONTENT_TYPE/errors", expectedStatus: http.StatusBadRequest},
+		{url: "http://localhost:8080/annotations/errors?earliestTime=-1year", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		testFlags = test.flags
+
+		client := &http.Client{}
+
+		req, _ := http.NewRequest("GET", test.url, nil)
+		res, err := client.Do(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, test.expectedStatus, res.StatusCode)
+
+		testFlags = flags{}
+	}
+}
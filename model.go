@@ -1,21 +1,12 @@
 package main
 
-type publishEvent struct {
-	ContentType   string `json:"content_type"`
-	Event         string `json:"event"`
-	IsValid       string `json:"isValid,omitempty"`
-	Level         string `json:"level"`
-	ServiceName   string `json:"service_name"`
-	Time          string `json:"@time"`
-	TransactionID string `json:"transaction_id"`
-	UUID          string `json:"uuid"`
-}
+import "github.com/Financial-Times/splunk-event-reader/model"
 
-type transactionEvent struct {
-	TransactionID string         `json:"transaction_id"`
-	UUID          string         `json:"uuid"`
-	ClosedTxn     string         `json:"closed_txn"`
-	EventCount    int            `json:"eventcount"`
-	Events        []publishEvent `json:"events"`
-	StartTime     string         `json:"start_time"`
-}
+// the response types live in the model package, shared with the Go client
+type (
+	publishEvent     = model.PublishEvent
+	transactionEvent = model.TransactionEvent
+	errorReport      = model.ErrorReport
+	timeSeries       = model.TimeSeries
+	searchMetadata   = model.SearchMetadata
+)
@@ -0,0 +1,75 @@
+// Package model holds the types of the splunk-event-reader API responses, shared by the service and its Go client.
+package model
+
+// PublishEvent is a monitoring event logged by a service of the publishing pipeline
+type PublishEvent struct {
+	ContentType   string `json:"content_type"`
+	Event         string `json:"event"`
+	IsValid       string `json:"isValid,omitempty"`
+	Level         string `json:"level"`
+	ServiceName   string `json:"service_name"`
+	Time          string `json:"@time"`
+	IndexTime     string `json:"indextime,omitempty"`
+	TransactionID string `json:"transaction_id"`
+	UUID          string `json:"uuid"`
+	// Region is the region the event was found in, when several regions are searched
+	Region string `json:"region,omitempty"`
+}
+
+// TransactionEvent is a publish transaction with its events
+type TransactionEvent struct {
+	TransactionID string         `json:"transaction_id"`
+	UUID          string         `json:"uuid"`
+	ClosedTxn     string         `json:"closed_txn"`
+	EventCount    int            `json:"eventcount"`
+	Events        []PublishEvent `json:"events"`
+	StartTime     string         `json:"start_time"`
+}
+
+// ErrorReport counts the error events of a service
+type ErrorReport struct {
+	ServiceName          string   `json:"service_name"`
+	Event                string   `json:"event"`
+	Count                int      `json:"count"`
+	SampleTransactionIDs []string `json:"sample_transaction_ids"`
+	LatestTime           string   `json:"latest_time"`
+}
+
+// TimeSeries is a series in the Grafana JSON datasource format, datapoints being [value, unix time in milliseconds] pairs
+type TimeSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// SearchMetadata describes the Splunk searches a response is built from
+type SearchMetadata struct {
+	Sids         []string `json:"sids"`
+	EarliestTime string   `json:"earliest_time,omitempty"`
+	LatestTime   string   `json:"latest_time,omitempty"`
+	Duration     float64  `json:"splunk_duration_seconds"`
+	EventCount   int      `json:"event_count"`
+	Truncated    bool     `json:"truncated"`
+	Warnings     []string `json:"warnings,omitempty"`
+}
+
+// ErrorResponse is the body of a request failing because of its parameters or of the backend
+type ErrorResponse struct {
+	Message string `json:"message"`
+}
+
+// Merge adds the metadata of another search run for the same response
+func (metadata *SearchMetadata) Merge(other SearchMetadata) {
+	metadata.Sids = append(metadata.Sids, other.Sids...)
+	if metadata.EarliestTime == "" || (other.EarliestTime != "" && other.EarliestTime < metadata.EarliestTime) {
+		metadata.EarliestTime = other.EarliestTime
+	}
+	if other.LatestTime > metadata.LatestTime {
+		metadata.LatestTime = other.LatestTime
+	}
+	if other.Duration > metadata.Duration {
+		metadata.Duration = other.Duration
+	}
+	metadata.EventCount += other.EventCount
+	metadata.Truncated = metadata.Truncated || other.Truncated
+	metadata.Warnings = append(metadata.Warnings, other.Warnings...)
+}
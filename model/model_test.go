@@ -0,0 +1,22 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchMetadata_Merge(t *testing.T) {
+	metadata := SearchMetadata{Sids: []string{"sid_1"}, EarliestTime: "1505829600", LatestTime: "1505829900", Duration: 1.5, EventCount: 3}
+	metadata.Merge(SearchMetadata{Sids: []string{"sid_2"}, EarliestTime: "1505829500", LatestTime: "1505829800", Duration: 2, EventCount: 2, Truncated: true, Warnings: []string{"Search head is busy"}})
+
+	assert.Equal(t, SearchMetadata{
+		Sids:         []string{"sid_1", "sid_2"},
+		EarliestTime: "1505829500",
+		LatestTime:   "1505829900",
+		Duration:     2,
+		EventCount:   5,
+		Truncated:    true,
+		Warnings:     []string{"Search head is busy"},
+	}, metadata)
+}
@@ -0,0 +1,138 @@
+package model
+
+import (
+	"strconv"
+	"time"
+)
+
+// EventType is the event of a monitoring event; events other than the known ones are kept as they are logged
+type EventType string
+
+// the known events of the publishing pipeline
+const (
+	EventPublishStart              EventType = "PublishStart"
+	EventPublishEnd                EventType = "PublishEnd"
+	EventMap                       EventType = "Map"
+	EventIngest                    EventType = "Ingest"
+	EventNativeSave                EventType = "NativeSave"
+	EventCombine                   EventType = "Combine"
+	EventForwarding                EventType = "Forwarding"
+	EventContentWriteElasticsearch EventType = "ContentWriteElasticsearch"
+)
+
+var knownEventTypes = map[EventType]bool{
+	EventPublishStart:              true,
+	EventPublishEnd:                true,
+	EventMap:                       true,
+	EventIngest:                    true,
+	EventNativeSave:                true,
+	EventCombine:                   true,
+	EventForwarding:                true,
+	EventContentWriteElasticsearch: true,
+}
+
+// Event is a monitoring event of the v2 model, with parsed times and validity
+type Event struct {
+	ContentType string    `json:"content_type"`
+	Event       EventType `json:"event"`
+	// Valid is not set for the events without validity
+	Valid       *bool      `json:"valid,omitempty"`
+	Level       string     `json:"level"`
+	ServiceName string     `json:"service_name"`
+	Time        time.Time  `json:"time"`
+	IndexTime   *time.Time `json:"index_time,omitempty"`
+	// RawTime is the logged time when it cannot be parsed, Time being zero then
+	RawTime       string `json:"raw_time,omitempty"`
+	TransactionID string `json:"transaction_id"`
+	UUID          string `json:"uuid"`
+	Region        string `json:"region,omitempty"`
+}
+
+// Transaction is a publish transaction of the v2 model
+type Transaction struct {
+	TransactionID string     `json:"transaction_id"`
+	UUID          string     `json:"uuid"`
+	Closed        bool       `json:"closed"`
+	EventCount    int        `json:"event_count"`
+	Events        []Event    `json:"events"`
+	StartTime     *time.Time `json:"start_time,omitempty"`
+}
+
+// Known tells whether the event is one of the known events of the publishing pipeline
+func (eventType EventType) Known() bool {
+	return knownEventTypes[eventType]
+}
+
+// V2 returns the event in the v2 model
+func (event PublishEvent) V2() Event {
+	v2 := Event{
+		ContentType:   event.ContentType,
+		Event:         EventType(event.Event),
+		Level:         event.Level,
+		ServiceName:   event.ServiceName,
+		TransactionID: event.TransactionID,
+		UUID:          event.UUID,
+		Region:        event.Region,
+	}
+	if valid, err := strconv.ParseBool(event.IsValid); err == nil {
+		v2.Valid = &valid
+	}
+	if eventTime, ok := parseTime(event.Time); ok {
+		v2.Time = eventTime
+	} else {
+		v2.RawTime = event.Time
+	}
+	if indexTime, err := strconv.ParseInt(event.IndexTime, 10, 64); err == nil {
+		indexed := time.Unix(indexTime, 0).UTC()
+		v2.IndexTime = &indexed
+	}
+	return v2
+}
+
+// V2 returns the transaction in the v2 model
+func (transaction TransactionEvent) V2() Transaction {
+	v2 := Transaction{
+		TransactionID: transaction.TransactionID,
+		UUID:          transaction.UUID,
+		Closed:        transaction.ClosedTxn == "1",
+		EventCount:    transaction.EventCount,
+		Events:        make([]Event, 0, len(transaction.Events)),
+	}
+	for _, event := range transaction.Events {
+		v2.Events = append(v2.Events, event.V2())
+	}
+	if startTime, ok := parseTime(transaction.StartTime); ok {
+		v2.StartTime = &startTime
+	}
+	return v2
+}
+
+// EventsV2 returns the events in the v2 model
+func EventsV2(events []PublishEvent) []Event {
+	v2 := make([]Event, 0, len(events))
+	for _, event := range events {
+		v2 = append(v2, event.V2())
+	}
+	return v2
+}
+
+// TransactionsV2 returns the transactions in the v2 model
+func TransactionsV2(transactions []TransactionEvent) []Transaction {
+	v2 := make([]Transaction, 0, len(transactions))
+	for _, transaction := range transactions {
+		v2 = append(v2, transaction.V2())
+	}
+	return v2
+}
+
+// parseTime parses the RFC3339 times of the events, with or without fractional seconds
+func parseTime(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return parsed.UTC(), true
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishEvent_V2(t *testing.T) {
+	valid := true
+	indexTime := time.Date(2017, 9, 19, 15, 11, 32, 0, time.UTC)
+	event := PublishEvent{ContentType: "Annotations", Event: "PublishEnd", IsValid: "true", Level: "info", ServiceName: "annotations-rw", Time: "2017-09-19T15:11:31.795334198Z", IndexTime: "1505833892", TransactionID: "tid_1", UUID: "uuid_1"}
+	assert.Equal(t, Event{
+		ContentType:   "Annotations",
+		Event:         EventPublishEnd,
+		Valid:         &valid,
+		Level:         "info",
+		ServiceName:   "annotations-rw",
+		Time:          time.Date(2017, 9, 19, 15, 11, 31, 795334198, time.UTC),
+		IndexTime:     &indexTime,
+		TransactionID: "tid_1",
+		UUID:          "uuid_1",
+	}, event.V2())
+	assert.True(t, event.V2().Event.Known())
+
+	unknown := PublishEvent{Event: "ImageResize", IsValid: "", Time: "19/09/2017 14:00", TransactionID: "tid_2"}.V2()
+	assert.Equal(t, EventType("ImageResize"), unknown.Event)
+	assert.False(t, unknown.Event.Known())
+	assert.Nil(t, unknown.Valid)
+	assert.Nil(t, unknown.IndexTime)
+	assert.True(t, unknown.Time.IsZero())
+	assert.Equal(t, "19/09/2017 14:00", unknown.RawTime)
+
+	msg, err := json.Marshal(PublishEvent{Event: "Map", IsValid: "false", Time: "2017-09-19T14:00:04+01:00"}.V2())
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"content_type":"","event":"Map","valid":false,"level":"","service_name":"","time":"2017-09-19T13:00:04Z","transaction_id":"","uuid":""}`, string(msg))
+}
+
+func TestTransactionEvent_V2(t *testing.T) {
+	transactions := TransactionsV2([]TransactionEvent{
+		{TransactionID: "tid_1", UUID: "uuid_1", ClosedTxn: "1", EventCount: 1, StartTime: "2017-09-19T14:00:02Z", Events: []PublishEvent{{Event: "PublishStart", Time: "2017-09-19T14:00:02Z"}}},
+		{TransactionID: "tid_2", UUID: "uuid_2", ClosedTxn: "0"},
+	})
+
+	startTime := time.Date(2017, 9, 19, 14, 0, 2, 0, time.UTC)
+	assert.Equal(t, []Transaction{
+		{TransactionID: "tid_1", UUID: "uuid_1", Closed: true, EventCount: 1, StartTime: &startTime, Events: []Event{{Event: EventPublishStart, Time: startTime}}},
+		{TransactionID: "tid_2", UUID: "uuid_2", Closed: false, Events: []Event{}},
+	}, transactions)
+	assert.Empty(t, EventsV2(nil))
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	health "github.com/Financial-Times/go-fthealth/v1_1"
+)
+
+const (
+	defaultOpenSearchIndex = "monitoring-*"
+	// openSearchMaxHits is the default max_result_window of an index, and the size of the pages of the longer searches
+	openSearchMaxHits = 10000
+	// openSearchMaxEvents is the max number of events read, page by page, by the searches that aggregate them
+	openSearchMaxEvents           = 100000
+	defaultOpenSearchPollInterval = 5 * time.Second
+	openSearchTimeField           = "@time"
+	openSearchHealthPath          = "/_cluster/health"
+	openSearchHealthStatusRed     = "red"
+	openSearchRequestTimeout      = 2 * time.Minute
+)
+
+type openSearchConfig struct {
+	url         string
+	index       string
+	user        string
+	password    string
+	environment string
+	// streamPollInterval is the interval between searches for the event stream
+	streamPollInterval time.Duration
+}
+
+// openSearchService reads the monitoring events from an OpenSearch or Elasticsearch index,
+// where they are expected to have the same fields as in Splunk. Events are assembled into transactions the same way,
+// but as there is no index time, cursors are based on the event time.
+type openSearchService struct {
+	sync.Mutex
+	HTTPClient *http.Client
+	Config     openSearchConfig
+	lastHealth healthStatus
+}
+
+type openSearchResponse struct {
+	Took int64 `json:"took"`
+	Hits struct {
+		Total struct {
+			Value int `json:"value"`
+		} `json:"total"`
+		Hits []struct {
+			Source publishEvent  `json:"_source"`
+			Sort   []interface{} `json:"sort"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+type openSearchClusterHealth struct {
+	Status string `json:"status"`
+}
+
+// openSearchError is a failed OpenSearch request
+type openSearchError struct {
+	StatusCode int
+	message    string
+}
+
+func (e *openSearchError) Error() string {
+	return e.message
+}
+
+func (e *openSearchError) httpStatus() int {
+	switch {
+	case e.StatusCode == 0, e.StatusCode == http.StatusUnauthorized, e.StatusCode == http.StatusForbidden, e.StatusCode >= 500:
+		return http.StatusBadGateway
+	case e.StatusCode == http.StatusTooManyRequests:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func newOpenSearchService(config openSearchConfig) EventReader {
+	if config.index == "" {
+		config.index = defaultOpenSearchIndex
+	}
+	if config.streamPollInterval == 0 {
+		config.streamPollInterval = defaultOpenSearchPollInterval
+	}
+	return &openSearchService{HTTPClient: &http.Client{Timeout: openSearchRequestTimeout}, Config: config}
+}
+
+func (service *openSearchService) GetTransactions(query monitoringQuery) ([]transactionEvent, error) {
+	transactions, _, err := service.GetTransactionsWithMetadata(query)
+	return transactions, err
+}
+
+func (service *openSearchService) GetTransactionsWithMetadata(query monitoringQuery) ([]transactionEvent, searchMetadata, error) {
+	transactions, metadata, err := service.searchTransactions(query, []string{query.ContentType})
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	return transactions[query.ContentType], metadata, nil
+}
+
+func (service *openSearchService) GetTransactionsByContentType(query monitoringQuery, contentTypes []string) (map[string][]transactionEvent, searchMetadata, error) {
+	return service.searchTransactions(query, contentTypes)
+}
+
+func (service *openSearchService) searchTransactions(query monitoringQuery, contentTypes []string) (map[string][]transactionEvent, searchMetadata, error) {
+	filters := []interface{}{service.contentTypeFilter(contentTypes, true), termsFilter("uuid", query.UUIDs)}
+	earliestTime := query.EarliestTime
+	if earliestTime == "" && query.Cursor != nil {
+		earliestTime = query.Cursor.earliestTime(defaultCursorLookback)
+	} else if earliestTime == "" {
+		earliestTime = defaultEarliestTime
+	}
+	filters = append(filters, timeFilter(earliestTime, query.LatestTime))
+
+	events, metadata, err := service.search(filters, true, openSearchMaxEvents, "desc")
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+
+	transactions := assembleTransactions(events, query, contentTypes)
+	if query.Cursor != nil {
+		for contentType, contentTypeTransactions := range transactions {
+			transactions[contentType] = updatedSince(contentTypeTransactions, query.Cursor)
+		}
+	}
+	return transactions, metadata, nil
+}
+
+func (service *openSearchService) GetLastEvent(query monitoringQuery) (*publishEvent, searchMetadata, error) {
+	events, metadata, err := service.GetLastEvents(query, 1)
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	if len(events) > 0 {
+		return &events[0], metadata, nil
+	}
+	return nil, metadata, ErrNoResults
+}
+
+func (service *openSearchService) GetLastEvents(query monitoringQuery, limit int) ([]publishEvent, searchMetadata, error) {
+	return service.search(service.lastEventFilters(query, []string{query.ContentType}), false, limit, "desc")
+}
+
+func (service *openSearchService) GetLastEventByContentType(query monitoringQuery, contentTypes []string) (map[string]publishEvent, searchMetadata, error) {
+	lastEvents := make(map[string]publishEvent)
+	merged := searchMetadata{Sids: []string{}}
+	for _, contentType := range contentTypes {
+		events, metadata, err := service.search(service.lastEventFilters(query, []string{contentType}), false, 1, "desc")
+		if err != nil {
+			return nil, searchMetadata{}, err
+		}
+		merged.Merge(metadata)
+		if len(events) > 0 {
+			lastEvents[contentType] = events[0]
+		}
+	}
+	return lastEvents, merged, nil
+}
+
+func (service *openSearchService) lastEventFilters(query monitoringQuery, contentTypes []string) []interface{} {
+	events := query.Events
+	if len(events) == 0 {
+		events = []string{defaultLastEvent}
+	}
+	filters := []interface{}{
+		service.contentTypeFilter(contentTypes, false),
+		termsFilter("event", events),
+		termsFilter("uuid", query.UUIDs),
+		termsFilter("service_name", query.Services),
+		termsFilter("level", query.Levels),
+	}
+	if query.EarliestTime != "" {
+		filters = append(filters, timeFilter(query.EarliestTime, ""))
+	}
+	return filters
+}
+
+func (service *openSearchService) GetErrors(query monitoringQuery) ([]errorReport, searchMetadata, error) {
+	filters := []interface{}{
+		service.contentTypeFilter([]string{query.ContentType}, true),
+		timeFilter(orDefault(query.EarliestTime, defaultEarliestTime), query.LatestTime),
+		map[string]interface{}{"bool": map[string]interface{}{
+			"should": []interface{}{
+				map[string]interface{}{"term": map[string]interface{}{"level": "error"}},
+				map[string]interface{}{"term": map[string]interface{}{"isValid": "false"}},
+			},
+			"minimum_should_match": 1,
+		}},
+	}
+	events, metadata, err := service.search(filters, true, openSearchMaxEvents, "desc")
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	return groupErrorReports(events), metadata, nil
+}
+
+func (service *openSearchService) GetThroughput(query monitoringQuery, span string) ([]timeSeries, searchMetadata, error) {
+	spanDuration, err := parseSpan(span)
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	filters := []interface{}{
+		service.contentTypeFilter([]string{query.ContentType}, true),
+		timeFilter(orDefault(query.EarliestTime, defaultEarliestTime), query.LatestTime),
+		termsFilter("event", []string{"PublishStart", "PublishEnd"}),
+	}
+	events, metadata, err := service.search(filters, true, openSearchMaxEvents, "asc")
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+
+	return throughputSeries(events, spanDuration), metadata, nil
+}
+
+// StreamEvents polls the index for new events, as there is no real-time search
+func (service *openSearchService) StreamEvents(ctx context.Context, query monitoringQuery, send func(event publishEvent) error, warn func(warning string)) error {
+	filters := []interface{}{
+		service.contentTypeFilter([]string{query.ContentType}, true),
+		termsFilter("uuid", query.UUIDs),
+		termsFilter("service_name", query.Services),
+		termsFilter("event", query.Events),
+	}
+	since := streamEarliestTime
+	// events logged in the same millisecond as the last one are queried again, so remember which ones were sent
+	sent := make(map[publishEvent]bool)
+	for {
+		events, _, err := service.search(append(filters, timeFilter(since, "")), true, openSearchMaxHits, "asc")
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			// the same instant can be logged in several forms, e.g. with another zone
+			if isLaterEventTime(event.Time, since) {
+				since = event.Time
+				sent = make(map[publishEvent]bool)
+			}
+			if sent[event] {
+				continue
+			}
+			sent[event] = true
+			if err = send(event); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(service.Config.streamPollInterval):
+		}
+	}
+}
+
+func (service *openSearchService) IsHealthy() healthStatus {
+	service.Lock()
+	defer service.Unlock()
+	if time.Now().Before(service.lastHealth.time.Add(healthCachePeriod)) {
+		return service.lastHealth
+	}
+
+	req, err := http.NewRequest("GET", service.Config.url+openSearchHealthPath, nil)
+	if err != nil {
+		return healthStatus{message: "OpenSearch error", err: err, time: time.Now()}
+	}
+	clusterHealth := openSearchClusterHealth{}
+	if err = service.do(req, &clusterHealth); err == nil && clusterHealth.Status == openSearchHealthStatusRed {
+		err = fmt.Errorf("OpenSearch cluster status is %s", clusterHealth.Status)
+	}
+	service.updateHealth(err)
+	return service.lastHealth
+}
+
+func (service *openSearchService) HealthChecks() []health.Check {
+	return nil
+}
+
+// updateHealth is called with the lock held
+func (service *openSearchService) updateHealth(err error) {
+	if err != nil {
+		service.lastHealth = healthStatus{message: "OpenSearch error", err: err, time: time.Now()}
+	} else {
+		service.lastHealth = healthStatus{message: "OpenSearch is ok", time: time.Now()}
+	}
+}
+
+// search returns up to limit monitoring events of the environment matching the filters, sorted by time,
+// reading them page by page when there are more than a search returns
+func (service *openSearchService) search(filters []interface{}, excludeSynthetic bool, limit int, order string) ([]publishEvent, searchMetadata, error) {
+	filters = append(filters,
+		map[string]interface{}{"term": map[string]interface{}{"monitoring_event": "true"}},
+		map[string]interface{}{"bool": map[string]interface{}{
+			"should": []interface{}{
+				map[string]interface{}{"term": map[string]interface{}{"environment": service.Config.environment}},
+				map[string]interface{}{"prefix": map[string]interface{}{"environment": regionRegex.ReplaceAllString(service.Config.environment, "") + "-publish"}},
+			},
+			"minimum_should_match": 1,
+		}},
+	)
+	var nonEmpty []interface{}
+	for _, filter := range filters {
+		if filter != nil {
+			nonEmpty = append(nonEmpty, filter)
+		}
+	}
+	boolQuery := map[string]interface{}{"filter": nonEmpty}
+	if excludeSynthetic {
+		boolQuery["must_not"] = []interface{}{
+			map[string]interface{}{"wildcard": map[string]interface{}{"transaction_id": "SYNTHETIC*"}},
+			map[string]interface{}{"wildcard": map[string]interface{}{"transaction_id": "*carousel*"}},
+		}
+	}
+	// the pages follow each other with search_after, with the per shard document order breaking the time ties
+	sort := []interface{}{
+		map[string]interface{}{openSearchTimeField: map[string]interface{}{"order": order}},
+		map[string]interface{}{"_doc": map[string]interface{}{"order": order}},
+	}
+	events := []publishEvent{}
+	metadata := searchMetadata{Sids: []string{}}
+	var searchAfter []interface{}
+	total := 0
+	for len(events) < limit {
+		size := limit - len(events)
+		if size > openSearchMaxHits {
+			size = openSearchMaxHits
+		}
+		request := map[string]interface{}{
+			"query":            map[string]interface{}{"bool": boolQuery},
+			"size":             size,
+			"sort":             sort,
+			"track_total_hits": true,
+		}
+		if searchAfter != nil {
+			request["search_after"] = searchAfter
+		}
+		response, err := service.searchPage(request)
+		if err != nil {
+			return nil, searchMetadata{}, err
+		}
+		metadata.Duration += float64(response.Took) / 1000
+		total = response.Hits.Total.Value
+
+		for _, hit := range response.Hits.Hits {
+			event := hit.Source
+			// there is no index time, so cursors are based on the event time
+			if t, err := time.Parse(time.RFC3339Nano, event.Time); err == nil {
+				event.IndexTime = strconv.FormatInt(t.Unix(), 10)
+			}
+			events = append(events, event)
+		}
+		if len(response.Hits.Hits) == 0 || len(events) >= total || response.Hits.Hits[len(response.Hits.Hits)-1].Sort == nil {
+			break
+		}
+		searchAfter = response.Hits.Hits[len(response.Hits.Hits)-1].Sort
+	}
+
+	metadata.EventCount = len(events)
+	if total > len(events) {
+		metadata.Truncated = true
+		// the searches that aggregate the events miss some of them, the others only expect the first ones
+		if limit == openSearchMaxEvents {
+			metadata.Warnings = append(metadata.Warnings, fmt.Sprintf("Only %d of the %d matching events were read", len(events), total))
+		}
+	}
+	return events, metadata, nil
+}
+
+// searchPage sends a search request to the index, and updates the health with its outcome
+func (service *openSearchService) searchPage(request map[string]interface{}) (*openSearchResponse, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/%s/_search", service.Config.url, service.Config.index), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	response := openSearchResponse{}
+	err = service.do(req, &response)
+	service.Lock()
+	service.updateHealth(err)
+	service.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (service *openSearchService) do(req *http.Request, result interface{}) error {
+	if service.Config.user != "" {
+		req.SetBasicAuth(service.Config.user, service.Config.password)
+	}
+	resp, err := service.HTTPClient.Do(req)
+	if err != nil {
+		return &openSearchError{message: fmt.Sprintf("OpenSearch request failed: %v", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return &openSearchError{StatusCode: resp.StatusCode, message: fmt.Sprintf("OpenSearch responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))}
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// contentTypeFilter matches the content types case insensitively, like Splunk, optionally with the events without content type
+func (service *openSearchService) contentTypeFilter(contentTypes []string, includeUntyped bool) interface{} {
+	var should []interface{}
+	for _, contentType := range contentTypes {
+		should = append(should, map[string]interface{}{"term": map[string]interface{}{"content_type": map[string]interface{}{"value": contentType, "case_insensitive": true}}})
+	}
+	if includeUntyped {
+		should = append(should,
+			map[string]interface{}{"term": map[string]interface{}{"content_type": ""}},
+			map[string]interface{}{"bool": map[string]interface{}{"must_not": map[string]interface{}{"exists": map[string]interface{}{"field": "content_type"}}}},
+		)
+	}
+	return map[string]interface{}{"bool": map[string]interface{}{"should": should, "minimum_should_match": 1}}
+}
+
+func termsFilter(field string, values []string) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"terms": map[string]interface{}{field: values}}
+}
+
+func timeFilter(earliestTime string, latestTime string) interface{} {
+	timeRange := map[string]interface{}{"gte": openSearchTime(earliestTime)}
+	if latestTime != "" {
+		timeRange["lte"] = openSearchTime(latestTime)
+	}
+	return map[string]interface{}{"range": map[string]interface{}{openSearchTimeField: timeRange}}
+}
+
+// openSearchTime converts the relative (-10m) and epoch times of the Splunk searches to OpenSearch date math
+func openSearchTime(value string) string {
+	if relativeTimeRegex.MatchString(value) {
+		return "now" + value
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+	}
+	return value
+}
+
+func orDefault(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const openSearchHitsSample = `{
+	"took": 12,
+	"hits": {
+		"total": {"value": 4},
+		"hits": [
+			{"_source": {"content_type": "annotations", "event": "Map", "service_name": "annotations-mapper", "@time": "2017-09-19T15:10:03.000Z", "transaction_id": "tid_1", "uuid": "uuid_1", "environment": "test", "monitoring_event": "true"}},
+			{"_source": {"content_type": "annotations", "event": "PublishStart", "service_name": "cms-notifier", "@time": "2017-09-19T15:10:01.000Z", "transaction_id": "tid_1", "uuid": "uuid_1", "environment": "test", "monitoring_event": "true"}},
+			{"_source": {"content_type": "annotations", "event": "PublishEnd", "service_name": "annotations-rw", "@time": "2017-09-19T15:10:02.000Z", "transaction_id": "tid_2", "uuid": "uuid_2", "environment": "test", "monitoring_event": "true"}}
+		]
+	}
+}`
+
+func newOpenSearchServer(t *testing.T, status int, response string, requests *[]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		assert.Equal(t, "user", user)
+		assert.Equal(t, "password", password)
+		if r.URL.Path == openSearchHealthPath {
+			w.Write([]byte(`{"status": "yellow"}`))
+			return
+		}
+
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/monitoring-*/_search", r.URL.Path)
+		body := make(map[string]interface{})
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		*requests = append(*requests, body)
+
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+}
+
+func newTestOpenSearchService(url string) EventReader {
+	return newOpenSearchService(openSearchConfig{url: url, user: "user", password: "password", environment: "upp-prod-delivery-eu"})
+}
+
+func TestOpenSearchService_GetTransactions(t *testing.T) {
+	var requests []map[string]interface{}
+	server := newOpenSearchServer(t, http.StatusOK, openSearchHitsSample, &requests)
+	defer server.Close()
+
+	transactions, metadata, err := newTestOpenSearchService(server.URL).GetTransactionsWithMetadata(monitoringQuery{ContentType: "annotations", EarliestTime: "-30m"})
+	assert.NoError(t, err)
+	assert.True(t, metadata.Truncated)
+	assert.Equal(t, 3, metadata.EventCount)
+
+	// only the open transactions are returned
+	assert.Len(t, transactions, 1)
+	assert.Equal(t, "tid_1", transactions[0].TransactionID)
+	assert.Equal(t, 2, transactions[0].EventCount)
+	assert.Equal(t, "2017-09-19T15:10:01.000Z", transactions[0].StartTime)
+	assert.Equal(t, "1505833803", transactions[0].Events[0].IndexTime)
+
+	query, _ := json.Marshal(requests[0]["query"])
+	assert.Contains(t, string(query), `"gte":"now-30m"`)
+	assert.Contains(t, string(query), `{"prefix":{"environment":"upp-prod-publish"}}`)
+	assert.Contains(t, string(query), `{"wildcard":{"transaction_id":"SYNTHETIC*"}}`)
+	assert.Contains(t, string(query), `"case_insensitive":true,"value":"annotations"`)
+	assert.Equal(t, float64(openSearchMaxHits), requests[0]["size"])
+}
+
+func TestOpenSearchService_GetTransactionsPaged(t *testing.T) {
+	var requests []map[string]interface{}
+	pages := map[string]string{
+		"": `{"took": 10, "hits": {"total": {"value": 4}, "hits": [
+			{"_source": {"content_type": "annotations", "event": "PublishEnd", "service_name": "annotations-rw", "@time": "2017-09-19T15:10:03.000Z", "transaction_id": "tid_1", "uuid": "uuid_1"}, "sort": [1505833803000, 2]},
+			{"_source": {"content_type": "annotations", "event": "PublishStart", "service_name": "cms-notifier", "@time": "2017-09-19T15:10:02.000Z", "transaction_id": "tid_2", "uuid": "uuid_2"}, "sort": [1505833802000, 1]}
+		]}}`,
+		"[1505833802000,1]": `{"took": 5, "hits": {"total": {"value": 4}, "hits": [
+			{"_source": {"content_type": "annotations", "event": "PublishStart", "service_name": "cms-notifier", "@time": "2017-09-19T15:10:01.000Z", "transaction_id": "tid_1", "uuid": "uuid_1"}, "sort": [1505833801000, 0]}
+		]}}`,
+		// the last event was deleted since the first page
+		"[1505833801000,0]": `{"took": 1, "hits": {"total": {"value": 4}, "hits": []}}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make(map[string]interface{})
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		requests = append(requests, body)
+		searchAfter := ""
+		if body["search_after"] != nil {
+			encoded, _ := json.Marshal(body["search_after"])
+			searchAfter = string(encoded)
+		}
+		page, found := pages[searchAfter]
+		assert.True(t, found, searchAfter)
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+
+	transactions, metadata, err := newTestOpenSearchService(server.URL).GetTransactionsWithMetadata(monitoringQuery{ContentType: "annotations"})
+	assert.NoError(t, err)
+	assert.Len(t, requests, 3)
+	assert.Equal(t, 3, metadata.EventCount)
+	assert.Equal(t, 0.016, metadata.Duration)
+	assert.True(t, metadata.Truncated)
+	assert.Equal(t, []string{"Only 3 of the 4 matching events were read"}, metadata.Warnings)
+
+	// the transaction started on the first page is completed with the event of the second
+	assert.Len(t, transactions, 1)
+	assert.Equal(t, "tid_2", transactions[0].TransactionID)
+	sort, _ := json.Marshal(requests[0]["sort"])
+	assert.Equal(t, `[{"@time":{"order":"desc"}},{"_doc":{"order":"desc"}}]`, string(sort))
+}
+
+func TestOpenSearchService_GetTransactionsSinceCursor(t *testing.T) {
+	var requests []map[string]interface{}
+	server := newOpenSearchServer(t, http.StatusOK, openSearchHitsSample, &requests)
+	defer server.Close()
+
+	cursor := eventCursor{IndexTime: 1505833803}
+	transactions, err := newTestOpenSearchService(server.URL).GetTransactions(monitoringQuery{ContentType: "annotations", Cursor: &cursor})
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 1)
+
+	cursor.IndexTime++
+	transactions, err = newTestOpenSearchService(server.URL).GetTransactions(monitoringQuery{ContentType: "annotations", Cursor: &cursor})
+	assert.NoError(t, err)
+	assert.Empty(t, transactions)
+}
+
+func TestOpenSearchService_GetLastEvent(t *testing.T) {
+	var requests []map[string]interface{}
+	server := newOpenSearchServer(t, http.StatusOK, openSearchHitsSample, &requests)
+	defer server.Close()
+
+	event, _, err := newTestOpenSearchService(server.URL).GetLastEvent(monitoringQuery{ContentType: "annotations"})
+	assert.NoError(t, err)
+	assert.Equal(t, "annotations-mapper", event.ServiceName)
+	assert.Equal(t, float64(1), requests[0]["size"])
+	query, _ := json.Marshal(requests[0]["query"])
+	assert.Contains(t, string(query), `{"terms":{"event":["PublishEnd"]}}`)
+	assert.NotContains(t, string(query), "must_not")
+
+	empty := newOpenSearchServer(t, http.StatusOK, `{"hits": {"total": {"value": 0}, "hits": []}}`, &requests)
+	defer empty.Close()
+	_, _, err = newTestOpenSearchService(empty.URL).GetLastEvent(monitoringQuery{ContentType: "annotations"})
+	assert.True(t, errors.Is(err, ErrNoResults))
+}
+
+func TestOpenSearchService_GetThroughput(t *testing.T) {
+	var requests []map[string]interface{}
+	server := newOpenSearchServer(t, http.StatusOK, openSearchHitsSample, &requests)
+	defer server.Close()
+
+	series, _, err := newTestOpenSearchService(server.URL).GetThroughput(monitoringQuery{ContentType: "annotations"}, "1m")
+	assert.NoError(t, err)
+	assert.Equal(t, []timeSeries{
+		{Target: "started", Datapoints: [][2]float64{{2, 1505833800000}}},
+		{Target: "completed", Datapoints: [][2]float64{{1, 1505833800000}}},
+		{Target: "open", Datapoints: [][2]float64{{1, 1505833800000}}},
+	}, series)
+}
+
+func TestOpenSearchService_StreamEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make(map[string]interface{})
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		requests = append(requests, body)
+		if len(requests) == 3 {
+			cancel()
+		}
+		// the events of the last millisecond are returned again by each poll
+		w.Write([]byte(`{"hits": {"total": {"value": 2}, "hits": [
+			{"_source": {"content_type": "annotations", "event": "PublishStart", "@time": "2017-09-19T15:10:01.000Z", "transaction_id": "tid_1"}},
+			{"_source": {"content_type": "annotations", "event": "PublishEnd", "@time": "2017-09-19T15:10:01.000+00:00", "transaction_id": "tid_1"}}
+		]}}`))
+	}))
+	defer server.Close()
+
+	var sent []string
+	service := newOpenSearchService(openSearchConfig{url: server.URL, environment: "upp-prod-delivery-eu", streamPollInterval: time.Millisecond})
+	err := service.StreamEvents(ctx, monitoringQuery{ContentType: "annotations"}, func(event publishEvent) error {
+		sent = append(sent, event.Event)
+		return nil
+	}, func(warning string) {})
+	assert.NoError(t, err)
+	assert.Len(t, requests, 3)
+	assert.Equal(t, []string{"PublishStart", "PublishEnd"}, sent)
+	query, _ := json.Marshal(requests[2]["query"])
+	assert.Contains(t, string(query), `"gte":"2017-09-19T15:10:01.000Z"`)
+}
+
+func TestOpenSearchService_Errors(t *testing.T) {
+	tests := []struct {
+		status         int
+		expectedStatus int
+	}{
+		{http.StatusUnauthorized, http.StatusBadGateway},
+		{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+		{http.StatusBadRequest, http.StatusInternalServerError},
+		{http.StatusServiceUnavailable, http.StatusBadGateway},
+	}
+	for _, test := range tests {
+		var requests []map[string]interface{}
+		server := newOpenSearchServer(t, test.status, `{"error": "failed"}`, &requests)
+		service := newTestOpenSearchService(server.URL)
+		_, err := service.GetTransactions(monitoringQuery{ContentType: "annotations"})
+		assert.Error(t, err)
+		assert.True(t, strings.Contains(err.Error(), "failed"))
+		assert.Equal(t, test.expectedStatus, backendErrorStatus(err))
+		server.Close()
+	}
+}
+
+func TestOpenSearchService_IsHealthy(t *testing.T) {
+	var requests []map[string]interface{}
+	server := newOpenSearchServer(t, http.StatusOK, openSearchHitsSample, &requests)
+	defer server.Close()
+	assert.NoError(t, newTestOpenSearchService(server.URL).IsHealthy().err)
+
+	red := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "red"}`))
+	}))
+	defer red.Close()
+	assert.Error(t, newOpenSearchService(openSearchConfig{url: red.URL}).IsHealthy().err)
+}
+
+func TestOpenSearchTime(t *testing.T) {
+	assert.Equal(t, "now-10m", openSearchTime("-10m"))
+	assert.Equal(t, "2017-09-19T15:10:03Z", openSearchTime("1505833803"))
+	assert.Equal(t, "2017-09-19T15:10:03Z", openSearchTime(time.Unix(1505833803, 0).UTC().Format(time.RFC3339)))
+}
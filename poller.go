@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	health "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/Financial-Times/go-logger/v2"
+)
+
+const pollerMaxBackoff = 10 * time.Minute
+
+type transactionsSnapshot struct {
+	transactions []transactionEvent
+	metadata     searchMetadata
+	time         time.Time
+}
+
+// transactionsPoller periodically runs the transactions query for a content type and keeps the latest results in memory
+type transactionsPoller struct {
+	sync.RWMutex
+	contentType  string
+	earliestTime string
+	interval     time.Duration
+	eventReader  EventReader
+	log          *logger.UPPLogger
+	snapshot     *transactionsSnapshot
+	failures     int
+	stop         chan struct{}
+}
+
+func newTransactionsPoller(contentType string, interval time.Duration, eventReader EventReader, log *logger.UPPLogger) *transactionsPoller {
+	return &transactionsPoller{
+		contentType:  contentType,
+		earliestTime: defaultEarliestTime,
+		interval:     interval,
+		eventReader:  eventReader,
+		log:          log,
+		stop:         make(chan struct{}),
+	}
+}
+
+func (p *transactionsPoller) start() {
+	go func() {
+		for {
+			wait := p.poll()
+			select {
+			case <-p.stop:
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+func (p *transactionsPoller) close() {
+	close(p.stop)
+}
+
+// poll refreshes the snapshot and returns how long to wait before the next poll
+func (p *transactionsPoller) poll() time.Duration {
+	transactions, metadata, err := p.eventReader.GetTransactionsWithMetadata(monitoringQuery{ContentType: p.contentType, EarliestTime: p.earliestTime})
+
+	p.Lock()
+	defer p.Unlock()
+	if err != nil {
+		p.failures++
+		backoff := p.backoff()
+		p.log.WithError(err).Warnf("Polling %s transactions failed %d time(s), retrying in %v", p.contentType, p.failures, backoff)
+		return backoff
+	}
+	p.failures = 0
+	p.snapshot = &transactionsSnapshot{transactions: transactions, metadata: metadata, time: time.Now()}
+	return p.interval
+}
+
+func (p *transactionsPoller) backoff() time.Duration {
+	backoff := p.interval
+	for i := 1; i < p.failures && backoff < pollerMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > pollerMaxBackoff {
+		backoff = pollerMaxBackoff
+	}
+	return backoff
+}
+
+// transactions returns the snapshot if it answers the query and is not older than twice the poll interval
+func (p *transactionsPoller) transactions(query monitoringQuery) ([]transactionEvent, searchMetadata, bool) {
+	if query.ContentType != p.contentType || len(query.UUIDs) > 0 || query.LatestTime != "" || query.Cursor != nil {
+		return nil, searchMetadata{}, false
+	}
+	if query.EarliestTime != "" && query.EarliestTime != p.earliestTime {
+		return nil, searchMetadata{}, false
+	}
+
+	p.RLock()
+	defer p.RUnlock()
+	if p.snapshot == nil || time.Since(p.snapshot.time) > 2*p.interval {
+		return nil, searchMetadata{}, false
+	}
+	transactions := []transactionEvent{}
+	for _, transaction := range p.snapshot.transactions {
+		if hasMatchingEvent(transaction, query) {
+			transactions = append(transactions, transaction)
+		}
+	}
+	return transactions, p.snapshot.metadata, true
+}
+
+func (p *transactionsPoller) healthCheck() health.Check {
+	return health.Check{
+		ID:               "transactions-poller-" + p.contentType,
+		BusinessImpact:   "No business impact, transactions are read directly from Splunk while the snapshot is stale",
+		Name:             fmt.Sprintf("%s transactions snapshot", p.contentType),
+		PanicGuide:       "https://dewey.ft.com/splunk-event-reader.html",
+		Severity:         3,
+		TechnicalSummary: "The background poller was not able to refresh the open transactions snapshot. Check Splunk REST API availability.",
+		Checker: func() (string, error) {
+			p.RLock()
+			defer p.RUnlock()
+			if p.snapshot == nil {
+				return "No snapshot", fmt.Errorf("no %s transactions snapshot taken yet, %d failed poll(s)", p.contentType, p.failures)
+			}
+			age := time.Since(p.snapshot.time).Round(time.Second)
+			if age > 2*p.interval {
+				return fmt.Sprintf("Snapshot age %v", age), fmt.Errorf("%s transactions snapshot is %v old, %d failed poll(s)", p.contentType, age, p.failures)
+			}
+			return fmt.Sprintf("Snapshot age %v", age), nil
+		},
+	}
+}
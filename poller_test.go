@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	health "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockEventReader struct {
+	transactions []transactionEvent
+	errors       []errorReport
+	series       []timeSeries
+	metadata     searchMetadata
+	lastEvent    *publishEvent
+	events       []publishEvent
+	err          error
+	queries      []monitoringQuery
+}
+
+func (m *mockEventReader) GetTransactions(query monitoringQuery) ([]transactionEvent, error) {
+	m.queries = append(m.queries, query)
+	return m.transactions, m.err
+}
+
+func (m *mockEventReader) GetTransactionsWithMetadata(query monitoringQuery) ([]transactionEvent, searchMetadata, error) {
+	transactions, err := m.GetTransactions(query)
+	return transactions, m.metadata, err
+}
+
+func (m *mockEventReader) GetTransactionsByContentType(query monitoringQuery, contentTypes []string) (map[string][]transactionEvent, searchMetadata, error) {
+	m.queries = append(m.queries, query)
+	transactions := make(map[string][]transactionEvent)
+	for _, contentType := range contentTypes {
+		transactions[contentType] = m.transactions
+	}
+	return transactions, m.metadata, m.err
+}
+
+func (m *mockEventReader) GetLastEventByContentType(query monitoringQuery, contentTypes []string) (map[string]publishEvent, searchMetadata, error) {
+	m.queries = append(m.queries, query)
+	events := make(map[string]publishEvent)
+	if m.lastEvent != nil {
+		for _, contentType := range contentTypes {
+			events[contentType] = *m.lastEvent
+		}
+	}
+	return events, m.metadata, m.err
+}
+
+func (m *mockEventReader) GetLastEvent(query monitoringQuery) (*publishEvent, searchMetadata, error) {
+	m.queries = append(m.queries, query)
+	return m.lastEvent, m.metadata, m.err
+}
+
+func (m *mockEventReader) GetLastEvents(query monitoringQuery, limit int) ([]publishEvent, searchMetadata, error) {
+	m.queries = append(m.queries, query)
+	if len(m.events) > limit {
+		return m.events[:limit], m.metadata, m.err
+	}
+	return m.events, m.metadata, m.err
+}
+
+func (m *mockEventReader) GetErrors(query monitoringQuery) ([]errorReport, searchMetadata, error) {
+	m.queries = append(m.queries, query)
+	return m.errors, m.metadata, m.err
+}
+
+func (m *mockEventReader) GetThroughput(query monitoringQuery, span string) ([]timeSeries, searchMetadata, error) {
+	m.queries = append(m.queries, query)
+	return m.series, m.metadata, m.err
+}
+
+func (m *mockEventReader) StreamEvents(ctx context.Context, query monitoringQuery, send func(event publishEvent) error, warn func(warning string)) error {
+	m.queries = append(m.queries, query)
+	for _, event := range m.events {
+		if err := send(event); err != nil {
+			return err
+		}
+	}
+	for _, warning := range m.metadata.Warnings {
+		warn(warning)
+	}
+	return m.err
+}
+
+func (m *mockEventReader) IsHealthy() healthStatus {
+	return healthStatus{message: "Splunk is ok"}
+}
+
+func (m *mockEventReader) HealthChecks() []health.Check {
+	return nil
+}
+
+func TestTransactionsPoller(t *testing.T) {
+	expectedTx := []transactionEvent{{TransactionID: "tid_test", ClosedTxn: "0"}}
+	splunk := &mockEventReader{transactions: expectedTx}
+	poller := newTransactionsPoller("annotations", time.Minute, splunk, logger.NewUPPLogger("test", "INFO"))
+
+	_, _, ok := poller.transactions(monitoringQuery{ContentType: "annotations"})
+	assert.False(t, ok, "no snapshot before the first poll")
+
+	check := poller.healthCheck()
+	_, err := check.Checker()
+	assert.Error(t, err)
+
+	assert.Equal(t, time.Minute, poller.poll())
+	assert.Equal(t, []monitoringQuery{{ContentType: "annotations", EarliestTime: defaultEarliestTime}}, splunk.queries)
+
+	tests := []struct {
+		query   monitoringQuery
+		covered bool
+	}{
+		{monitoringQuery{ContentType: "annotations"}, true},
+		{monitoringQuery{ContentType: "annotations", EarliestTime: defaultEarliestTime}, true},
+		{monitoringQuery{ContentType: "annotations", EarliestTime: "-30m"}, false},
+		{monitoringQuery{ContentType: "annotations", LatestTime: "-5m"}, false},
+		{monitoringQuery{ContentType: "annotations", UUIDs: []string{"27355ee6-e280-4fb8-b825-8f14be1be9d3"}}, false},
+	}
+	for _, test := range tests {
+		tx, _, ok := poller.transactions(test.query)
+		assert.Equal(t, test.covered, ok)
+		if test.covered {
+			assert.Equal(t, expectedTx, tx)
+		}
+	}
+
+	_, err = check.Checker()
+	assert.NoError(t, err)
+
+	splunk.err = errors.New("503 Service Unavailable")
+	assert.Equal(t, time.Minute, poller.poll())
+	assert.Equal(t, 2*time.Minute, poller.poll())
+	assert.Equal(t, 4*time.Minute, poller.poll())
+	for i := 0; i < 5; i++ {
+		poller.poll()
+	}
+	assert.Equal(t, pollerMaxBackoff, poller.poll())
+
+	_, _, ok = poller.transactions(monitoringQuery{ContentType: "annotations"})
+	assert.True(t, ok, "last good snapshot is kept while polling fails")
+}
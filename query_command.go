@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	outputJSON   = "json"
+	outputNDJSON = "ndjson"
+	outputTable  = "table"
+)
+
+// queryOutput is the result of a query command, written as the JSON body the HTTP API would return, as one JSON line
+// per item, or as a table of the item rows
+type queryOutput struct {
+	result interface{}
+	items  []interface{}
+	header []string
+	rows   [][]string
+}
+
+func isValidOutputFormat(format string) bool {
+	return format == outputJSON || format == outputNDJSON || format == outputTable
+}
+
+// queryParams returns the query options of a command as the parameters of the matching HTTP endpoint, so that they are
+// validated the same way
+func queryParams(earliestTime string, latestTime string, uuids []string, services []string, events []string, levels []string) url.Values {
+	params := url.Values{}
+	if earliestTime != "" {
+		params.Set(earliestTimePathVar, earliestTime)
+	}
+	if latestTime != "" {
+		params.Set(latestTimePathVar, latestTime)
+	}
+	for name, values := range map[string][]string{uuidPathVar: uuids, servicePathVar: services, eventPathVar: events, levelPathVar: levels} {
+		if len(values) > 0 {
+			params[name] = values
+		}
+	}
+	return params
+}
+
+func transactionsOutput(transactions []transactionEvent) queryOutput {
+	output := queryOutput{result: transactions, header: []string{"TRANSACTION ID", "UUID", "CLOSED", "EVENTS", "START TIME"}}
+	for _, transaction := range transactions {
+		output.items = append(output.items, transaction)
+		output.rows = append(output.rows, []string{transaction.TransactionID, transaction.UUID, transaction.ClosedTxn, strconv.Itoa(transaction.EventCount), transaction.StartTime})
+	}
+	return output
+}
+
+// eventsOutput returns the output of events; result is the single last event or the list of events
+func eventsOutput(result interface{}, events []publishEvent) queryOutput {
+	output := queryOutput{result: result, header: []string{"TIME", "CONTENT TYPE", "EVENT", "SERVICE", "LEVEL", "TRANSACTION ID", "UUID"}}
+	for _, event := range events {
+		output.items = append(output.items, event)
+		output.rows = append(output.rows, []string{event.Time, event.ContentType, event.Event, event.ServiceName, event.Level, event.TransactionID, event.UUID})
+	}
+	return output
+}
+
+func (output queryOutput) write(writer io.Writer, format string) error {
+	switch format {
+	case outputNDJSON:
+		encoder := json.NewEncoder(writer)
+		for _, item := range output.items {
+			if err := encoder.Encode(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case outputTable:
+		table := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, strings.Join(output.header, "\t"))
+		for _, row := range output.rows {
+			fmt.Fprintln(table, strings.Join(row, "\t"))
+		}
+		return table.Flush()
+	default:
+		msg, err := json.MarshalIndent(output.result, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(writer, string(msg))
+		return err
+	}
+}
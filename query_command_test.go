@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var queryCommandTransactions = []transactionEvent{
+	{TransactionID: "tid_1", UUID: "uuid_1", ClosedTxn: "0", EventCount: 1, StartTime: "2017-09-19T14:00:02Z", Events: []publishEvent{{Event: "PublishStart", TransactionID: "tid_1", UUID: "uuid_1"}}},
+	{TransactionID: "tid_2", UUID: "uuid_2", ClosedTxn: "1", EventCount: 2, StartTime: "2017-09-19T14:00:01Z"},
+}
+
+func TestQueryOutput_Transactions(t *testing.T) {
+	var output bytes.Buffer
+	assert.NoError(t, transactionsOutput(queryCommandTransactions).write(&output, outputTable))
+	assert.Equal(t, "TRANSACTION ID  UUID    CLOSED  EVENTS  START TIME\n"+
+		"tid_1           uuid_1  0       1       2017-09-19T14:00:02Z\n"+
+		"tid_2           uuid_2  1       2       2017-09-19T14:00:01Z\n", output.String())
+
+	output.Reset()
+	assert.NoError(t, transactionsOutput(queryCommandTransactions).write(&output, outputNDJSON))
+	lines := bytes.Split(bytes.TrimSpace(output.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 2)
+	assert.JSONEq(t, `{"transaction_id":"tid_2","uuid":"uuid_2","closed_txn":"1","eventcount":2,"events":null,"start_time":"2017-09-19T14:00:01Z"}`, string(lines[1]))
+
+	output.Reset()
+	assert.NoError(t, transactionsOutput(queryCommandTransactions[1:]).write(&output, outputJSON))
+	assert.JSONEq(t, `[{"transaction_id":"tid_2","uuid":"uuid_2","closed_txn":"1","eventcount":2,"events":null,"start_time":"2017-09-19T14:00:01Z"}]`, output.String())
+}
+
+func TestQueryOutput_Events(t *testing.T) {
+	event := publishEvent{ContentType: "Annotations", Event: "PublishEnd", Level: "info", ServiceName: "annotations-rw", Time: "2017-09-19T14:00:03Z", TransactionID: "tid_1", UUID: "uuid_1"}
+
+	var output bytes.Buffer
+	assert.NoError(t, eventsOutput(&event, []publishEvent{event}).write(&output, outputJSON))
+	assert.JSONEq(t, `{"content_type":"Annotations","event":"PublishEnd","level":"info","service_name":"annotations-rw","@time":"2017-09-19T14:00:03Z","transaction_id":"tid_1","uuid":"uuid_1"}`, output.String())
+
+	output.Reset()
+	assert.NoError(t, eventsOutput([]publishEvent{event}, []publishEvent{event}).write(&output, outputTable))
+	assert.Equal(t, "TIME                  CONTENT TYPE  EVENT       SERVICE         LEVEL  TRANSACTION ID  UUID\n"+
+		"2017-09-19T14:00:03Z  Annotations   PublishEnd  annotations-rw  info   tid_1           uuid_1\n", output.String())
+
+	output.Reset()
+	assert.NoError(t, eventsOutput([]publishEvent{}, nil).write(&output, outputNDJSON))
+	assert.Empty(t, output.String())
+}
+
+func TestQueryParams(t *testing.T) {
+	params := queryParams("-30m", "", []string{"0dd0a85f-2926-4371-a0d8-2ae13d738476"}, nil, []string{"PublishEnd", "Map"}, nil)
+	assert.Equal(t, url.Values{"earliestTime": {"-30m"}, "uuid": {"0dd0a85f-2926-4371-a0d8-2ae13d738476"}, "event": {"PublishEnd", "Map"}}, params)
+
+	query, err := parseTransactionsQuery(params)
+	assert.NoError(t, err)
+	assert.Equal(t, monitoringQuery{EarliestTime: "-30m", UUIDs: []string{"0dd0a85f-2926-4371-a0d8-2ae13d738476"}, Events: []string{"PublishEnd", "Map"}}, query)
+
+	_, err = parseTransactionsQuery(queryParams("yesterday", "", nil, nil, nil, nil))
+	assert.Error(t, err)
+
+	assert.True(t, isValidOutputFormat(outputTable))
+	assert.False(t, isValidOutputFormat("xml"))
+}
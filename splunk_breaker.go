@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	breakerStateMetric         = "splunk.breaker.state"
+	defaultBreakerFailureRatio = 0.5
+	defaultBreakerWindow       = 20
+	defaultBreakerOpenDuration = 30 * time.Second
+	defaultBreakerTrials       = 1
+)
+
+type breakerState int64
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (state breakerState) String() string {
+	switch state {
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+// breakerConfig sets when the circuit breaker opens and how it recovers
+type breakerConfig struct {
+	// failureRatio of the searches in the window that opens the breaker
+	failureRatio float64
+	// window is the number of latest searches the failure ratio is computed on, the breaker is disabled if 0
+	window       int
+	openDuration time.Duration
+	// trials is the number of successful trial searches that close a half-open breaker
+	trials int
+}
+
+var defaultBreakerConfig = breakerConfig{
+	failureRatio: defaultBreakerFailureRatio,
+	window:       defaultBreakerWindow,
+	openDuration: defaultBreakerOpenDuration,
+	trials:       defaultBreakerTrials,
+}
+
+// circuitBreaker stops searches from being sent to Splunk while most of them fail,
+// until a trial search succeeds after the open duration
+type circuitBreaker struct {
+	sync.Mutex
+	config breakerConfig
+	state  breakerState
+	// outcomes is a ring of the latest search results, true for failures
+	outcomes  []bool
+	next      int
+	failures  int
+	openedAt  time.Time
+	trials    int
+	successes int
+	now       func() time.Time
+}
+
+func newCircuitBreaker(config breakerConfig) *circuitBreaker {
+	return &circuitBreaker{config: config, outcomes: make([]bool, 0, config.window), now: time.Now}
+}
+
+func (config breakerConfig) validate() error {
+	if config.window < 0 {
+		return fmt.Errorf("window must not be negative, it is %d", config.window)
+	}
+	if config.failureRatio <= 0 || config.failureRatio > 1 {
+		return fmt.Errorf("failure ratio must be above 0 and at most 1, it is %v", config.failureRatio)
+	}
+	if config.openDuration <= 0 {
+		return fmt.Errorf("open duration must be positive, it is %v", config.openDuration)
+	}
+	if config.trials < 1 {
+		return fmt.Errorf("trials must be at least 1, it is %d", config.trials)
+	}
+	return nil
+}
+
+// allow fails fast while the breaker is open, and lets a limited number of trial searches through once it is half-open
+func (breaker *circuitBreaker) allow() error {
+	if breaker.config.window == 0 {
+		return nil
+	}
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	breaker.halfOpenIfDue()
+	switch breaker.state {
+	case breakerOpen:
+		return breaker.openError()
+	case breakerHalfOpen:
+		if breaker.trials >= breaker.config.trials {
+			return breaker.openError()
+		}
+		breaker.trials++
+	}
+	return nil
+}
+
+// record counts the result of an allowed search; only failures that mean Splunk is not available count against it
+func (breaker *circuitBreaker) record(err error) {
+	if breaker.config.window == 0 {
+		return
+	}
+	failed := false
+	var splunkErr *SplunkError
+	if errors.As(err, &splunkErr) {
+		failed = splunkErr.affectsHealth()
+	}
+
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	switch breaker.state {
+	case breakerHalfOpen:
+		if breaker.trials > 0 {
+			breaker.trials--
+		}
+		if failed {
+			breaker.open()
+			return
+		}
+		breaker.successes++
+		if breaker.successes >= breaker.config.trials {
+			breaker.reset()
+		}
+	case breakerClosed:
+		breaker.add(failed)
+		if len(breaker.outcomes) == breaker.config.window && float64(breaker.failures) >= breaker.config.failureRatio*float64(breaker.config.window) {
+			breaker.open()
+		}
+	}
+}
+
+// release gives back the trial of an allowed search that was cancelled, without counting it
+func (breaker *circuitBreaker) release() {
+	if breaker.config.window == 0 {
+		return
+	}
+	breaker.Lock()
+	defer breaker.Unlock()
+	if breaker.state == breakerHalfOpen && breaker.trials > 0 {
+		breaker.trials--
+	}
+}
+
+// status reports an open breaker as unhealthy; it is nil while the breaker is closed or half-open, the breaker becoming
+// half-open once the open duration is over even if no search is sent
+func (breaker *circuitBreaker) status() error {
+	if breaker.config.window == 0 {
+		return nil
+	}
+	breaker.Lock()
+	defer breaker.Unlock()
+	breaker.halfOpenIfDue()
+	if breaker.state == breakerOpen {
+		return breaker.openError()
+	}
+	return nil
+}
+
+// isHalfOpen tells whether the breaker waits for trial searches to close
+func (breaker *circuitBreaker) isHalfOpen() bool {
+	if breaker.config.window == 0 {
+		return false
+	}
+	breaker.Lock()
+	defer breaker.Unlock()
+	return breaker.state == breakerHalfOpen
+}
+
+func (breaker *circuitBreaker) halfOpenIfDue() {
+	if breaker.state == breakerOpen && breaker.now().Sub(breaker.openedAt) >= breaker.config.openDuration {
+		breaker.setState(breakerHalfOpen)
+	}
+}
+
+func (breaker *circuitBreaker) add(failed bool) {
+	if len(breaker.outcomes) < breaker.config.window {
+		breaker.outcomes = append(breaker.outcomes, failed)
+	} else {
+		if breaker.outcomes[breaker.next] {
+			breaker.failures--
+		}
+		breaker.outcomes[breaker.next] = failed
+	}
+	breaker.next = (breaker.next + 1) % breaker.config.window
+	if failed {
+		breaker.failures++
+	}
+}
+
+func (breaker *circuitBreaker) open() {
+	breaker.openedAt = breaker.now()
+	breaker.successes = 0
+	breaker.setState(breakerOpen)
+}
+
+func (breaker *circuitBreaker) reset() {
+	breaker.outcomes = breaker.outcomes[:0]
+	breaker.next = 0
+	breaker.failures = 0
+	breaker.successes = 0
+	breaker.setState(breakerClosed)
+}
+
+func (breaker *circuitBreaker) setState(state breakerState) {
+	breaker.state = state
+	breaker.trials = 0
+	metrics.GetOrRegisterGauge(breakerStateMetric, metrics.DefaultRegistry).Update(int64(state))
+}
+
+func (breaker *circuitBreaker) openError() error {
+	return &SplunkError{Class: classCircuitOpen, message: fmt.Sprintf("Splunk circuit breaker is %v since %s", breaker.state, breaker.openedAt.Format(time.RFC3339))}
+}
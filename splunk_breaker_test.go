@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := newCircuitBreaker(breakerConfig{window: 4, failureRatio: 0.5, openDuration: time.Minute, trials: 2})
+	breaker.now = func() time.Time { return now }
+	unavailable := &SplunkError{Class: classTransport}
+
+	// failures of single searches do not count
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, breaker.allow())
+		breaker.record(&SplunkError{Class: classSyntax})
+	}
+	assert.Equal(t, breakerClosed, breaker.state)
+
+	for _, err := range []error{nil, unavailable, nil} {
+		assert.NoError(t, breaker.allow())
+		breaker.record(err)
+	}
+	assert.Equal(t, breakerClosed, breaker.state)
+	assert.NoError(t, breaker.status())
+
+	breaker.record(unavailable)
+	assert.Equal(t, breakerOpen, breaker.state)
+	assert.True(t, errors.Is(breaker.allow(), ErrSplunkCircuitOpen))
+	assert.True(t, errors.Is(breaker.status(), ErrSplunkCircuitOpen))
+
+	// a failed trial opens the breaker again
+	now = now.Add(time.Minute)
+	assert.NoError(t, breaker.allow())
+	assert.Equal(t, breakerHalfOpen, breaker.state)
+	assert.NoError(t, breaker.status())
+	breaker.record(unavailable)
+	assert.Equal(t, breakerOpen, breaker.state)
+
+	// as many trials as configured run at a time, and their success closes the breaker
+	now = now.Add(time.Minute)
+	assert.NoError(t, breaker.allow())
+	assert.NoError(t, breaker.allow())
+	assert.True(t, errors.Is(breaker.allow(), ErrSplunkCircuitOpen))
+	breaker.record(nil)
+	assert.Equal(t, breakerHalfOpen, breaker.state)
+	breaker.record(nil)
+	assert.Equal(t, breakerClosed, breaker.state)
+	assert.NoError(t, breaker.allow())
+}
+
+func TestCircuitBreaker_Disabled(t *testing.T) {
+	breaker := newCircuitBreaker(breakerConfig{window: 0, failureRatio: 0.5, openDuration: time.Minute, trials: 1})
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, breaker.allow())
+		breaker.record(&SplunkError{Class: classTransport})
+	}
+	assert.NoError(t, breaker.status())
+}
+
+func TestSplunkService_CircuitBreaker(t *testing.T) {
+	calls := 0
+	splunkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer splunkServer.Close()
+
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test", breaker: breakerConfig{window: 2, failureRatio: 0.5, openDuration: time.Minute, trials: 1}})
+	for i := 0; i < 2; i++ {
+		_, err := splunkReader.GetTransactions(monitoringQuery{})
+		assert.True(t, errors.Is(err, ErrSplunkAuth))
+	}
+	assert.Equal(t, 2, calls)
+
+	_, err := splunkReader.GetTransactions(monitoringQuery{})
+	assert.True(t, errors.Is(err, ErrSplunkCircuitOpen))
+	assert.Equal(t, http.StatusServiceUnavailable, backendErrorStatus(err))
+	assert.Equal(t, 2, calls)
+
+	health := splunkReader.IsHealthy()
+	assert.Error(t, health.err)
+	assert.Equal(t, "Splunk circuit breaker is open", health.message)
+
+	healthService := newHealthService(healthConfig{}, splunkReader.IsHealthy, splunkReader.GetLastEvent)
+	assert.False(t, healthService.gtgCheck().GoodToGo)
+	assert.Equal(t, 2, calls)
+}
+
+func TestSplunkService_CircuitBreakerHealthRecovery(t *testing.T) {
+	failing := true
+	splunkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeResponse(w, r, func() {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"results":[]}`))
+		})
+	}))
+	defer splunkServer.Close()
+
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test", breaker: breakerConfig{window: 2, failureRatio: 0.5, openDuration: time.Minute, trials: 1}}).(*splunkService)
+	now := time.Now()
+	splunkReader.breaker.now = func() time.Time {
+		return now
+	}
+	for i := 0; i < 2; i++ {
+		splunkReader.GetTransactions(monitoringQuery{})
+	}
+	healthService := newHealthService(healthConfig{}, splunkReader.IsHealthy, splunkReader.GetLastEvent)
+	assert.Error(t, splunkReader.IsHealthy().err)
+	assert.False(t, healthService.gtgCheck().GoodToGo)
+
+	// Splunk is back, and no search is sent while the breaker is open
+	failing = false
+	now = now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		health := splunkReader.IsHealthy()
+		assert.NoError(t, health.err)
+		assert.Equal(t, "Splunk is ok", health.message)
+	}
+	assert.True(t, healthService.gtgCheck().GoodToGo)
+	assert.NoError(t, splunkReader.breaker.status())
+	assert.False(t, splunkReader.breaker.isHalfOpen(), "the health search closes the breaker")
+}
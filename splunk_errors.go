@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// SplunkErrorClass identifies the cause of a Splunk failure
+type SplunkErrorClass string
+
+const (
+	classTransport   SplunkErrorClass = "transport"
+	classAuth        SplunkErrorClass = "auth"
+	classQuota       SplunkErrorClass = "quota_exceeded"
+	classSyntax      SplunkErrorClass = "syntax"
+	classTimeout     SplunkErrorClass = "timeout"
+	classPeer        SplunkErrorClass = "peer_failure"
+	classJobFailure  SplunkErrorClass = "job_failure"
+	classServer      SplunkErrorClass = "server"
+	classCircuitOpen SplunkErrorClass = "circuit_open"
+)
+
+// SplunkError is a classified Splunk failure; use errors.Is with the Err* values to check its class
+type SplunkError struct {
+	Class      SplunkErrorClass
+	StatusCode int
+	message    string
+	cause      error
+}
+
+// Splunk error classes, to be checked with errors.Is
+var (
+	ErrSplunkTransport   = &SplunkError{Class: classTransport}
+	ErrSplunkAuth        = &SplunkError{Class: classAuth}
+	ErrSplunkQuota       = &SplunkError{Class: classQuota}
+	ErrSplunkSyntax      = &SplunkError{Class: classSyntax}
+	ErrSplunkTimeout     = &SplunkError{Class: classTimeout}
+	ErrSplunkPeer        = &SplunkError{Class: classPeer}
+	ErrSplunkJobFailure  = &SplunkError{Class: classJobFailure}
+	ErrSplunkServer      = &SplunkError{Class: classServer}
+	ErrSplunkCircuitOpen = &SplunkError{Class: classCircuitOpen}
+)
+
+type splunkMessages struct {
+	Messages []jobMessage `json:"messages"`
+}
+
+func (e *SplunkError) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %v", e.message, e.cause)
+	}
+	return e.message
+}
+
+func (e *SplunkError) Unwrap() error {
+	return e.cause
+}
+
+// Is matches any SplunkError of the same class
+func (e *SplunkError) Is(target error) bool {
+	t, ok := target.(*SplunkError)
+	return ok && t.Class == e.Class
+}
+
+// affectsHealth tells whether the failure means Splunk is not available, as opposed to a problem with a single search
+func (e *SplunkError) affectsHealth() bool {
+	switch e.Class {
+	case classSyntax, classPeer, classJobFailure:
+		return false
+	}
+	return true
+}
+
+// retryable tells whether the same search may succeed when tried again
+func (e *SplunkError) retryable() bool {
+	switch e.Class {
+	case classTransport, classQuota, classTimeout, classServer:
+		return true
+	}
+	return false
+}
+
+// httpStatus is the status this service responds with when the failure prevents answering a request
+func (e *SplunkError) httpStatus() int {
+	switch e.Class {
+	case classAuth, classTransport, classPeer:
+		return http.StatusBadGateway
+	case classQuota, classCircuitOpen:
+		return http.StatusServiceUnavailable
+	case classTimeout:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+func newTransportError(err error) *SplunkError {
+	return &SplunkError{Class: classTransport, message: "Splunk request failed", cause: err}
+}
+
+// newStatusError classifies an unexpected Splunk response by its status code and the messages of its body
+func newStatusError(resp *http.Response) *SplunkError {
+	message := resp.Status
+	var class SplunkErrorClass
+	body := splunkMessages{}
+	if data, err := ioutil.ReadAll(resp.Body); err == nil && json.Unmarshal(data, &body) == nil && len(body.Messages) > 0 {
+		message = fmt.Sprintf("%s: %s", resp.Status, body.Messages[0].Text)
+		class = classifyMessage(body.Messages[0].Text)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		class = classAuth
+	case http.StatusTooManyRequests:
+		class = classQuota
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		class = classTimeout
+	case http.StatusBadRequest:
+		if class == "" {
+			class = classSyntax
+		}
+	}
+	if class == "" || class == classJobFailure {
+		class = classServer
+	}
+	return &SplunkError{Class: class, StatusCode: resp.StatusCode, message: message}
+}
+
+// newJobError classifies a failed job by its message
+func newJobError(message string, msg *jobMessage) *SplunkError {
+	class := classJobFailure
+	if msg != nil {
+		class = classifyMessage(msg.Text)
+	}
+	return &SplunkError{Class: class, message: message}
+}
+
+func classifyMessage(text string) SplunkErrorClass {
+	text = strings.ToLower(text)
+	switch {
+	case strings.Contains(text, "maximum number of concurrent") || strings.Contains(text, "quota"):
+		return classQuota
+	case strings.Contains(text, "error in '") || strings.Contains(text, "unknown search command") || strings.Contains(text, "syntax"):
+		return classSyntax
+	case strings.Contains(text, "timed out") || strings.Contains(text, "timeout"):
+		return classTimeout
+	case strings.Contains(text, "peer") || strings.Contains(text, "indexer") || strings.Contains(text, "index "):
+		return classPeer
+	case strings.Contains(text, "unauthorized") || strings.Contains(text, "authentication"):
+		return classAuth
+	}
+	return classJobFailure
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStatusError(t *testing.T) {
+	tests := []struct {
+		status         int
+		body           string
+		expectedClass  error
+		expectedStatus int
+	}{
+		{http.StatusUnauthorized, `{"messages":[{"type":"WARN","text":"call not properly authenticated"}]}`, ErrSplunkAuth, http.StatusBadGateway},
+		{http.StatusForbidden, ``, ErrSplunkAuth, http.StatusBadGateway},
+		{http.StatusTooManyRequests, ``, ErrSplunkQuota, http.StatusServiceUnavailable},
+		{http.StatusServiceUnavailable, `{"messages":[{"type":"FATAL","text":"The maximum number of concurrent historical searches on this instance has been reached."}]}`, ErrSplunkQuota, http.StatusServiceUnavailable},
+		{http.StatusBadRequest, `{"messages":[{"type":"FATAL","text":"Error in 'search' command: Unable to parse the search: unbalanced parentheses."}]}`, ErrSplunkSyntax, http.StatusInternalServerError},
+		{http.StatusBadRequest, ``, ErrSplunkSyntax, http.StatusInternalServerError},
+		{http.StatusGatewayTimeout, ``, ErrSplunkTimeout, http.StatusGatewayTimeout},
+		{http.StatusInternalServerError, `not json`, ErrSplunkServer, http.StatusInternalServerError},
+		{http.StatusServiceUnavailable, ``, ErrSplunkServer, http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		resp := &http.Response{Status: http.StatusText(test.status), StatusCode: test.status, Body: ioutil.NopCloser(strings.NewReader(test.body))}
+		err := newStatusError(resp)
+		assert.True(t, errors.Is(err, test.expectedClass), "%d %s: got %s", test.status, test.body, err.Class)
+		assert.Equal(t, test.expectedStatus, err.httpStatus(), "%d %s", test.status, test.body)
+		assert.Equal(t, test.status, err.StatusCode)
+	}
+}
+
+func TestNewJobError(t *testing.T) {
+	tests := []struct {
+		msg            *jobMessage
+		expectedClass  error
+		affectsHealth  bool
+		expectedStatus int
+	}{
+		{nil, ErrSplunkJobFailure, false, http.StatusInternalServerError},
+		{&jobMessage{Type: "ERROR", Text: "Unknown search command 'foo'."}, ErrSplunkSyntax, false, http.StatusInternalServerError},
+		{&jobMessage{Type: "ERROR", Text: "Search results might be incomplete: the search process on peer idx-1 ended prematurely."}, ErrSplunkPeer, false, http.StatusBadGateway},
+		{&jobMessage{Type: "ERROR", Text: "The search job was cancelled because it timed out."}, ErrSplunkTimeout, true, http.StatusGatewayTimeout},
+		{&jobMessage{Type: "ERROR", Text: "Search not executed: the maximum number of concurrent searches has been reached."}, ErrSplunkQuota, true, http.StatusServiceUnavailable},
+		{&jobMessage{Type: "ERROR", Text: "Something unexpected"}, ErrSplunkJobFailure, false, http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		err := newJobError("job failed", test.msg)
+		assert.True(t, errors.Is(err, test.expectedClass), "%v: got %s", test.msg, err.Class)
+		assert.Equal(t, test.affectsHealth, err.affectsHealth(), "%v", test.msg)
+		assert.Equal(t, test.expectedStatus, err.httpStatus(), "%v", test.msg)
+	}
+}
+
+func TestSplunkService_IsHealthyByErrorClass(t *testing.T) {
+	tests := []struct {
+		status        int
+		body          string
+		expectedClass error
+		healthy       bool
+	}{
+		{http.StatusBadRequest, `{"messages":[{"type":"FATAL","text":"Error in 'search' command: Unable to parse the search."}]}`, ErrSplunkSyntax, true},
+		{http.StatusUnauthorized, ``, ErrSplunkAuth, false},
+		{http.StatusTooManyRequests, ``, ErrSplunkQuota, false},
+	}
+
+	for _, test := range tests {
+		splunkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+
+		splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
+		_, err := splunkReader.GetTransactions(monitoringQuery{})
+		assert.True(t, errors.Is(err, test.expectedClass), "%d: %v", test.status, err)
+		assert.Equal(t, test.healthy, splunkReader.IsHealthy().err == nil, "%d", test.status)
+		splunkServer.Close()
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	health "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/Financial-Times/go-logger/v2"
+)
+
+// regionConfig is a regional Splunk backend, searching the events of the region's environment
+type regionConfig struct {
+	name        string
+	environment string
+	restURLs    []string
+}
+
+type regionalService struct {
+	name    string
+	service EventReader
+}
+
+// federatedSplunkService fans queries out to the Splunk backends of all the regions in parallel and merges their results.
+// The results of the regions that fail are left out, with a warning, unless all of them fail.
+type federatedSplunkService struct {
+	regions []regionalService
+	log     *logger.UPPLogger
+}
+
+type regionResult struct {
+	region   string
+	value    interface{}
+	metadata searchMetadata
+	err      error
+}
+
+func newFederatedSplunkService(regions []regionalService, log *logger.UPPLogger) EventReader {
+	return &federatedSplunkService{regions: regions, log: log}
+}
+
+// parseSplunkRegions parses a comma separated list of region=environment pairs, each optionally followed by @url
+// when the region has its own Splunk backend, e.g. "eu=upp-prod-delivery-eu,us=upp-prod-delivery-us@https://splunk-us:8089"
+func parseSplunkRegions(value string, defaultURLs []string) ([]regionConfig, error) {
+	var regions []regionConfig
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || !isValidFieldValue(parts[0]) {
+			return nil, fmt.Errorf("invalid Splunk region %q, expected region=environment[@url]", entry)
+		}
+		region := regionConfig{name: parts[0], environment: parts[1], restURLs: defaultURLs}
+		if i := strings.Index(parts[1], "@"); i >= 0 {
+			region.environment = parts[1][:i]
+			region.restURLs = []string{parts[1][i+1:]}
+		}
+		if !isValidFieldValue(region.environment) || len(region.restURLs) == 0 || region.restURLs[0] == "" {
+			return nil, fmt.Errorf("invalid Splunk region %q, expected region=environment[@url]", entry)
+		}
+		for _, other := range regions {
+			if other.name == region.name {
+				return nil, fmt.Errorf("duplicate Splunk region %s", region.name)
+			}
+		}
+		regions = append(regions, region)
+	}
+	return regions, nil
+}
+
+// fanOut runs the search on all the regions in parallel; it fails only if all of them fail.
+// The warnings about the failed regions are logged, and returned to be added to the metadata of the response.
+func (service *federatedSplunkService) fanOut(search func(region regionalService) (interface{}, searchMetadata, error)) ([]regionResult, []string, error) {
+	results := make([]regionResult, len(service.regions))
+	var wg sync.WaitGroup
+	for i, region := range service.regions {
+		wg.Add(1)
+		go func(i int, region regionalService) {
+			defer wg.Done()
+			value, metadata, err := search(region)
+			results[i] = regionResult{region: region.name, value: value, metadata: metadata, err: err}
+		}(i, region)
+	}
+	wg.Wait()
+
+	var succeeded []regionResult
+	var warnings []string
+	var err error
+	for _, result := range results {
+		if result.err != nil && !errors.Is(result.err, ErrNoResults) {
+			warnings = append(warnings, regionWarning(result.region, result.err))
+			err = result.err
+			continue
+		}
+		succeeded = append(succeeded, result)
+	}
+	if len(succeeded) == 0 {
+		return nil, nil, err
+	}
+	for _, warning := range warnings {
+		service.log.Warn(warning)
+	}
+	return succeeded, warnings, nil
+}
+
+func (service *federatedSplunkService) GetTransactions(query monitoringQuery) ([]transactionEvent, error) {
+	transactions, _, err := service.GetTransactionsWithMetadata(query)
+	return transactions, err
+}
+
+func (service *federatedSplunkService) GetTransactionsWithMetadata(query monitoringQuery) ([]transactionEvent, searchMetadata, error) {
+	results, warnings, err := service.fanOut(func(region regionalService) (interface{}, searchMetadata, error) {
+		return region.service.GetTransactionsWithMetadata(regionQuery(query))
+	})
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+
+	var regionTransactions [][]transactionEvent
+	for _, result := range results {
+		regionTransactions = append(regionTransactions, tagTransactions(result.value.([]transactionEvent), result.region))
+	}
+	return dropClosedTransactions(mergeTransactions(regionTransactions), query), mergeMetadata(results, warnings), nil
+}
+
+func (service *federatedSplunkService) GetTransactionsByContentType(query monitoringQuery, contentTypes []string) (map[string][]transactionEvent, searchMetadata, error) {
+	results, warnings, err := service.fanOut(func(region regionalService) (interface{}, searchMetadata, error) {
+		return region.service.GetTransactionsByContentType(regionQuery(query), contentTypes)
+	})
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+
+	merged := make(map[string][]transactionEvent)
+	for _, contentType := range contentTypes {
+		var regionTransactions [][]transactionEvent
+		for _, result := range results {
+			regionTransactions = append(regionTransactions, tagTransactions(result.value.(map[string][]transactionEvent)[contentType], result.region))
+		}
+		merged[contentType] = dropClosedTransactions(mergeTransactions(regionTransactions), query)
+	}
+	return merged, mergeMetadata(results, warnings), nil
+}
+
+func (service *federatedSplunkService) GetLastEvent(query monitoringQuery) (*publishEvent, searchMetadata, error) {
+	events, metadata, err := service.GetLastEvents(query, 1)
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	if len(events) > 0 {
+		return &events[0], metadata, nil
+	}
+	return nil, metadata, ErrNoResults
+}
+
+func (service *federatedSplunkService) GetLastEvents(query monitoringQuery, limit int) ([]publishEvent, searchMetadata, error) {
+	results, warnings, err := service.fanOut(func(region regionalService) (interface{}, searchMetadata, error) {
+		return region.service.GetLastEvents(query, limit)
+	})
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+
+	events := []publishEvent{}
+	for _, result := range results {
+		events = append(events, tagEvents(result.value.([]publishEvent), result.region)...)
+	}
+	events = dedupEvents(events)
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, mergeMetadata(results, warnings), nil
+}
+
+func (service *federatedSplunkService) GetLastEventByContentType(query monitoringQuery, contentTypes []string) (map[string]publishEvent, searchMetadata, error) {
+	results, warnings, err := service.fanOut(func(region regionalService) (interface{}, searchMetadata, error) {
+		return region.service.GetLastEventByContentType(query, contentTypes)
+	})
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+
+	lastEvents := make(map[string]publishEvent)
+	for _, result := range results {
+		for contentType, event := range result.value.(map[string]publishEvent) {
+			if last, found := lastEvents[contentType]; !found || isLaterEventTime(event.Time, last.Time) {
+				event.Region = result.region
+				lastEvents[contentType] = event
+			}
+		}
+	}
+	return lastEvents, mergeMetadata(results, warnings), nil
+}
+
+func (service *federatedSplunkService) GetErrors(query monitoringQuery) ([]errorReport, searchMetadata, error) {
+	results, warnings, err := service.fanOut(func(region regionalService) (interface{}, searchMetadata, error) {
+		return region.service.GetErrors(query)
+	})
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+
+	reports := []errorReport{}
+	reportIndex := make(map[[2]string]int)
+	for _, result := range results {
+		for _, regionReport := range result.value.([]errorReport) {
+			key := [2]string{regionReport.ServiceName, regionReport.Event}
+			i, found := reportIndex[key]
+			if !found {
+				reportIndex[key] = len(reports)
+				regionReport.SampleTransactionIDs = append([]string{}, regionReport.SampleTransactionIDs...)
+				reports = append(reports, regionReport)
+				continue
+			}
+
+			report := &reports[i]
+			report.Count += regionReport.Count
+			for _, transactionID := range regionReport.SampleTransactionIDs {
+				if len(report.SampleTransactionIDs) < maxErrorSamples && !contains(report.SampleTransactionIDs, transactionID) {
+					report.SampleTransactionIDs = append(report.SampleTransactionIDs, transactionID)
+				}
+			}
+			if isLaterEventTime(regionReport.LatestTime, report.LatestTime) {
+				report.LatestTime = regionReport.LatestTime
+			}
+		}
+	}
+
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].Count > reports[j].Count
+	})
+	return reports, mergeMetadata(results, warnings), nil
+}
+
+// GetThroughput adds up the counts of the regions in each time bucket
+func (service *federatedSplunkService) GetThroughput(query monitoringQuery, span string) ([]timeSeries, searchMetadata, error) {
+	results, warnings, err := service.fanOut(func(region regionalService) (interface{}, searchMetadata, error) {
+		return region.service.GetThroughput(query, span)
+	})
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+
+	var merged []timeSeries
+	for _, result := range results {
+		for _, series := range result.value.([]timeSeries) {
+			i := 0
+			for i < len(merged) && merged[i].Target != series.Target {
+				i++
+			}
+			if i == len(merged) {
+				merged = append(merged, timeSeries{Target: series.Target, Datapoints: [][2]float64{}})
+			}
+			merged[i].Datapoints = addDatapoints(merged[i].Datapoints, series.Datapoints)
+		}
+	}
+	return merged, mergeMetadata(results, warnings), nil
+}
+
+// StreamEvents streams the events of all the regions; it fails only if the streams of all the regions fail, the failure
+// of the others being sent as a warning when it happens
+func (service *federatedSplunkService) StreamEvents(ctx context.Context, query monitoringQuery, send func(event publishEvent) error, warn func(warning string)) error {
+	var lock sync.Mutex
+	failed := 0
+	_, _, err := service.fanOut(func(region regionalService) (interface{}, searchMetadata, error) {
+		err := region.service.StreamEvents(ctx, query, func(event publishEvent) error {
+			lock.Lock()
+			defer lock.Unlock()
+			event.Region = region.name
+			return send(event)
+		}, func(warning string) {
+			lock.Lock()
+			defer lock.Unlock()
+			warn(warning)
+		})
+
+		if err != nil {
+			lock.Lock()
+			defer lock.Unlock()
+			failed++
+			// the failure of the last region fails the whole stream
+			if failed < len(service.regions) {
+				warn(regionWarning(region.name, err))
+			}
+		}
+		return nil, searchMetadata{}, err
+	})
+	return err
+}
+
+// regionWarning tells that the results of a region are left out because its search failed
+func regionWarning(region string, err error) string {
+	return fmt.Sprintf("Results of region %s are missing: %v", region, err)
+}
+
+// IsHealthy fails only if Splunk is not available in any region
+func (service *federatedSplunkService) IsHealthy() healthStatus {
+	var unhealthy []string
+	var status healthStatus
+	for _, region := range service.regions {
+		regionStatus := region.service.IsHealthy()
+		if regionStatus.err != nil {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s: %v", region.name, regionStatus.err))
+			status = regionStatus
+		}
+	}
+	switch {
+	case len(unhealthy) == len(service.regions):
+		return healthStatus{message: status.message, err: fmt.Errorf("Splunk is not available in any region: %s", strings.Join(unhealthy, "; ")), time: status.time}
+	case len(unhealthy) > 0:
+		return healthStatus{message: fmt.Sprintf("Splunk is ok, except in %s", strings.Join(unhealthy, "; "))}
+	}
+	return healthStatus{message: "Splunk is ok"}
+}
+
+func (service *federatedSplunkService) HealthChecks() []health.Check {
+	var checks []health.Check
+	for _, region := range service.regions {
+		for _, check := range region.service.HealthChecks() {
+			check.ID = fmt.Sprintf("%s-%s", check.ID, region.name)
+			check.Name = fmt.Sprintf("%s in region %s", check.Name, region.name)
+			checks = append(checks, check)
+		}
+	}
+	return checks
+}
+
+// regionQuery returns the query of the transactions of a region, with the closed ones, as a transaction may only be
+// closed in one of the regions; the closed transactions the query does not return are dropped once merged
+func regionQuery(query monitoringQuery) monitoringQuery {
+	query.IncludeClosed = true
+	return query
+}
+
+// mergeMetadata merges the metadata of the searches of the regions, and adds the warnings about the failed regions
+func mergeMetadata(results []regionResult, warnings []string) searchMetadata {
+	merged := searchMetadata{Sids: []string{}}
+	for _, result := range results {
+		merged.Merge(result.metadata)
+	}
+	merged.Warnings = append(merged.Warnings, warnings...)
+	return merged
+}
+
+func tagEvents(events []publishEvent, region string) []publishEvent {
+	tagged := make([]publishEvent, len(events))
+	for i, event := range events {
+		event.Region = region
+		tagged[i] = event
+	}
+	return tagged
+}
+
+func tagTransactions(transactions []transactionEvent, region string) []transactionEvent {
+	tagged := make([]transactionEvent, len(transactions))
+	for i, transaction := range transactions {
+		transaction.Events = tagEvents(transaction.Events, region)
+		tagged[i] = transaction
+	}
+	return tagged
+}
+
+// mergeTransactions merges the events of the transactions found by several searches, of several regions or UUID chunks;
+// the events found by several searches, such as the events of the publishing cluster in all the regions, are only kept once
+func mergeTransactions(results [][]transactionEvent) []transactionEvent {
+	transactions := []transactionEvent{}
+	txIndex := make(map[string]int)
+	for _, result := range results {
+		for _, transaction := range result {
+			i, found := txIndex[transaction.TransactionID]
+			if !found {
+				txIndex[transaction.TransactionID] = len(transactions)
+				transactions = append(transactions, transaction)
+				continue
+			}
+
+			merged := &transactions[i]
+			merged.Events = dedupEvents(append(append([]publishEvent{}, merged.Events...), transaction.Events...))
+			merged.EventCount = len(merged.Events)
+			if merged.UUID == "" {
+				merged.UUID = transaction.UUID
+			}
+			if transaction.StartTime != "" && (merged.StartTime == "" || isLaterEventTime(merged.StartTime, transaction.StartTime)) {
+				merged.StartTime = transaction.StartTime
+			}
+			if transaction.ClosedTxn == "1" {
+				merged.ClosedTxn = "1"
+			}
+		}
+	}
+	return transactions
+}
+
+// dedupEvents removes the events found by several searches and sorts them latest first
+func dedupEvents(events []publishEvent) []publishEvent {
+	deduped := []publishEvent{}
+	seen := make(map[publishEvent]bool)
+	for _, event := range events {
+		key := event
+		key.Region = ""
+		if !seen[key] {
+			seen[key] = true
+			deduped = append(deduped, event)
+		}
+	}
+	sort.SliceStable(deduped, func(i, j int) bool {
+		return isLaterEventTime(deduped[i].Time, deduped[j].Time)
+	})
+	return deduped
+}
+
+func addDatapoints(datapoints [][2]float64, other [][2]float64) [][2]float64 {
+	for _, point := range other {
+		i := 0
+		for i < len(datapoints) && datapoints[i][1] != point[1] {
+			i++
+		}
+		if i == len(datapoints) {
+			datapoints = append(datapoints, point)
+		} else {
+			datapoints[i][0] += point[0]
+		}
+	}
+	sort.Slice(datapoints, func(i, j int) bool {
+		return datapoints[i][1] < datapoints[j][1]
+	})
+	return datapoints
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestFederation(eu *mockEventReader, us *mockEventReader) EventReader {
+	return newFederatedSplunkService([]regionalService{{name: "eu", service: eu}, {name: "us", service: us}}, logger.NewUPPLogger("test", "INFO"))
+}
+
+func TestFederatedSplunkService_GetTransactions(t *testing.T) {
+	publishStart := publishEvent{Event: "PublishStart", ServiceName: "cms-notifier", Time: "2017-09-19T15:10:00.000Z", TransactionID: "tid_1", UUID: "uuid_1"}
+	euMapper := publishEvent{Event: "Map", ServiceName: "annotations-mapper", Time: "2017-09-19T15:10:01.000Z", TransactionID: "tid_1", UUID: "uuid_1"}
+	usMapper := publishEvent{Event: "Map", ServiceName: "annotations-mapper", Time: "2017-09-19T15:10:02.000Z", TransactionID: "tid_1", UUID: "uuid_1"}
+	eu := &mockEventReader{
+		transactions: []transactionEvent{{TransactionID: "tid_1", UUID: "uuid_1", ClosedTxn: "0", EventCount: 2, StartTime: publishStart.Time, Events: []publishEvent{euMapper, publishStart}}},
+		metadata:     searchMetadata{Sids: []string{"eu_sid"}, EventCount: 2},
+	}
+	us := &mockEventReader{
+		transactions: []transactionEvent{
+			{TransactionID: "tid_1", UUID: "uuid_1", ClosedTxn: "0", EventCount: 2, StartTime: publishStart.Time, Events: []publishEvent{usMapper, publishStart}},
+			{TransactionID: "tid_2", UUID: "uuid_2", ClosedTxn: "0", EventCount: 0},
+		},
+		metadata: searchMetadata{Sids: []string{"us_sid"}, EventCount: 3},
+	}
+
+	transactions, metadata, err := newTestFederation(eu, us).GetTransactionsWithMetadata(monitoringQuery{ContentType: "annotations"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"eu_sid", "us_sid"}, metadata.Sids)
+	assert.Equal(t, 5, metadata.EventCount)
+	assert.Empty(t, metadata.Warnings)
+
+	assert.Len(t, transactions, 2)
+	merged := transactions[0]
+	assert.Equal(t, "tid_1", merged.TransactionID)
+	// the publishing cluster event is found in both regions, but only kept once
+	assert.Equal(t, 3, merged.EventCount)
+	assert.Equal(t, []string{"us", "eu", "eu"}, []string{merged.Events[0].Region, merged.Events[1].Region, merged.Events[2].Region})
+	assert.Equal(t, "annotations-mapper", merged.Events[0].ServiceName)
+	assert.Equal(t, "PublishStart", merged.Events[2].Event)
+
+	// the regional results are not changed
+	assert.Empty(t, eu.transactions[0].Events[0].Region)
+}
+
+func TestFederatedSplunkService_TransactionClosedInOneRegion(t *testing.T) {
+	publishStart := publishEvent{Event: "PublishStart", Time: "2017-09-19T15:10:00.000Z", TransactionID: "tid_1", UUID: "uuid_1"}
+	euEnd := publishEvent{Event: "PublishEnd", Time: "2017-09-19T15:10:03.000Z", TransactionID: "tid_1", UUID: "uuid_1"}
+	usMapper := publishEvent{Event: "Map", Time: "2017-09-19T15:10:02.000Z", TransactionID: "tid_1", UUID: "uuid_1"}
+	eu := &mockEventReader{transactions: []transactionEvent{{TransactionID: "tid_1", UUID: "uuid_1", ClosedTxn: "1", EventCount: 2, Events: []publishEvent{euEnd, publishStart}}}}
+	us := &mockEventReader{transactions: []transactionEvent{
+		{TransactionID: "tid_1", UUID: "uuid_1", ClosedTxn: "0", EventCount: 2, Events: []publishEvent{usMapper, publishStart}},
+		{TransactionID: "tid_2", UUID: "uuid_2", ClosedTxn: "0", EventCount: 1, Events: []publishEvent{{Event: "PublishStart", TransactionID: "tid_2"}}},
+	}}
+	federation := newTestFederation(eu, us)
+
+	transactions, err := federation.GetTransactions(monitoringQuery{ContentType: "annotations"})
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 1)
+	assert.Equal(t, "tid_2", transactions[0].TransactionID, "tid_1 is closed in the eu region")
+	assert.True(t, eu.queries[0].IncludeClosed)
+	assert.True(t, us.queries[0].IncludeClosed)
+
+	byContentType, _, err := federation.GetTransactionsByContentType(monitoringQuery{}, []string{"annotations"})
+	assert.NoError(t, err)
+	assert.Len(t, byContentType["annotations"], 1)
+	assert.Equal(t, "tid_2", byContentType["annotations"][0].TransactionID)
+
+	transactions, err = federation.GetTransactions(monitoringQuery{ContentType: "annotations", IncludeClosed: true})
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 2)
+	assert.Equal(t, "1", transactions[0].ClosedTxn)
+	assert.Equal(t, 3, transactions[0].EventCount)
+	assert.Equal(t, []string{"PublishEnd", "Map", "PublishStart"}, []string{transactions[0].Events[0].Event, transactions[0].Events[1].Event, transactions[0].Events[2].Event})
+}
+
+func TestFederatedSplunkService_PartialFailure(t *testing.T) {
+	eu := &mockEventReader{transactions: []transactionEvent{{TransactionID: "tid_1"}}, events: []publishEvent{{TransactionID: "tid_1", Time: "2017-09-19T15:10:00.000Z"}}}
+	us := &mockEventReader{err: &SplunkError{Class: classTransport, message: "Splunk request failed"}}
+
+	transactions, metadata, err := newTestFederation(eu, us).GetTransactionsWithMetadata(monitoringQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 1)
+	assert.Equal(t, []string{"Results of region us are missing: Splunk request failed"}, metadata.Warnings)
+
+	event, metadata, err := newTestFederation(eu, us).GetLastEvent(monitoringQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, "eu", event.Region)
+	assert.Equal(t, []string{"Results of region us are missing: Splunk request failed"}, metadata.Warnings)
+
+	_, metadata, err = newTestFederation(&mockEventReader{err: ErrNoResults}, us).GetLastEvent(monitoringQuery{})
+	assert.True(t, errors.Is(err, ErrNoResults))
+	assert.Equal(t, []string{"Results of region us are missing: Splunk request failed"}, metadata.Warnings, "the event may be in the missing results")
+
+	_, metadata, err = newTestFederation(eu, us).GetLastEventByContentType(monitoringQuery{}, []string{"annotations"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Results of region us are missing: Splunk request failed"}, metadata.Warnings)
+
+	_, metadata, err = newTestFederation(eu, us).GetTransactionsByContentType(monitoringQuery{}, []string{"annotations"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Results of region us are missing: Splunk request failed"}, metadata.Warnings)
+
+	_, metadata, err = newTestFederation(eu, us).GetErrors(monitoringQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Results of region us are missing: Splunk request failed"}, metadata.Warnings)
+
+	_, metadata, err = newTestFederation(eu, us).GetThroughput(monitoringQuery{}, "1m")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Results of region us are missing: Splunk request failed"}, metadata.Warnings)
+
+	var warnings []string
+	err = newTestFederation(eu, us).StreamEvents(context.Background(), monitoringQuery{}, func(event publishEvent) error {
+		return nil
+	}, func(warning string) {
+		warnings = append(warnings, warning)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Results of region us are missing: Splunk request failed"}, warnings)
+
+	eu.err = &SplunkError{Class: classAuth}
+	_, _, err = newTestFederation(eu, us).GetTransactionsWithMetadata(monitoringQuery{})
+	assert.Error(t, err)
+}
+
+func TestFederatedSplunkService_GetLastEvents(t *testing.T) {
+	eu := &mockEventReader{events: []publishEvent{{TransactionID: "tid_3", Time: "2017-09-19T15:10:03.000Z"}, {TransactionID: "tid_1", Time: "2017-09-19T15:10:01.000Z"}}}
+	us := &mockEventReader{events: []publishEvent{{TransactionID: "tid_2", Time: "2017-09-19T15:10:02.000Z"}}}
+
+	events, _, err := newTestFederation(eu, us).GetLastEvents(monitoringQuery{}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []publishEvent{
+		{TransactionID: "tid_3", Time: "2017-09-19T15:10:03.000Z", Region: "eu"},
+		{TransactionID: "tid_2", Time: "2017-09-19T15:10:02.000Z", Region: "us"},
+	}, events)
+
+	_, _, err = newTestFederation(&mockEventReader{err: ErrNoResults}, &mockEventReader{err: ErrNoResults}).GetLastEvent(monitoringQuery{})
+	assert.True(t, errors.Is(err, ErrNoResults))
+}
+
+func TestFederatedSplunkService_GetErrorsAndThroughput(t *testing.T) {
+	eu := &mockEventReader{
+		errors: []errorReport{{ServiceName: "annotations-rw", Event: "SaveNeo4j", Count: 2, SampleTransactionIDs: []string{"tid_1"}, LatestTime: "2017-09-19T15:10:00.000Z"}},
+		series: []timeSeries{{Target: "started", Datapoints: [][2]float64{{3, 1505829600000}, {1, 1505829660000}}}},
+	}
+	us := &mockEventReader{
+		errors: []errorReport{
+			{ServiceName: "annotations-rw", Event: "SaveNeo4j", Count: 2, SampleTransactionIDs: []string{"tid_2"}, LatestTime: "2017-09-19T15:11:00.000Z"},
+			{ServiceName: "annotations-mapper", Event: "Map", Count: 3, SampleTransactionIDs: []string{"tid_3"}},
+		},
+		series: []timeSeries{{Target: "started", Datapoints: [][2]float64{{2, 1505829600000}}}},
+	}
+
+	reports, _, err := newTestFederation(eu, us).GetErrors(monitoringQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, []errorReport{
+		{ServiceName: "annotations-rw", Event: "SaveNeo4j", Count: 4, SampleTransactionIDs: []string{"tid_1", "tid_2"}, LatestTime: "2017-09-19T15:11:00.000Z"},
+		{ServiceName: "annotations-mapper", Event: "Map", Count: 3, SampleTransactionIDs: []string{"tid_3"}},
+	}, reports)
+
+	series, _, err := newTestFederation(eu, us).GetThroughput(monitoringQuery{}, "1m")
+	assert.NoError(t, err)
+	assert.Equal(t, []timeSeries{{Target: "started", Datapoints: [][2]float64{{5, 1505829600000}, {1, 1505829660000}}}}, series)
+	assert.Equal(t, float64(3), eu.series[0].Datapoints[0][0])
+}
+
+func TestFederatedSplunkService_TimeZones(t *testing.T) {
+	// the us events are logged with an offset, so they sort later as strings than the later eu events
+	publishStart := publishEvent{Event: "PublishStart", Time: "2017-09-19T20:10:00.000+05:00", TransactionID: "tid_1"}
+	euEnd := publishEvent{Event: "PublishEnd", Time: "2017-09-19T15:10:02.000Z", TransactionID: "tid_1"}
+	usMapper := publishEvent{Event: "Map", Time: "2017-09-19T20:10:01.000+05:00", TransactionID: "tid_1"}
+	eu := &mockEventReader{
+		transactions: []transactionEvent{{TransactionID: "tid_1", ClosedTxn: "1", StartTime: euEnd.Time, Events: []publishEvent{euEnd}}},
+		errors:       []errorReport{{ServiceName: "annotations-rw", Event: "SaveNeo4j", Count: 1, LatestTime: "2017-09-19T15:10:02.000Z"}},
+		lastEvent:    &euEnd,
+	}
+	us := &mockEventReader{
+		transactions: []transactionEvent{{TransactionID: "tid_1", ClosedTxn: "0", StartTime: publishStart.Time, Events: []publishEvent{usMapper, publishStart}}},
+		errors:       []errorReport{{ServiceName: "annotations-rw", Event: "SaveNeo4j", Count: 1, LatestTime: usMapper.Time}},
+		lastEvent:    &usMapper,
+	}
+	federation := newTestFederation(eu, us)
+
+	transactions, err := federation.GetTransactions(monitoringQuery{ContentType: "annotations", IncludeClosed: true})
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 1)
+	assert.Equal(t, []string{"PublishEnd", "Map", "PublishStart"}, []string{transactions[0].Events[0].Event, transactions[0].Events[1].Event, transactions[0].Events[2].Event})
+	assert.Equal(t, publishStart.Time, transactions[0].StartTime)
+
+	reports, _, err := federation.GetErrors(monitoringQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, "2017-09-19T15:10:02.000Z", reports[0].LatestTime)
+
+	lastEvents, _, err := federation.GetLastEventByContentType(monitoringQuery{}, []string{"annotations"})
+	assert.NoError(t, err)
+	assert.Equal(t, "eu", lastEvents["annotations"].Region)
+	assert.Equal(t, "PublishEnd", lastEvents["annotations"].Event)
+}
+
+func TestFederatedSplunkService_StreamEvents(t *testing.T) {
+	eu := &mockEventReader{events: []publishEvent{{TransactionID: "tid_1"}}}
+	us := &mockEventReader{events: []publishEvent{{TransactionID: "tid_2"}}}
+
+	regions := make(map[string]string)
+	err := newTestFederation(eu, us).StreamEvents(context.Background(), monitoringQuery{}, func(event publishEvent) error {
+		regions[event.TransactionID] = event.Region
+		return nil
+	}, func(warning string) {})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"tid_1": "eu", "tid_2": "us"}, regions)
+}
+
+func TestParseSplunkRegions(t *testing.T) {
+	regions, err := parseSplunkRegions("eu=upp-prod-delivery-eu, us=upp-prod-delivery-us@https://splunk-us:8089", []string{"https://splunk:8089"})
+	assert.NoError(t, err)
+	assert.Equal(t, []regionConfig{
+		{name: "eu", environment: "upp-prod-delivery-eu", restURLs: []string{"https://splunk:8089"}},
+		{name: "us", environment: "upp-prod-delivery-us", restURLs: []string{"https://splunk-us:8089"}},
+	}, regions)
+
+	regions, err = parseSplunkRegions("", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, regions)
+
+	for _, value := range []string{"eu", "eu=", "eu=upp-prod-delivery-eu", "eu=upp prod", "eu=a@https://x,eu=b@https://y", "e u=a@https://x"} {
+		_, err = parseSplunkRegions(value, nil)
+		assert.Error(t, err, value)
+	}
+}
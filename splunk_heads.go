@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	health "github.com/Financial-Times/go-fthealth/v1_1"
+)
+
+const (
+	headSelectionPrimary    = "primary"
+	headSelectionRoundRobin = "round-robin"
+	// headRecoveryPeriod is how long a failed search head is only used when all the others fail too
+	headRecoveryPeriod = 30 * time.Second
+)
+
+// searchHead is a Splunk search head, with the health of its latest search
+type searchHead struct {
+	sync.Mutex
+	url      string
+	lastErr  error
+	failedAt time.Time
+}
+
+func (head *searchHead) record(err error) {
+	head.Lock()
+	defer head.Unlock()
+	var splunkErr *SplunkError
+	if errors.As(err, &splunkErr) && splunkErr.affectsHealth() {
+		head.lastErr = err
+		head.failedAt = time.Now()
+	} else if err == nil {
+		head.lastErr = nil
+	}
+}
+
+func (head *searchHead) available() bool {
+	head.Lock()
+	defer head.Unlock()
+	return head.lastErr == nil || time.Since(head.failedAt) > headRecoveryPeriod
+}
+
+func (head *searchHead) health() healthStatus {
+	head.Lock()
+	defer head.Unlock()
+	if head.lastErr != nil {
+		return healthStatus{message: fmt.Sprintf("Search head failed at %s", head.failedAt.Format(time.RFC3339)), err: head.lastErr, time: head.failedAt}
+	}
+	return healthStatus{message: "Search head is ok", time: time.Now()}
+}
+
+func (head *searchHead) healthCheck(id string) health.Check {
+	return health.Check{
+		ID:               id,
+		BusinessImpact:   "No business impact while another search head is available, searches fail over to it",
+		Name:             fmt.Sprintf("Splunk search head %s", head.url),
+		PanicGuide:       "https://dewey.ft.com/splunk-event-reader.html",
+		Severity:         3,
+		TechnicalSummary: "The latest search on this Splunk search head failed, so it is only used when the other heads fail too. Check the availability of the search head.",
+		Checker: func() (string, error) {
+			status := head.health()
+			return status.message, status.err
+		},
+	}
+}
+
+// searchHeads selects the search head of each search, either the first available one in the configured order,
+// or the available ones in turn
+type searchHeads struct {
+	heads     []*searchHead
+	selection string
+	next      uint32
+}
+
+func newSearchHeads(urls []string, selection string) *searchHeads {
+	heads := &searchHeads{selection: selection}
+	for _, url := range urls {
+		heads.heads = append(heads.heads, &searchHead{url: url})
+	}
+	return heads
+}
+
+// candidates lists the heads a search should be tried on in order, the recently failed ones last
+func (heads *searchHeads) candidates() []*searchHead {
+	start := 0
+	if heads.selection == headSelectionRoundRobin && len(heads.heads) > 0 {
+		start = int((atomic.AddUint32(&heads.next, 1) - 1) % uint32(len(heads.heads)))
+	}
+	var available, failed []*searchHead
+	for i := range heads.heads {
+		head := heads.heads[(start+i)%len(heads.heads)]
+		if head.available() {
+			available = append(available, head)
+		} else {
+			failed = append(failed, head)
+		}
+	}
+	return append(available, failed...)
+}
+
+func (heads *searchHeads) healthChecks() []health.Check {
+	var checks []health.Check
+	for i, head := range heads.heads {
+		checks = append(checks, head.healthCheck(fmt.Sprintf("splunk-search-head-%d", i+1)))
+	}
+	return checks
+}
+
+// failover runs the call on each candidate head until it succeeds or fails for a reason that another head would not fix
+func (heads *searchHeads) failover(call func(head *searchHead) error) error {
+	var err error
+	for _, head := range heads.candidates() {
+		err = call(head)
+		head.record(err)
+		var splunkErr *SplunkError
+		if !errors.As(err, &splunkErr) || !splunkErr.affectsHealth() {
+			return err
+		}
+	}
+	return err
+}
+
+func isValidHeadSelection(selection string) bool {
+	return selection == headSelectionPrimary || selection == headSelectionRoundRobin
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newHeadServer(t *testing.T, status int, requests *[]string, lock *sync.Mutex) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		*requests = append(*requests, r.URL.Path)
+		lock.Unlock()
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		writeResponse(w, r, func() {
+			w.WriteHeader(http.StatusOK)
+			inputJSON, err := ioutil.ReadFile("testdata/splunk_response_sample.json")
+			assert.NoError(t, err, "Unexpected error")
+			w.Write(inputJSON)
+		})
+	}))
+}
+
+func TestSplunkService_SearchHeadFailover(t *testing.T) {
+	lock := &sync.Mutex{}
+	var primaryRequests, secondaryRequests []string
+	primary := newHeadServer(t, http.StatusServiceUnavailable, &primaryRequests, lock)
+	defer primary.Close()
+	secondary := newHeadServer(t, http.StatusOK, &secondaryRequests, lock)
+	defer secondary.Close()
+
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{primary.URL, secondary.URL}, environment: "test"})
+	transactions, err := splunkReader.GetTransactions(monitoringQuery{})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, transactions)
+	assert.NoError(t, splunkReader.IsHealthy().err)
+
+	assert.Equal(t, []string{splunkEndpoint}, primaryRequests)
+	// the job status and results are fetched from the head that created the job
+	assert.Equal(t, []string{splunkEndpoint, splunkEndpoint + "/test_sid", splunkEndpoint + "/test_sid/results"}, secondaryRequests)
+
+	checks := splunkReader.HealthChecks()
+	assert.Len(t, checks, 2)
+	assert.Equal(t, "splunk-search-head-1", checks[0].ID)
+	_, err = checks[0].Checker()
+	assert.True(t, errors.Is(err, ErrSplunkServer))
+	_, err = checks[1].Checker()
+	assert.NoError(t, err)
+
+	// the failed head is only tried after the available ones
+	_, err = splunkReader.GetTransactions(monitoringQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, primaryRequests, 1)
+}
+
+func TestSplunkService_SearchHeadNoFailoverForInvalidSearch(t *testing.T) {
+	lock := &sync.Mutex{}
+	var primaryRequests, secondaryRequests []string
+	primary := newHeadServer(t, http.StatusBadRequest, &primaryRequests, lock)
+	defer primary.Close()
+	secondary := newHeadServer(t, http.StatusOK, &secondaryRequests, lock)
+	defer secondary.Close()
+
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{primary.URL, secondary.URL}, environment: "test"})
+	_, err := splunkReader.GetTransactions(monitoringQuery{})
+	assert.True(t, errors.Is(err, ErrSplunkSyntax))
+	assert.Len(t, primaryRequests, 1)
+	assert.Empty(t, secondaryRequests)
+
+	_, err = splunkReader.HealthChecks()[0].Checker()
+	assert.NoError(t, err)
+}
+
+func TestSearchHeads_Candidates(t *testing.T) {
+	urls := func(heads []*searchHead) string {
+		var result []string
+		for _, head := range heads {
+			result = append(result, head.url)
+		}
+		return strings.Join(result, ",")
+	}
+
+	primary := newSearchHeads([]string{"a", "b", "c"}, headSelectionPrimary)
+	assert.Equal(t, "a,b,c", urls(primary.candidates()))
+	assert.Equal(t, "a,b,c", urls(primary.candidates()))
+	primary.heads[0].record(&SplunkError{Class: classTransport})
+	assert.Equal(t, "b,c,a", urls(primary.candidates()))
+	primary.heads[0].record(nil)
+	assert.Equal(t, "a,b,c", urls(primary.candidates()))
+
+	roundRobin := newSearchHeads([]string{"a", "b", "c"}, headSelectionRoundRobin)
+	assert.Equal(t, "a,b,c", urls(roundRobin.candidates()))
+	assert.Equal(t, "b,c,a", urls(roundRobin.candidates()))
+	roundRobin.heads[0].record(&SplunkError{Class: classAuth})
+	assert.Equal(t, "c,b,a", urls(roundRobin.candidates()))
+	assert.Equal(t, "b,c,a", urls(roundRobin.candidates()))
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	redacted          = "REDACTED"
+	fixtureFileFormat = "%04d-%s-%s.json"
+	// maxRecordedBodySize caps the recorded responses, as a real-time export stream does not end until it is closed
+	maxRecordedBodySize = 1 << 20
+)
+
+// credentialFields are removed from the recorded requests, and session keys redacted from the recorded responses
+var (
+	credentialFields = []string{"username", "password"}
+	sessionKeyRegex  = regexp.MustCompile(`("sessionKey"\s*:\s*"|<sessionKey>)[^"<]*`)
+	fixtureNameRegex = regexp.MustCompile(`[^\w.-]+`)
+)
+
+// fixture is a recorded Splunk request and its response
+type fixture struct {
+	Request  recordedRequest  `json:"request"`
+	Response recordedResponse `json:"response"`
+}
+
+type recordedRequest struct {
+	Method string     `json:"method"`
+	Path   string     `json:"path"`
+	Form   url.Values `json:"form"`
+}
+
+type recordedResponse struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        string `json:"body"`
+	// Truncated is set when only the first maxRecordedBodySize bytes of the body were recorded
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// recordingTransport writes the Splunk requests and responses to a directory, one file per request in the order they
+// are sent, without the credentials
+type recordingTransport struct {
+	sync.Mutex
+	dir      string
+	next     http.RoundTripper
+	sequence int
+}
+
+// replayTransport serves the responses recorded by recordingTransport; a request gets the response of the first
+// recorded request with the same method, path and form not served yet, or of the last one when they were all served
+type replayTransport struct {
+	sync.Mutex
+	fixtures []fixture
+	served   []bool
+}
+
+// recordedBody records the response body as it is read, so that streamed responses are recorded up to where they are read,
+// or up to maxRecordedBodySize bytes
+type recordedBody struct {
+	io.ReadCloser
+	buffer    bytes.Buffer
+	truncated bool
+	closed    func(body []byte, truncated bool) error
+	once      sync.Once
+}
+
+func newRecordingTransport(dir string, next http.RoundTripper) *recordingTransport {
+	return &recordingTransport{dir: dir, next: next}
+}
+
+func (transport *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	request, err := recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	transport.Lock()
+	transport.sequence++
+	fileName := fmt.Sprintf(fixtureFileFormat, transport.sequence, req.Method, fixtureNameRegex.ReplaceAllString(strings.Trim(req.URL.Path, "/"), "_"))
+	transport.Unlock()
+
+	resp, err := transport.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body := &recordedBody{ReadCloser: resp.Body}
+	body.closed = func(data []byte, truncated bool) error {
+		recorded := fixture{
+			Request:  request,
+			Response: recordedResponse{Status: resp.StatusCode, ContentType: resp.Header.Get("Content-Type"), Body: sessionKeyRegex.ReplaceAllString(string(data), "${1}"+redacted), Truncated: truncated},
+		}
+		content, err := json.MarshalIndent(recorded, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err = os.MkdirAll(transport.dir, 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(transport.dir, fileName), content, 0644)
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+// recordRequest returns the request without the credentials, reading the form of the body without consuming it
+func recordRequest(req *http.Request) (recordedRequest, error) {
+	form := url.Values{}
+	for field, values := range req.URL.Query() {
+		form[field] = values
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return recordedRequest{}, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(data))
+		bodyForm, err := url.ParseQuery(string(data))
+		if err != nil {
+			return recordedRequest{}, err
+		}
+		for field, values := range bodyForm {
+			form[field] = append(form[field], values...)
+		}
+	}
+	for _, field := range credentialFields {
+		form.Del(field)
+	}
+	return recordedRequest{Method: req.Method, Path: req.URL.Path, Form: form}, nil
+}
+
+func (body *recordedBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	recorded := p[:n]
+	if space := maxRecordedBodySize - body.buffer.Len(); len(recorded) > space {
+		recorded = recorded[:space]
+		body.truncated = true
+	}
+	body.buffer.Write(recorded)
+	return n, err
+}
+
+func (body *recordedBody) Close() error {
+	err := body.ReadCloser.Close()
+	body.once.Do(func() {
+		if recordErr := body.closed(body.buffer.Bytes(), body.truncated); recordErr != nil && err == nil {
+			err = recordErr
+		}
+	})
+	return err
+}
+
+// newReplayTransport loads the fixtures of the directory, in the order they were recorded
+func newReplayTransport(dir string) (*replayTransport, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	transport := &replayTransport{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		recorded := fixture{}
+		if err = json.Unmarshal(data, &recorded); err != nil {
+			return nil, fmt.Errorf("invalid fixture %s: %v", file, err)
+		}
+		transport.fixtures = append(transport.fixtures, recorded)
+	}
+	transport.served = make([]bool, len(transport.fixtures))
+	return transport, nil
+}
+
+func (transport *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	request, err := recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	transport.Lock()
+	defer transport.Unlock()
+	match := -1
+	for i, recorded := range transport.fixtures {
+		if recorded.Request.Method != request.Method || recorded.Request.Path != request.Path || !sameForm(recorded.Request.Form, request.Form) {
+			continue
+		}
+		match = i
+		if !transport.served[i] {
+			break
+		}
+	}
+	if match < 0 {
+		return nil, fmt.Errorf("no recorded response for %s %s with %s", request.Method, request.Path, request.Form.Encode())
+	}
+	transport.served[match] = true
+
+	recorded := transport.fixtures[match].Response
+	header := http.Header{}
+	if recorded.ContentType != "" {
+		header.Set("Content-Type", recorded.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
+
+func sameForm(recorded url.Values, form url.Values) bool {
+	if len(recorded) == 0 && len(form) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(recorded, form)
+}
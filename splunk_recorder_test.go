@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/splunk-event-reader/splunktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplunkService_RecordAndReplay(t *testing.T) {
+	recordDir, err := ioutil.TempDir("", "splunk-record")
+	assert.NoError(t, err)
+	defer os.RemoveAll(recordDir)
+
+	splunkServer := splunktest.NewServer(splunktest.Event{"@time": "2017-09-19T14:04:00Z", "environment": "xp", "monitoring_event": "true", "content_type": "Annotations", "event": "PublishStart", "transaction_id": "tid_1", "uuid": "uuid_1"})
+	defer splunkServer.Close()
+	splunkServer.User = "user"
+	splunkServer.Password = "secret-password"
+	splunkServer.Now = func() time.Time {
+		return time.Date(2017, 9, 19, 14, 5, 0, 0, time.UTC)
+	}
+
+	query := monitoringQuery{ContentType: "annotations", EarliestTime: "-10m"}
+	recorder := newSplunkService(splunkAccessConfig{user: "user", password: "secret-password", restURLs: []string{splunkServer.URL}, environment: "xp", recordDir: recordDir})
+	recorded, err := recorder.GetTransactions(query)
+	assert.NoError(t, err)
+	assert.Len(t, recorded, 1)
+
+	files, err := filepath.Glob(filepath.Join(recordDir, "*.json"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, files)
+	assert.True(t, strings.HasPrefix(filepath.Base(files[0]), "0001-POST-services_search_jobs"), files[0])
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(content), "secret-password", file)
+		assert.True(t, json.Valid(content), file)
+	}
+
+	// the recorded responses are served without the Splunk server
+	replay, err := newReplayTransport(recordDir)
+	assert.NoError(t, err)
+	replayer := newSplunkService(splunkAccessConfig{user: "other", password: "other", restURLs: []string{"http://splunk.invalid:8089"}, environment: "xp"}).(*splunkService)
+	replayer.HTTPClient.Transport = replay
+	replayed, err := replayer.GetTransactions(query)
+	assert.NoError(t, err)
+	assert.Equal(t, recorded, replayed)
+
+	_, err = replayer.GetTransactions(monitoringQuery{ContentType: "images", EarliestTime: "-10m"})
+	assert.Error(t, err)
+}
+
+// TestSplunkService_ReplayRecordedFixtures runs the service against the checked in recording of testdata/recorded; as
+// the requests are matched on their parameters, it fails when the SPL or the job parameters change, in which case the
+// recording is to be made again with --record-dir
+func TestSplunkService_ReplayRecordedFixtures(t *testing.T) {
+	replay, err := newReplayTransport("testdata/recorded")
+	assert.NoError(t, err)
+	splunkReader := newSplunkService(splunkAccessConfig{user: "user", password: "password", restURLs: []string{"http://splunk.invalid:8089"}, environment: "xp"}).(*splunkService)
+	splunkReader.HTTPClient.Transport = replay
+
+	transactions, err := splunkReader.GetTransactions(monitoringQuery{ContentType: "annotations", Services: []string{"nativerw"}})
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 1)
+	assert.Equal(t, "tid_hamoil09hg", transactions[0].TransactionID)
+	assert.Equal(t, "27355ee6-e280-4fb8-b825-8f14be1be9d3", transactions[0].UUID)
+	assert.Equal(t, "0", transactions[0].ClosedTxn)
+	assert.Equal(t, 6, transactions[0].EventCount)
+	var eventNames []string
+	for _, event := range transactions[0].Events {
+		eventNames = append(eventNames, event.Event)
+	}
+	assert.Equal(t, []string{"ContentWriteElasticsearch", "Combine", "Forwarding", "Ingest", "NativeSave", "Forwarding"}, eventNames)
+	assert.Equal(t, "1792358380", transactions[0].Events[0].IndexTime)
+
+	events, _, err := splunkReader.GetLastEvents(monitoringQuery{ContentType: "annotations", Events: []string{"Forwarding", "Ingest"}}, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []publishEvent{
+		{ContentType: "Annotations", Event: "Forwarding", Level: "info", ServiceName: "cms-metadata-kafka-bridge-pub-xp", Time: "2017-09-19T13:59:58.442130319Z", TransactionID: "tid_hamoil09hg"},
+		{ContentType: "Annotations", Event: "Ingest", Level: "info", ServiceName: "native-ingester-metadata", Time: "2017-09-19T13:59:53.44657054Z", TransactionID: "tid_hamoil09hg", UUID: "27355ee6-e280-4fb8-b825-8f14be1be9d3"},
+		{ContentType: "Annotations", Event: "Forwarding", Level: "info", ServiceName: "cms-metadata-kafka-bridge-pub-prod", Time: "2017-09-19T13:59:48.248989749Z", TransactionID: "tid_hamoil09hg"},
+	}, events)
+
+	_, err = splunkReader.GetTransactions(monitoringQuery{ContentType: "annotations", Services: []string{"methode-article-mapper"}})
+	assert.Error(t, err, "a search that was not recorded is not answered")
+}
+
+func TestRecordingTransport_RedactsCredentials(t *testing.T) {
+	recordDir, err := ioutil.TempDir("", "splunk-record")
+	assert.NoError(t, err)
+	defer os.RemoveAll(recordDir)
+
+	splunkServer := splunktest.NewServer()
+	defer splunkServer.Close()
+	splunkServer.User = "admin"
+	splunkServer.Password = "changeme"
+
+	client := &http.Client{Transport: newRecordingTransport(recordDir, http.DefaultTransport)}
+	resp, err := client.Post(splunkServer.URL+splunktest.LoginPath, "application/x-www-form-urlencoded", strings.NewReader("username=admin&password=changeme&output_mode=json"))
+	assert.NoError(t, err)
+	login := struct {
+		SessionKey string `json:"sessionKey"`
+	}{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&login))
+	resp.Body.Close()
+	assert.NotEmpty(t, login.SessionKey)
+
+	content, err := ioutil.ReadFile(filepath.Join(recordDir, "0001-POST-services_auth_login.json"))
+	assert.NoError(t, err)
+	recorded := fixture{}
+	assert.NoError(t, json.Unmarshal(content, &recorded))
+	assert.Equal(t, recordedRequest{Method: "POST", Path: splunktest.LoginPath, Form: map[string][]string{"output_mode": {"json"}}}, recorded.Request)
+	assert.Equal(t, http.StatusOK, recorded.Response.Status)
+	assert.NotContains(t, recorded.Response.Body, login.SessionKey)
+	assert.Contains(t, recorded.Response.Body, redacted)
+}
+
+func TestRecordingTransport_CapsRecordedBody(t *testing.T) {
+	recordDir, err := ioutil.TempDir("", "splunk-record")
+	assert.NoError(t, err)
+	defer os.RemoveAll(recordDir)
+
+	stream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := []byte(`{"result": {"_raw": "event"}}` + "\n")
+		for written := 0; written <= 2*maxRecordedBodySize; written += len(event) {
+			w.Write(event)
+		}
+	}))
+	defer stream.Close()
+
+	client := &http.Client{Transport: newRecordingTransport(recordDir, http.DefaultTransport)}
+	resp, err := client.Get(stream.URL + "/services/search/jobs/export")
+	assert.NoError(t, err)
+	read, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.True(t, len(read) > 2*maxRecordedBodySize)
+
+	content, err := ioutil.ReadFile(filepath.Join(recordDir, "0001-GET-services_search_jobs_export.json"))
+	assert.NoError(t, err)
+	recorded := fixture{}
+	assert.NoError(t, json.Unmarshal(content, &recorded))
+	assert.Len(t, recorded.Response.Body, maxRecordedBodySize)
+	assert.True(t, recorded.Response.Truncated)
+}
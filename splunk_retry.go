@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	searchRetriesMetric  = "splunk.search.retries"
+	searchSuccessMetric  = "splunk.search.success"
+	searchFailureMetric  = "splunk.search.failure"
+	defaultMaxAttempts   = 2
+	defaultBaseBackoff   = 2 * time.Second
+	defaultMaxBackoff    = 30 * time.Second
+	defaultBackoffJitter = 0.2
+)
+
+// retryPolicy decides whether and when a failed Splunk search is tried again
+type retryPolicy struct {
+	maxAttempts int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	// jitter is the fraction of each backoff that is randomised, between 0 and 1
+	jitter float64
+	// budget limits the overall time of the attempts and backoffs, no limit if 0
+	budget time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{
+	maxAttempts: defaultMaxAttempts,
+	baseBackoff: defaultBaseBackoff,
+	maxBackoff:  defaultMaxBackoff,
+	jitter:      defaultBackoffJitter,
+}
+
+func parseRetryPolicy(maxAttempts int, baseBackoff string, maxBackoff string, jitter float64, budget string) (retryPolicy, error) {
+	policy := retryPolicy{maxAttempts: maxAttempts, jitter: jitter}
+	var err error
+	if policy.baseBackoff, err = time.ParseDuration(baseBackoff); err != nil {
+		return retryPolicy{}, fmt.Errorf("invalid base backoff %s", baseBackoff)
+	}
+	if policy.maxBackoff, err = time.ParseDuration(maxBackoff); err != nil {
+		return retryPolicy{}, fmt.Errorf("invalid max backoff %s", maxBackoff)
+	}
+	if budget != "" {
+		if policy.budget, err = time.ParseDuration(budget); err != nil {
+			return retryPolicy{}, fmt.Errorf("invalid budget %s", budget)
+		}
+	}
+	return policy, policy.validate()
+}
+
+func (policy retryPolicy) validate() error {
+	if policy.maxAttempts < 1 {
+		return fmt.Errorf("max attempts must be at least 1, it is %d", policy.maxAttempts)
+	}
+	if policy.baseBackoff < 0 || policy.maxBackoff < policy.baseBackoff {
+		return fmt.Errorf("backoff must be between 0 and the max backoff %v, it is %v", policy.maxBackoff, policy.baseBackoff)
+	}
+	if policy.jitter < 0 || policy.jitter > 1 {
+		return fmt.Errorf("jitter must be between 0 and 1, it is %v", policy.jitter)
+	}
+	if policy.budget < 0 {
+		return fmt.Errorf("budget must not be negative, it is %v", policy.budget)
+	}
+	return nil
+}
+
+// do calls the search until it succeeds, fails with an error that is not transient, or runs out of attempts or budget
+func (policy retryPolicy) do(search func() error) error {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := search()
+		if err == nil {
+			metrics.GetOrRegisterCounter(searchSuccessMetric, metrics.DefaultRegistry).Inc(1)
+			return nil
+		}
+
+		backoff := policy.backoff(attempt)
+		if attempt >= policy.maxAttempts || !isRetryable(err) || (policy.budget > 0 && time.Since(start)+backoff > policy.budget) {
+			countFailure(err)
+			return err
+		}
+
+		metrics.GetOrRegisterCounter(searchRetriesMetric, metrics.DefaultRegistry).Inc(1)
+		time.Sleep(backoff)
+	}
+}
+
+// backoff doubles with each attempt up to the max backoff, then the jitter takes a random part off it
+func (policy retryPolicy) backoff(attempt int) time.Duration {
+	backoff := policy.maxBackoff
+	if attempt < 32 && policy.baseBackoff<<uint(attempt-1) < policy.maxBackoff {
+		backoff = policy.baseBackoff << uint(attempt-1)
+	}
+	return backoff - time.Duration(policy.jitter*rand.Float64()*float64(backoff))
+}
+
+func isRetryable(err error) bool {
+	var splunkErr *SplunkError
+	return errors.As(err, &splunkErr) && splunkErr.retryable()
+}
+
+func countFailure(err error) {
+	metrics.GetOrRegisterCounter(searchFailureMetric, metrics.DefaultRegistry).Inc(1)
+	var splunkErr *SplunkError
+	if errors.As(err, &splunkErr) {
+		metrics.GetOrRegisterCounter(fmt.Sprintf("%s.%s", searchFailureMetric, splunkErr.Class), metrics.DefaultRegistry).Inc(1)
+	}
+}
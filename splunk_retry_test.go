@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy_Do(t *testing.T) {
+	tests := []struct {
+		name             string
+		err              error
+		expectedAttempts int
+	}{
+		{"transport", newTransportError(errors.New("connection reset by peer")), 3},
+		{"quota", &SplunkError{Class: classQuota}, 3},
+		{"server", &SplunkError{Class: classServer}, 3},
+		{"timeout", &SplunkError{Class: classTimeout}, 3},
+		{"auth", &SplunkError{Class: classAuth}, 1},
+		{"syntax", &SplunkError{Class: classSyntax}, 1},
+		{"peer", &SplunkError{Class: classPeer}, 1},
+		{"job", &SplunkError{Class: classJobFailure}, 1},
+		{"unclassified", errors.New("invalid job details"), 1},
+	}
+
+	policy := retryPolicy{maxAttempts: 3}
+	for _, test := range tests {
+		retries := metrics.GetOrRegisterCounter(searchRetriesMetric, metrics.DefaultRegistry)
+		retries.Clear()
+		failures := metrics.GetOrRegisterCounter(searchFailureMetric, metrics.DefaultRegistry)
+		failures.Clear()
+
+		attempts := 0
+		err := policy.do(func() error {
+			attempts++
+			return test.err
+		})
+		assert.Equal(t, test.err, err, test.name)
+		assert.Equal(t, test.expectedAttempts, attempts, test.name)
+		assert.Equal(t, int64(test.expectedAttempts-1), retries.Count(), test.name)
+		assert.Equal(t, int64(1), failures.Count(), test.name)
+	}
+}
+
+func TestRetryPolicy_DoSucceedsAfterRetry(t *testing.T) {
+	successes := metrics.GetOrRegisterCounter(searchSuccessMetric, metrics.DefaultRegistry)
+	successes.Clear()
+
+	attempts := 0
+	err := retryPolicy{maxAttempts: 3}.do(func() error {
+		attempts++
+		if attempts == 1 {
+			return &SplunkError{Class: classQuota}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, int64(1), successes.Count())
+}
+
+func TestRetryPolicy_DoBudget(t *testing.T) {
+	attempts := 0
+	policy := retryPolicy{maxAttempts: 10, baseBackoff: 20 * time.Millisecond, maxBackoff: 20 * time.Millisecond, budget: 50 * time.Millisecond}
+	err := policy.do(func() error {
+		attempts++
+		return &SplunkError{Class: classServer}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := retryPolicy{baseBackoff: time.Second, maxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, policy.backoff(1))
+	assert.Equal(t, 2*time.Second, policy.backoff(2))
+	assert.Equal(t, 4*time.Second, policy.backoff(3))
+	assert.Equal(t, 5*time.Second, policy.backoff(4))
+	assert.Equal(t, 5*time.Second, policy.backoff(100))
+
+	policy.jitter = 0.5
+	for i := 0; i < 100; i++ {
+		backoff := policy.backoff(2)
+		assert.True(t, backoff > time.Second && backoff <= 2*time.Second, backoff)
+	}
+}
+
+func TestParseRetryPolicy(t *testing.T) {
+	tests := []struct {
+		maxAttempts int
+		baseBackoff string
+		maxBackoff  string
+		jitter      float64
+		budget      string
+		hasError    bool
+	}{
+		{2, "2s", "30s", 0.2, "", false},
+		{5, "100ms", "1s", 0, "10s", false},
+		{0, "2s", "30s", 0.2, "", true},
+		{2, "2 seconds", "30s", 0.2, "", true},
+		{2, "2s", "1s", 0.2, "", true},
+		{2, "2s", "30s", 1.5, "", true},
+		{2, "2s", "30s", 0.2, "-1m", true},
+		{2, "2s", "30s", 0.2, "forever", true},
+	}
+
+	for _, test := range tests {
+		policy, err := parseRetryPolicy(test.maxAttempts, test.baseBackoff, test.maxBackoff, test.jitter, test.budget)
+		if test.hasError {
+			assert.Error(t, err, "%+v", test)
+		} else {
+			assert.NoError(t, err, "%+v", test)
+			assert.Equal(t, test.maxAttempts, policy.maxAttempts)
+		}
+	}
+}
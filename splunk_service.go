@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
@@ -9,41 +10,73 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/giantswarm/retry-go"
+	health "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/rcrowley/go-metrics"
 )
 
 const (
 	splunkEndpoint            = "/services/search/jobs"
 	defaultEarliestTime       = "-10m"
-	transactionsQueryTemplate = `search index="%s" monitoring_event=true (environment="%s" OR environment="%s-publish*") (content_type="%s" OR content_type="") transaction_id!="SYNTHETIC*" transaction_id!="*carousel*"  | fields content_type, event, isValid, level, service_name, @time, transaction_id, uuid`
-	latestEventQueryTemplate  = `search index="%s" monitoring_event=true (environment="%s" OR environment="%s-publish*") content_type="%s" event="PublishEnd" | fields content_type, event, isValid, level, service_name, @time, transaction_id, uuid | head 1`
-	healthcheckQuery          = `search index=_audit | head 1`
-	healthCachePeriod         = time.Minute * 5
+	defaultCursorLookback     = 10 * time.Minute
+	maxUUIDsPerSearch         = 100
+	maxParallelSearches       = 4
+	transactionsQueryTemplate = `search index="%s" monitoring_event=true (environment="%s" OR environment="%s-publish*") (%s OR content_type="") transaction_id!="SYNTHETIC*" transaction_id!="*carousel*"  | eval indextime=_indextime | fields content_type, event, isValid, level, service_name, @time, indextime, transaction_id, uuid`
+	// keeps whole transactions having at least one event matching the predicate
+	transactionFilterTemplate = ` | eval filter_match=if(%s, 1, 0) | eventstats max(filter_match) as txn_match by transaction_id | where txn_match=1 | fields - filter_match, txn_match`
+	// keeps whole transactions having at least one event indexed in the second of the cursor or later; the ones whose
+	// events of that second are not after the cursor are dropped once assembled
+	cursorFilterTemplate     = ` | eventstats max(indextime) as latest_indextime by transaction_id | where latest_indextime>=%d | fields - latest_indextime`
+	latestEventQueryTemplate = `search index="%s" monitoring_event=true (environment="%s" OR environment="%s-publish*") %s%s | fields content_type, event, isValid, level, service_name, @time, transaction_id, uuid | head %d`
+	// returns the latest event of each content type
+	latestEventByContentTypeQueryTemplate = `search index="%s" monitoring_event=true (environment="%s" OR environment="%s-publish*") %s%s | fields content_type, event, isValid, level, service_name, @time, transaction_id, uuid | dedup content_type`
+	defaultLastEvent                      = "PublishEnd"
+	streamQueryTemplate                   = `search index="%s" monitoring_event=true (environment="%s" OR environment="%s-publish*") (content_type="%s" OR content_type="")%s transaction_id!="SYNTHETIC*" transaction_id!="*carousel*" | eval indextime=_indextime | fields content_type, event, isValid, level, service_name, @time, indextime, transaction_id, uuid`
+	// counts the error events of the transactions having an event of the content type, as the events of the shared
+	// services have no content type
+	errorsQueryTemplate = `search index="%s" monitoring_event=true (environment="%s" OR environment="%s-publish*") (content_type="%s" OR content_type="") transaction_id!="SYNTHETIC*" transaction_id!="*carousel*" | eval content_type_match=if(content_type!="", 1, 0) | eventstats max(content_type_match) as txn_content_type_match by transaction_id | search txn_content_type_match=1 (level="error" OR isValid="false") | stats count, values(transaction_id) as transaction_ids, max(@time) as latest_time by service_name, event`
+	maxErrorSamples     = 5
+	// counts transactions in the bucket of their first event, a transaction being completed if it has a PublishEnd event
+	throughputQueryTemplate = `search index="%s" monitoring_event=true (environment="%s" OR environment="%s-publish*") (content_type="%s" OR content_type="") (event="PublishStart" OR event="PublishEnd") transaction_id!="SYNTHETIC*" transaction_id!="*carousel*" | stats min(_time) as _time, max(eval(if(event="PublishEnd", 1, 0))) as closed by transaction_id | eval open=1-closed | timechart span=%s count as started, sum(closed) as completed, sum(open) as open | eval time=_time | fields time, started, completed, open`
+	streamEarliestTime      = "-1m"
+	// events are polled until they are indexed this long after the latest indexed one was logged
+	streamPollLag     = 5 * time.Minute
+	cancelJobTimeout  = 10 * time.Second
+	healthcheckQuery  = `search index=_audit | head 1`
+	healthCachePeriod = time.Minute * 5
 )
 
-// ErrNoResults returned when the Splunk query yields no results
-var ErrNoResults = errors.New("No results")
-var regionRegex = regexp.MustCompile("-delivery-(eu|us)$")
+const (
+	warningPolicyIgnore = "ignore"
+	warningPolicyReport = "report"
+	warningPolicyFail   = "fail"
+	warningsMetric      = "splunk.job.warnings"
+)
 
-// SplunkServiceI Splunk based event reader service
-type SplunkServiceI interface {
-	GetTransactions(query monitoringQuery) ([]transactionEvent, error)
-	GetLastEvent(query monitoringQuery) (*publishEvent, error)
-	doQuery(queryString string) (*http.Response, error)
-	IsHealthy() healthStatus
-}
+var regionRegex = regexp.MustCompile("-delivery-(eu|us)$")
 
 type splunkAccessConfig struct {
-	user        string
-	password    string
-	restURL     string
-	environment string
-	region      string
-	index       string
+	user     string
+	password string
+	// restURLs are the search heads, selected by headSelection
+	restURLs      []string
+	headSelection string
+	environment   string
+	region        string
+	index         string
+	// warningPolicy is one of ignore, report or fail, deciding what to do with the WARN messages of Splunk jobs
+	warningPolicy string
+	// streamPollInterval switches event streaming from a real-time search to polling when set
+	streamPollInterval time.Duration
+	retryPolicy        retryPolicy
+	breaker            breakerConfig
+	// recordDir is where the requests and responses are recorded when set, without the credentials
+	recordDir string
 }
 
 type splunkService struct {
@@ -51,13 +84,8 @@ type splunkService struct {
 	HTTPClient *http.Client
 	Config     splunkAccessConfig
 	lastHealth healthStatus
-}
-
-type monitoringQuery struct {
-	ContentType  string
-	EarliestTime string
-	LatestTime   string
-	UUIDs        []string
+	breaker    *circuitBreaker
+	heads      *searchHeads
 }
 
 type searchResponse struct {
@@ -76,6 +104,10 @@ type jobDetailsContent struct {
 	DispatchState string       `json:"dispatchState"`
 	Messages      []jobMessage `json:"messages"`
 	IsDone        bool         `json:"isDone"`
+	IsFinalized   bool         `json:"isFinalized"`
+	EarliestTime  string       `json:"earliestTime"`
+	LatestTime    string       `json:"latestTime"`
+	RunDuration   float64      `json:"runDuration"`
 }
 
 type jobMessage struct {
@@ -84,26 +116,122 @@ type jobMessage struct {
 	Help string `json:"help"`
 }
 
+type throughputResponse struct {
+	Results []throughputBucket `json:"results"`
+}
+
+type throughputBucket struct {
+	Time      string `json:"time"`
+	Started   string `json:"started"`
+	Completed string `json:"completed"`
+	Open      string `json:"open"`
+}
+
+type errorsResponse struct {
+	Results []errorsResult `json:"results"`
+}
+
+type errorsResult struct {
+	ServiceName    string     `json:"service_name"`
+	Event          string     `json:"event"`
+	Count          string     `json:"count"`
+	TransactionIDs multiValue `json:"transaction_ids"`
+	LatestTime     string     `json:"latest_time"`
+}
+
+// multiValue is a field of the search results that may have several values, given as an array, or a single one
+type multiValue []string
+
+func (values *multiValue) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*values = []string{value}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(values))
+}
+
+type exportResponse struct {
+	Preview bool          `json:"preview"`
+	Result  *publishEvent `json:"result"`
+}
+
 type sidResponse struct {
 	Sid string `json:"sid"`
 }
 
-type JobFailure struct {
-	message string
+func (service *splunkService) GetTransactions(query monitoringQuery) ([]transactionEvent, error) {
+	transactions, _, err := service.GetTransactionsWithMetadata(query)
+	return transactions, err
 }
 
-func (jobFailure *JobFailure) Error() string {
-	return jobFailure.message
+// GetTransactionsWithMetadata returns the transactions along with the details of the Splunk searches run for them
+func (service *splunkService) GetTransactionsWithMetadata(query monitoringQuery) ([]transactionEvent, searchMetadata, error) {
+	if len(query.UUIDs) > maxUUIDsPerSearch {
+		return service.getTransactionsInChunks(query)
+	}
+	transactions, metadata, err := service.searchTransactions(query, []string{query.ContentType})
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	return transactions[query.ContentType], metadata, nil
 }
 
-func NewJobFailure(message string) *JobFailure {
-	return &JobFailure{
-		message: message,
+// getTransactionsInChunks splits a large UUID set into several searches run in parallel, and merges their results
+func (service *splunkService) getTransactionsInChunks(query monitoringQuery) ([]transactionEvent, searchMetadata, error) {
+	var chunks [][]string
+	for start := 0; start < len(query.UUIDs); start += maxUUIDsPerSearch {
+		end := start + maxUUIDsPerSearch
+		if end > len(query.UUIDs) {
+			end = len(query.UUIDs)
+		}
+		chunks = append(chunks, query.UUIDs[start:end])
+	}
+
+	results := make([][]transactionEvent, len(chunks))
+	metadata := make([]searchMetadata, len(chunks))
+	errs := make([]error, len(chunks))
+	// Splunk limits concurrent searches per user, so only a few chunks are searched at a time
+	semaphore := make(chan struct{}, maxParallelSearches)
+	wg := sync.WaitGroup{}
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk []string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			chunkQuery := query
+			chunkQuery.UUIDs = chunk
+			// a transaction may only be closed in one of the chunks, so the closed ones are dropped once merged
+			chunkQuery.IncludeClosed = true
+			results[i], metadata[i], errs[i] = service.GetTransactionsWithMetadata(chunkQuery)
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, searchMetadata{}, err
+		}
+	}
+
+	merged := searchMetadata{}
+	for _, m := range metadata {
+		merged.Merge(m)
 	}
+
+	// a transaction is returned by several chunks if its events refer to UUIDs of different chunks
+	return dropClosedTransactions(mergeTransactions(results), query), merged, nil
 }
 
-func (service *splunkService) GetTransactions(query monitoringQuery) ([]transactionEvent, error) {
-	queryString := fmt.Sprintf(transactionsQueryTemplate, service.Config.index, service.Config.environment, regionRegex.ReplaceAllString(service.Config.environment, ""), query.ContentType)
+// GetTransactionsByContentType runs a single search for all the content types and groups the transactions by content type
+func (service *splunkService) GetTransactionsByContentType(query monitoringQuery, contentTypes []string) (map[string][]transactionEvent, searchMetadata, error) {
+	return service.searchTransactions(query, contentTypes)
+}
+
+func (service *splunkService) searchTransactions(query monitoringQuery, contentTypes []string) (map[string][]transactionEvent, searchMetadata, error) {
+	queryString := fmt.Sprintf(transactionsQueryTemplate, service.Config.index, service.Config.environment, regionRegex.ReplaceAllString(service.Config.environment, ""), contentTypeClause(contentTypes))
 
 	if len(query.UUIDs) > 0 {
 		queryString += " | search uuid IN ("
@@ -113,10 +241,20 @@ func (service *splunkService) GetTransactions(query monitoringQuery) ([]transact
 		queryString += ")"
 	}
 
+	if predicate := eventFilterPredicate(query); predicate != "" {
+		queryString += fmt.Sprintf(transactionFilterTemplate, predicate)
+	}
+
+	if query.Cursor != nil {
+		queryString += fmt.Sprintf(cursorFilterTemplate, query.Cursor.IndexTime)
+	}
+
 	v := url.Values{}
 	v.Set("search", queryString)
 	if query.EarliestTime != "" {
 		v.Set("earliest_time", query.EarliestTime)
+	} else if query.Cursor != nil {
+		v.Set("earliest_time", query.Cursor.earliestTime(defaultCursorLookback))
 	} else {
 
 		v.Set("earliest_time", defaultEarliestTime)
@@ -126,116 +264,453 @@ func (service *splunkService) GetTransactions(query monitoringQuery) ([]transact
 		v.Set("latest_time", query.LatestTime)
 	}
 
-	resp, err := service.doQuery(v.Encode())
+	resp, metadata, err := service.runSearch(context.Background(), v.Encode())
 
 	if err != nil {
-		return nil, err
+		return nil, searchMetadata{}, err
 	}
 
 	defer resp.Body.Close()
-	transactions := []transactionEvent{}
-
-	txMap := make(map[string]*transactionEvent)
 	decoder := json.NewDecoder(bufio.NewReader(resp.Body))
 	response := searchResponse{}
 	err = decoder.Decode(&response)
 	if err != nil {
-		return nil, err
+		return nil, searchMetadata{}, err
+	}
+	metadata.EventCount = len(response.Results)
+	transactions := assembleTransactions(response.Results, query, contentTypes)
+	if query.Cursor != nil {
+		for contentType, contentTypeTransactions := range transactions {
+			transactions[contentType] = updatedSince(contentTypeTransactions, query.Cursor)
+		}
 	}
-	for _, event := range response.Results {
 
-		transaction := txMap[event.TransactionID]
+	return transactions, metadata, nil
+}
+
+func (service *splunkService) GetLastEvent(query monitoringQuery) (*publishEvent, searchMetadata, error) {
+	events, metadata, err := service.GetLastEvents(query, 1)
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+
+	if len(events) > 0 {
+		publishEvent := events[0]
+		return &publishEvent, metadata, nil
+	}
+
+	return nil, metadata, ErrNoResults
+}
+
+// GetLastEventByContentType runs a single search for the last event of each content type; content types without events are left out
+func (service *splunkService) GetLastEventByContentType(query monitoringQuery, contentTypes []string) (map[string]publishEvent, searchMetadata, error) {
+	events := query.Events
+	if len(events) == 0 {
+		events = []string{defaultLastEvent}
+	}
+	filters := inClause("event", events) + inClause("uuid", query.UUIDs) + inClause("service_name", query.Services) + inClause("level", query.Levels)
+	queryString := fmt.Sprintf(latestEventByContentTypeQueryTemplate, service.Config.index, service.Config.environment, regionRegex.ReplaceAllString(service.Config.environment, ""), contentTypeClause(contentTypes), filters)
+
+	v := url.Values{}
+	v.Set("search", queryString)
+	if query.EarliestTime != "" {
+		v.Set("earliest_time", query.EarliestTime)
+	}
 
-		if transaction == nil {
-			transaction = &transactionEvent{
-				TransactionID: event.TransactionID,
-				ClosedTxn:     "0",
+	results, metadata, err := service.searchEvents(context.Background(), v.Encode())
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+
+	lastEvents := make(map[string]publishEvent)
+	for _, event := range results {
+		for _, contentType := range contentTypes {
+			if _, found := lastEvents[contentType]; !found && strings.EqualFold(event.ContentType, contentType) {
+				lastEvents[contentType] = event
 			}
+		}
+	}
+	return lastEvents, metadata, nil
+}
+
+// GetLastEvents returns the latest events matching the query, latest first; PublishEnd events are returned if no event name is set
+func (service *splunkService) GetLastEvents(query monitoringQuery, limit int) ([]publishEvent, searchMetadata, error) {
+	events := query.Events
+	if len(events) == 0 {
+		events = []string{defaultLastEvent}
+	}
+	filters := inClause("event", events) + inClause("uuid", query.UUIDs) + inClause("service_name", query.Services) + inClause("level", query.Levels)
+	queryString := fmt.Sprintf(latestEventQueryTemplate, service.Config.index, service.Config.environment, regionRegex.ReplaceAllString(service.Config.environment, ""), contentTypeClause([]string{query.ContentType}), filters, limit)
+
+	v := url.Values{}
+	v.Set("search", queryString)
+	if query.EarliestTime != "" {
+		v.Set("earliest_time", query.EarliestTime)
+	}
+
+	return service.searchEvents(context.Background(), v.Encode())
+}
+
+// GetErrors returns the error level and invalid events grouped by service and event, most frequent first
+func (service *splunkService) GetErrors(query monitoringQuery) ([]errorReport, searchMetadata, error) {
+	queryString := fmt.Sprintf(errorsQueryTemplate, service.Config.index, service.Config.environment, regionRegex.ReplaceAllString(service.Config.environment, ""), query.ContentType)
+
+	v := url.Values{}
+	v.Set("search", queryString)
+	if query.EarliestTime != "" {
+		v.Set("earliest_time", query.EarliestTime)
+	} else {
+		v.Set("earliest_time", defaultEarliestTime)
+	}
+	if query.LatestTime != "" {
+		v.Set("latest_time", query.LatestTime)
+	}
+
+	resp, metadata, err := service.runSearch(context.Background(), v.Encode())
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	defer resp.Body.Close()
 
-			txMap[event.TransactionID] = transaction
+	decoder := json.NewDecoder(bufio.NewReader(resp.Body))
+	response := errorsResponse{}
+	if err = decoder.Decode(&response); err != nil {
+		return nil, searchMetadata{}, err
+	}
+	metadata.EventCount = len(response.Results)
+
+	reports := []errorReport{}
+	for _, result := range response.Results {
+		count, err := strconv.Atoi(result.Count)
+		if err != nil {
+			return nil, searchMetadata{}, fmt.Errorf("invalid error count %q: %v", result.Count, err)
 		}
+		samples := append([]string{}, result.TransactionIDs...)
+		if len(samples) > maxErrorSamples {
+			samples = samples[:maxErrorSamples]
+		}
+		reports = append(reports, errorReport{ServiceName: result.ServiceName, Event: result.Event, Count: count, SampleTransactionIDs: samples, LatestTime: result.LatestTime})
+	}
+
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].Count > reports[j].Count
+	})
+	return reports, metadata, nil
+}
 
-		if event.UUID != "" {
-			transaction.UUID = event.UUID
+// GetThroughput returns the number of started, completed and still open transactions per time bucket
+func (service *splunkService) GetThroughput(query monitoringQuery, span string) ([]timeSeries, searchMetadata, error) {
+	queryString := fmt.Sprintf(throughputQueryTemplate, service.Config.index, service.Config.environment, regionRegex.ReplaceAllString(service.Config.environment, ""), query.ContentType, span)
+
+	v := url.Values{}
+	v.Set("search", queryString)
+	if query.EarliestTime != "" {
+		v.Set("earliest_time", query.EarliestTime)
+	} else {
+		v.Set("earliest_time", defaultEarliestTime)
+	}
+	if query.LatestTime != "" {
+		v.Set("latest_time", query.LatestTime)
+	}
+
+	resp, metadata, err := service.runSearch(context.Background(), v.Encode())
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(resp.Body))
+	response := throughputResponse{}
+	err = decoder.Decode(&response)
+	if err != nil {
+		return nil, searchMetadata{}, err
+	}
+	metadata.EventCount = len(response.Results)
+
+	started := timeSeries{Target: "started", Datapoints: [][2]float64{}}
+	completed := timeSeries{Target: "completed", Datapoints: [][2]float64{}}
+	open := timeSeries{Target: "open", Datapoints: [][2]float64{}}
+	for _, bucket := range response.Results {
+		t, err := strconv.ParseFloat(bucket.Time, 64)
+		if err != nil {
+			return nil, searchMetadata{}, fmt.Errorf("invalid time bucket %q: %v", bucket.Time, err)
 		}
+		// timechart leaves sums of empty buckets blank
+		startedCount, _ := strconv.ParseFloat(bucket.Started, 64)
+		completedCount, _ := strconv.ParseFloat(bucket.Completed, 64)
+		openCount, _ := strconv.ParseFloat(bucket.Open, 64)
+
+		millis := t * 1000
+		started.Datapoints = append(started.Datapoints, [2]float64{startedCount, millis})
+		completed.Datapoints = append(completed.Datapoints, [2]float64{completedCount, millis})
+		open.Datapoints = append(open.Datapoints, [2]float64{openCount, millis})
+	}
 
-		transaction.Events = append(transaction.Events, event)
-		transaction.EventCount++
-		if event.Event == "PublishStart" {
-			transaction.StartTime = event.Time
+	return []timeSeries{started, completed, open}, metadata, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
 		}
-		if event.Event == "PublishEnd" {
-			transaction.ClosedTxn = "1"
+	}
+	return false
+}
+
+// eventFilterPredicate returns an eval expression matching the events selected by the service, level, event and validity filters
+func eventFilterPredicate(query monitoringQuery) string {
+	var predicates []string
+	for field, values := range map[string][]string{"service_name": query.Services, "level": query.Levels, "event": query.Events} {
+		if len(values) > 0 {
+			predicates = append(predicates, fmt.Sprintf("in(%s, %s)", field, quotedList(values)))
 		}
 	}
+	if query.IsValid != "" {
+		predicates = append(predicates, fmt.Sprintf(`isValid="%s"`, query.IsValid))
+	}
+	sort.Strings(predicates)
+	return strings.Join(predicates, " AND ")
+}
 
-	for _, transaction := range txMap {
-		if transaction.ClosedTxn != "1" {
-			// if transaction has at least one event with the required content type: keep it
-			for _, event := range transaction.Events {
-				if strings.EqualFold(event.ContentType, query.ContentType) {
-					transactions = append(transactions, *transaction)
-					break
-				}
-			}
+// hasMatchingEvent checks whether a single event of the transaction matches all the service, level, event and validity filters.
+// Searches apply the filters already, but poller snapshots are taken without them.
+func hasMatchingEvent(transaction transactionEvent, query monitoringQuery) bool {
+	if len(query.Services) == 0 && len(query.Levels) == 0 && len(query.Events) == 0 && query.IsValid == "" {
+		return true
+	}
+	for _, event := range transaction.Events {
+		if matchesAny(event.ServiceName, query.Services) && matchesAny(event.Level, query.Levels) && matchesAny(event.Event, query.Events) &&
+			(query.IsValid == "" || strings.EqualFold(event.IsValid, query.IsValid)) {
+			return true
 		}
 	}
+	return false
+}
 
-	return transactions, nil
+func matchesAny(value string, values []string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if strings.EqualFold(value, v) {
+			return true
+		}
+	}
+	return false
 }
 
-func (service *splunkService) GetLastEvent(query monitoringQuery) (*publishEvent, error) {
-	queryString := fmt.Sprintf(latestEventQueryTemplate, service.Config.index, service.Config.environment, regionRegex.ReplaceAllString(service.Config.environment, ""), query.ContentType)
+// StreamEvents sends the monitoring events matching the query as they arrive, until the context is cancelled or send fails
+func (service *splunkService) StreamEvents(ctx context.Context, query monitoringQuery, send func(event publishEvent) error, warn func(warning string)) error {
+	filters := inClause("uuid", query.UUIDs) + inClause("service_name", query.Services) + inClause("event", query.Events)
+	queryString := fmt.Sprintf(streamQueryTemplate, service.Config.index, service.Config.environment, regionRegex.ReplaceAllString(service.Config.environment, ""), query.ContentType, filters)
+
+	if service.Config.streamPollInterval > 0 {
+		return service.pollEvents(ctx, queryString, send, warn)
+	}
+	return service.exportRealtimeEvents(ctx, queryString, send)
+}
 
+// exportRealtimeEvents runs a real-time search through the export endpoint; the search lives as long as the connection,
+// so cancelling the context cancels the Splunk job
+func (service *splunkService) exportRealtimeEvents(ctx context.Context, queryString string, send func(event publishEvent) error) error {
 	v := url.Values{}
 	v.Set("search", queryString)
-	if query.EarliestTime != "" {
-		v.Set("earliest_time", query.EarliestTime)
+	v.Set("search_mode", "realtime")
+	v.Set("earliest_time", "rt"+streamEarliestTime)
+	v.Set("latest_time", "rt")
+	v.Set("output_mode", "json")
+
+	// a real-time search cannot be resumed on another head, so it only uses the first candidate
+	head := service.heads.candidates()[0]
+	serviceURL := fmt.Sprintf("%v%v/export", head.url, splunkEndpoint)
+	req, err := http.NewRequestWithContext(ctx, "POST", serviceURL, strings.NewReader(v.Encode()))
+	if err != nil {
+		return err
 	}
+	req.SetBasicAuth(service.Config.user, service.Config.password)
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err := service.doQuery(v.Encode())
+	resp, err := service.HTTPClient.Do(req)
 	if err != nil {
-		return nil, err
+		if ctx.Err() != nil {
+			return nil
+		}
+		err = newTransportError(err)
+		head.record(err)
+		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		err = newStatusError(resp)
+		head.record(err)
+		return err
+	}
+
 	decoder := json.NewDecoder(bufio.NewReader(resp.Body))
-	response := searchResponse{}
-	err = decoder.Decode(&response)
+	for {
+		line := exportResponse{}
+		if err = decoder.Decode(&line); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if line.Preview || line.Result == nil {
+			continue
+		}
+		if err = send(*line.Result); err != nil {
+			return err
+		}
+	}
+}
+
+// pollEvents repeatedly runs the query for events indexed since the previous run
+func (service *splunkService) pollEvents(ctx context.Context, queryString string, send func(event publishEvent) error, warn func(warning string)) error {
+	var lastIndexTime int64
+	// events indexed in the same second as the last one are queried again, so remember which ones were sent
+	sent := make(map[publishEvent]bool)
+
+	for {
+		v := url.Values{}
+		if lastIndexTime > 0 {
+			v.Set("search", queryString+fmt.Sprintf(" | where indextime>=%d", lastIndexTime))
+			// the window follows the index time rather than the poll time, so that no event is missed whatever the interval
+			v.Set("earliest_time", strconv.FormatInt(lastIndexTime-int64(streamPollLag.Seconds()), 10))
+		} else {
+			v.Set("search", queryString)
+			v.Set("earliest_time", streamEarliestTime)
+		}
+
+		events, metadata, err := service.searchEvents(ctx, v.Encode())
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, warning := range metadata.Warnings {
+			warn(warning)
+		}
+
+		nextSent := make(map[publishEvent]bool)
+		// results are returned latest first
+		for i := len(events) - 1; i >= 0; i-- {
+			event := events[i]
+			indexTime, _ := strconv.ParseInt(event.IndexTime, 10, 64)
+			if indexTime > lastIndexTime {
+				lastIndexTime = indexTime
+				nextSent = make(map[publishEvent]bool)
+			}
+			if indexTime == lastIndexTime {
+				nextSent[event] = true
+			}
+			if sent[event] {
+				continue
+			}
+			if err = send(event); err != nil {
+				return err
+			}
+		}
+		if len(nextSent) > 0 {
+			sent = nextSent
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(service.Config.streamPollInterval):
+		}
+	}
+}
+
+// searchEvents runs the query and returns its events, along with the details of the search
+func (service *splunkService) searchEvents(ctx context.Context, query string) ([]publishEvent, searchMetadata, error) {
+	resp, metadata, err := service.runSearch(ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, searchMetadata{}, err
 	}
+	defer resp.Body.Close()
 
-	if len(response.Results) > 0 {
-		publishEvent := response.Results[0]
-		return &publishEvent, nil
+	decoder := json.NewDecoder(bufio.NewReader(resp.Body))
+	response := searchResponse{}
+	if err = decoder.Decode(&response); err != nil {
+		return nil, searchMetadata{}, err
 	}
+	metadata.EventCount = len(response.Results)
+	return response.Results, metadata, nil
+}
 
-	return nil, ErrNoResults
+// contentTypeClause returns the search clause matching any of the content types
+func contentTypeClause(contentTypes []string) string {
+	if len(contentTypes) == 1 {
+		return fmt.Sprintf(`content_type="%s"`, contentTypes[0])
+	}
+	return strings.TrimPrefix(inClause("content_type", contentTypes), " ")
 }
 
-func (service *splunkService) doQuery(query string) (*http.Response, error) {
+// inClause returns a field IN (...) search clause, or an empty string if there are no values
+func inClause(field string, values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" %s IN (%s)", field, quotedList(values))
+}
+
+func quotedList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = fmt.Sprintf(`"%s"`, value)
+	}
+	return strings.Join(quoted, ",")
+}
+
+func (service *splunkService) doQuery(ctx context.Context, query string) (*http.Response, error) {
+	resp, _, err := service.runSearch(ctx, query)
+	return resp, err
+}
+
+// runSearch runs the query as a blocking Splunk job and returns the results response, along with the job details.
+// Cancelling the context cancels the job, unless it is cancelled while the job is created, before its sid is known.
+func (service *splunkService) runSearch(ctx context.Context, query string) (*http.Response, searchMetadata, error) {
 	var resp *http.Response
+	var metadata searchMetadata
 	// call blocks until job finishes
 	query = query + "&exec_mode=blocking&output_mode=json"
-	httpCall := func() error {
-		sid, err := service.newJob(query)
+	// a sid is only valid on the head that created it, so each head runs the whole job flow
+	searchOnHead := func(head *searchHead) (err error) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		sid, err := service.newJob(ctx, head, query)
 		if err != nil {
-			return err
+			return contextError(ctx, err)
 		}
+		defer func() {
+			if err != nil && ctx.Err() != nil {
+				service.cancelJob(head, sid)
+				err = ctx.Err()
+			}
+		}()
 
-		job, err := service.getJobDetails(sid)
+		job, err := service.getJobDetails(ctx, head, sid)
 		if err != nil {
 			return err
 		}
-		err = validateJob(sid, job)
+		countWarnings(job)
+		err = validateJob(sid, job, service.Config.warningPolicy)
 		if err != nil {
 			return err
 		}
+		metadata = newSearchMetadata(sid, job, service.Config.warningPolicy)
 
 		// fetch results and disable the default result count limit (0 = disabled)
-		serviceURL := fmt.Sprintf("%v%v/%v/results?count=0&output_mode=json", service.Config.restURL, splunkEndpoint, sid)
-		req, err := http.NewRequest("GET", serviceURL, nil)
+		serviceURL := fmt.Sprintf("%v%v/%v/results?count=0&output_mode=json", head.url, splunkEndpoint, sid)
+		req, err := http.NewRequestWithContext(ctx, "GET", serviceURL, nil)
+		if err != nil {
+			return err
+		}
 		req.SetBasicAuth(service.Config.user, service.Config.password)
 
 		resp, err = service.HTTPClient.Do(req)
@@ -243,33 +718,96 @@ func (service *splunkService) doQuery(query string) (*http.Response, error) {
 			if resp != nil && resp.Body != nil {
 				_ = resp.Body.Close()
 			}
-			return err
+			return newTransportError(err)
 		}
 
 		if resp.StatusCode != http.StatusOK {
-			if resp != nil && resp.Body != nil {
-				_ = resp.Body.Close()
-			}
-			return errors.New(resp.Status)
+			err = newStatusError(resp)
+			_ = resp.Body.Close()
+			return err
 		}
 		return nil
 	}
 
-	err := retry.Do(httpCall, retry.RetryChecker(func(e error) bool { return e != nil }), retry.MaxTries(2), retry.Sleep(2*time.Second))
+	if err := service.breaker.allow(); err != nil {
+		return nil, searchMetadata{}, err
+	}
+	err := service.Config.retryPolicy.do(func() error {
+		return service.heads.failover(searchOnHead)
+	})
+	if ctx.Err() != nil {
+		// a cancelled search tells nothing about the availability of Splunk
+		service.breaker.release()
+		return nil, searchMetadata{}, ctx.Err()
+	}
+	service.breaker.record(err)
 
 	service.updateHealth(err)
 	if err != nil {
-		return nil, err
+		return nil, searchMetadata{}, err
+	}
+
+	return resp, metadata, nil
+}
+
+// cancelJob deletes the job of a cancelled search, so that it does not keep running; it expires anyway if this fails
+func (service *splunkService) cancelJob(head *searchHead, sid string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cancelJobTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "DELETE", fmt.Sprintf("%v%v/%v", head.url, splunkEndpoint, sid), nil)
+	if err != nil {
+		return
+	}
+	req.SetBasicAuth(service.Config.user, service.Config.password)
+	if resp, err := service.HTTPClient.Do(req); err == nil {
+		resp.Body.Close()
 	}
+}
 
-	return resp, nil
+// contextError returns the error of the cancelled context rather than the failure of the request it cancelled
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func newSearchMetadata(sid string, job *jobDetails, warningPolicy string) searchMetadata {
+	metadata := searchMetadata{Sids: []string{sid}}
+	if len(job.Entry) > 0 {
+		content := job.Entry[0].Content
+		metadata.EarliestTime = content.EarliestTime
+		metadata.LatestTime = content.LatestTime
+		metadata.Duration = content.RunDuration
+		metadata.Truncated = content.IsFinalized
+		for _, msg := range content.Messages {
+			if msg.Type == "WARN" && warningPolicy == warningPolicyReport {
+				metadata.Warnings = append(metadata.Warnings, msg.Text)
+			}
+		}
+	}
+	return metadata
+}
+
+// countWarnings records the number of WARN messages of the job, whatever the policy
+func countWarnings(job *jobDetails) {
+	if len(job.Entry) == 0 {
+		return
+	}
+	for _, msg := range job.Entry[0].Content.Messages {
+		if msg.Type == "WARN" {
+			metrics.GetOrRegisterCounter(warningsMetric, metrics.DefaultRegistry).Inc(1)
+		}
+	}
 }
 
 func (service *splunkService) updateHealth(err error) {
-	switch err.(type) {
-	case *JobFailure:
+	var splunkErr *SplunkError
+	if errors.As(err, &splunkErr) && !splunkErr.affectsHealth() {
 		err = nil
 	}
+	service.Lock()
+	defer service.Unlock()
 	if err != nil {
 		service.lastHealth = healthStatus{message: "Splunk error", err: err, time: time.Now()}
 	} else {
@@ -277,41 +815,49 @@ func (service *splunkService) updateHealth(err error) {
 	}
 }
 
-func validateJob(sid string, job *jobDetails) error {
+func (service *splunkService) health() healthStatus {
+	service.RLock()
+	defer service.RUnlock()
+	return service.lastHealth
+}
+
+func validateJob(sid string, job *jobDetails, warningPolicy string) error {
 	if len(job.Entry) > 0 {
-		// mainly looking for warnings caused by index failures (type=WARN), but we treat any message as a bad omen for now
-		// note that index failures still result in status=DONE
+		// warnings are mostly caused by index failures, which still result in status=DONE but may leave the results incomplete
 		if len(job.Entry[0].Content.Messages) > 0 {
 			for _, msg := range job.Entry[0].Content.Messages {
-				if msg.Type == "ERROR" {
+				if msg.Type == "ERROR" || (msg.Type == "WARN" && warningPolicy == warningPolicyFail) {
 					message := fmt.Sprintf("Splunk job %v has status %v with messages: %v", sid, job.Entry[0].Content.DispatchState, job.Entry[0].Content.Messages)
-					return NewJobFailure(message)
+					return newJobError(message, &msg)
 				}
 			}
 		}
 
 		if job.Entry[0].Content.DispatchState == "FAILED" {
 			message := fmt.Sprintf("Splunk job with sid %v is %v", sid, job.Entry[0].Content.DispatchState)
-			return NewJobFailure(message)
+			return newJobError(message, nil)
 		}
 	}
 	return nil
 }
 
-func (service *splunkService) newJob(query string) (string, error) {
+func (service *splunkService) newJob(ctx context.Context, head *searchHead, query string) (string, error) {
 	var resp *http.Response
-	serviceURL := fmt.Sprintf("%v%v", service.Config.restURL, splunkEndpoint)
-	req, err := http.NewRequest("POST", serviceURL, strings.NewReader(query))
+	serviceURL := fmt.Sprintf("%v%v", head.url, splunkEndpoint)
+	req, err := http.NewRequestWithContext(ctx, "POST", serviceURL, strings.NewReader(query))
+	if err != nil {
+		return "", err
+	}
 	req.SetBasicAuth(service.Config.user, service.Config.password)
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	resp, err = service.HTTPClient.Do(req)
 	if err != nil {
-		return "", err
+		return "", newTransportError(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return "", errors.New(resp.Status)
+		return "", newStatusError(resp)
 	}
 
 	decoder := json.NewDecoder(bufio.NewReader(resp.Body))
@@ -323,21 +869,24 @@ func (service *splunkService) newJob(query string) (string, error) {
 	return sidResp.Sid, nil
 }
 
-func (service *splunkService) getJobDetails(sid string) (*jobDetails, error) {
+func (service *splunkService) getJobDetails(ctx context.Context, head *searchHead, sid string) (*jobDetails, error) {
 
 	var resp *http.Response
 
-	serviceURL := fmt.Sprintf("%v%v/%v?output_mode=json", service.Config.restURL, splunkEndpoint, sid)
-	req, err := http.NewRequest("GET", serviceURL, nil)
+	serviceURL := fmt.Sprintf("%v%v/%v?output_mode=json", head.url, splunkEndpoint, sid)
+	req, err := http.NewRequestWithContext(ctx, "GET", serviceURL, nil)
+	if err != nil {
+		return nil, err
+	}
 	req.SetBasicAuth(service.Config.user, service.Config.password)
 	resp, err = service.HTTPClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, newTransportError(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(resp.Status)
+		return nil, newStatusError(resp)
 	}
 
 	decoder := json.NewDecoder(bufio.NewReader(resp.Body))
@@ -350,24 +899,52 @@ func (service *splunkService) getJobDetails(sid string) (*jobDetails, error) {
 }
 
 func (service *splunkService) IsHealthy() healthStatus {
-	if time.Now().Before(service.lastHealth.time.Add(healthCachePeriod)) {
-		return service.lastHealth
+	if err := service.breaker.status(); err != nil {
+		return healthStatus{message: "Splunk circuit breaker is open", err: err, time: time.Now()}
+	}
+	// a half-open breaker is closed by the health search when there is no other search to try
+	if lastHealth := service.health(); !service.breaker.isHalfOpen() && time.Now().Before(lastHealth.time.Add(healthCachePeriod)) {
+		return lastHealth
 	}
 	v := url.Values{}
 	v.Set("search", healthcheckQuery)
 	v.Set("earliest_time", "-10s")
 
-	resp, _ := service.doQuery(v.Encode())
+	resp, _ := service.doQuery(context.Background(), v.Encode())
 	if resp != nil && resp.Body != nil {
 		_ = resp.Body.Close()
 	}
-	return service.lastHealth
+	return service.health()
+}
+
+// HealthChecks reports the health of each search head
+func (service *splunkService) HealthChecks() []health.Check {
+	return service.heads.healthChecks()
 }
 
-func newSplunkService(config splunkAccessConfig) SplunkServiceI {
+func isValidWarningPolicy(policy string) bool {
+	return policy == warningPolicyIgnore || policy == warningPolicyReport || policy == warningPolicyFail
+}
+
+func newSplunkService(config splunkAccessConfig) EventReader {
+	if config.warningPolicy == "" {
+		config.warningPolicy = warningPolicyReport
+	}
+	if config.retryPolicy.maxAttempts == 0 {
+		config.retryPolicy = defaultRetryPolicy
+	}
+	if config.headSelection == "" {
+		config.headSelection = headSelectionPrimary
+	}
+	if config.breaker == (breakerConfig{}) {
+		config.breaker = defaultBreakerConfig
+	}
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
 	client := &http.Client{Transport: tr}
-	return &splunkService{HTTPClient: client, Config: config}
+	if config.recordDir != "" {
+		client.Transport = newRecordingTransport(config.recordDir, tr)
+	}
+	return &splunkService{HTTPClient: client, Config: config, breaker: newCircuitBreaker(config.breaker), heads: newSearchHeads(config.restURLs, config.headSelection)}
 }
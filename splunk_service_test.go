@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/Financial-Times/splunk-event-reader/splunktest"
+	"github.com/rcrowley/go-metrics"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -55,7 +63,7 @@ func TestSplunkService_GetTransactions(t *testing.T) {
 
 		defer splunkServer.Close()
 
-		splunkReader := newSplunkService(splunkAccessConfig{restURL: splunkServer.URL, environment: "test"})
+		splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
 		tx, err := splunkReader.GetTransactions(test.query)
 		if test.hasError {
 			assert.Error(t, err)
@@ -65,6 +73,440 @@ func TestSplunkService_GetTransactions(t *testing.T) {
 	}
 }
 
+func TestSplunkService_GetTransactionsByContentType(t *testing.T) {
+	expectedJSON, _ := ioutil.ReadFile("testdata/splunk_transaction_output.json")
+	expectedTx := []transactionEvent{}
+	json.Unmarshal(expectedJSON, &expectedTx)
+
+	splunkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.RequestURI, "/results") && !strings.Contains(r.RequestURI, "_sid") {
+			r.ParseForm()
+			assert.Contains(t, r.Form.Get("search"), `(content_type IN ("annotations","content") OR content_type="")`)
+		}
+		writeResponse(w, r, func() {
+			w.WriteHeader(http.StatusOK)
+			inputJSON, err := ioutil.ReadFile("testdata/splunk_response_sample.json")
+			assert.NoError(t, err, "Unexpected error")
+			w.Write(inputJSON)
+		})
+	}))
+
+	defer splunkServer.Close()
+
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
+	tx, _, err := splunkReader.GetTransactionsByContentType(monitoringQuery{}, []string{"annotations", "content"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]transactionEvent{"annotations": expectedTx, "content": {}}, tx)
+}
+
+func TestSplunkService_GetLastEventByContentType(t *testing.T) {
+	splunkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.RequestURI, "/results") && !strings.Contains(r.RequestURI, "_sid") {
+			r.ParseForm()
+			assert.Contains(t, r.Form.Get("search"), `content_type IN ("annotations","content") event IN ("PublishEnd")`)
+			assert.True(t, strings.HasSuffix(r.Form.Get("search"), "| dedup content_type"))
+		}
+		writeResponse(w, r, func() {
+			w.WriteHeader(http.StatusOK)
+			inputJSON, err := ioutil.ReadFile("testdata/splunk_publish_end_sample.json")
+			assert.NoError(t, err, "Unexpected error")
+			w.Write(inputJSON)
+		})
+	}))
+
+	defer splunkServer.Close()
+
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
+	events, _, err := splunkReader.GetLastEventByContentType(monitoringQuery{}, []string{"annotations", "content"})
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "tid_evjm9gls5a", events["annotations"].TransactionID)
+}
+
+func TestSplunkService_GetTransactionsInChunks(t *testing.T) {
+	expectedJSON, _ := ioutil.ReadFile("testdata/splunk_transaction_output.json")
+	expectedTx := []transactionEvent{}
+	json.Unmarshal(expectedJSON, &expectedTx)
+
+	var uuids []string
+	for i := 0; i < 250; i++ {
+		uuids = append(uuids, fmt.Sprintf("27355ee6-e280-4fb8-b825-%012d", i))
+	}
+
+	lock := sync.Mutex{}
+	searchedUUIDs := 0
+	searches := 0
+	splunkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.RequestURI, "/results") && !strings.Contains(r.RequestURI, "_sid") {
+			r.ParseForm()
+			count := strings.Count(r.Form.Get("search"), "27355ee6-e280-4fb8-b825-")
+			assert.True(t, count <= maxUUIDsPerSearch)
+			lock.Lock()
+			searches++
+			searchedUUIDs += count
+			lock.Unlock()
+		}
+		writeResponse(w, r, func() {
+			w.WriteHeader(http.StatusOK)
+			inputJSON, err := ioutil.ReadFile("testdata/splunk_response_sample.json")
+			assert.NoError(t, err, "Unexpected error")
+			w.Write(inputJSON)
+		})
+	}))
+
+	defer splunkServer.Close()
+
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
+	tx, err := splunkReader.GetTransactions(monitoringQuery{UUIDs: uuids})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, searches)
+	assert.Equal(t, 250, searchedUUIDs)
+	// every chunk returns the same transaction in this test; merged events are sorted latest first, as Splunk returns them
+	sort.SliceStable(expectedTx[0].Events, func(i, j int) bool {
+		return expectedTx[0].Events[i].Time > expectedTx[0].Events[j].Time
+	})
+	assert.Equal(t, expectedTx, tx)
+}
+
+func TestSplunkService_GetTransactionsInChunksAcrossBoundary(t *testing.T) {
+	var uuids []string
+	for i := 0; i < 250; i++ {
+		uuids = append(uuids, fmt.Sprintf("27355ee6-e280-4fb8-b825-%012d", i))
+	}
+	event := func(eventTime string, event string, transactionID string, uuid string) splunktest.Event {
+		return splunktest.Event{"@time": eventTime, "environment": "xp", "monitoring_event": "true", "content_type": "Annotations", "event": event, "transaction_id": transactionID, "uuid": uuid}
+	}
+	// tid_closed is started in the first chunk and ended in the second one, tid_open spans the first and the third chunks
+	splunkServer := splunktest.NewServer(
+		event("2017-09-19T14:01:00Z", "PublishStart", "tid_closed", uuids[0]),
+		event("2017-09-19T14:02:00Z", "PublishStart", "tid_open", uuids[1]),
+		event("2017-09-19T14:03:00Z", "PublishEnd", "tid_closed", uuids[150]),
+		event("2017-09-19T14:04:00Z", "Map", "tid_open", uuids[200]),
+	)
+	defer splunkServer.Close()
+	splunkServer.Now = func() time.Time {
+		return time.Date(2017, 9, 19, 14, 5, 0, 0, time.UTC)
+	}
+
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "xp"})
+	tx, err := splunkReader.GetTransactions(monitoringQuery{ContentType: "annotations", UUIDs: uuids})
+	assert.NoError(t, err)
+	assert.Len(t, splunkServer.Jobs(), 3)
+	if assert.Len(t, tx, 1) {
+		assert.Equal(t, "tid_open", tx[0].TransactionID)
+		assert.Equal(t, "0", tx[0].ClosedTxn)
+		assert.Equal(t, 2, tx[0].EventCount)
+		assert.Equal(t, []string{"Map", "PublishStart"}, []string{tx[0].Events[0].Event, tx[0].Events[1].Event})
+		assert.Equal(t, "2017-09-19T14:02:00Z", tx[0].StartTime)
+	}
+
+	tx, err = splunkReader.GetTransactions(monitoringQuery{ContentType: "annotations", UUIDs: uuids, IncludeClosed: true})
+	assert.NoError(t, err)
+	closed := map[string]transactionEvent{}
+	for _, transaction := range tx {
+		closed[transaction.TransactionID] = transaction
+	}
+	assert.Len(t, closed, 2)
+	assert.Equal(t, "1", closed["tid_closed"].ClosedTxn, "the PublishEnd of the second chunk closes the transaction")
+	assert.Equal(t, 2, closed["tid_closed"].EventCount)
+	assert.Equal(t, "2017-09-19T14:01:00Z", closed["tid_closed"].StartTime)
+	assert.Equal(t, "0", closed["tid_open"].ClosedTxn)
+}
+
+func TestSplunkService_GetTransactionsWithMetadata(t *testing.T) {
+	splunkServer := newWarningSplunkServer(t)
+
+	defer splunkServer.Close()
+
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
+	_, metadata, err := splunkReader.GetTransactionsWithMetadata(monitoringQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, searchMetadata{
+		Sids:         []string{"test_sid"},
+		EarliestTime: "2017-09-19T13:50:00.000+00:00",
+		LatestTime:   "2017-09-19T14:00:00.000+00:00",
+		Duration:     1.25,
+		EventCount:   6,
+		Warnings:     []string{"Search results might be incomplete: the search process on peer idx-1 ended prematurely."},
+	}, metadata)
+}
+
+func TestSplunkService_WarningPolicy(t *testing.T) {
+	tests := []struct {
+		policy           string
+		hasError         bool
+		expectedWarnings int
+	}{
+		{warningPolicyIgnore, false, 0},
+		{warningPolicyReport, false, 1},
+		{warningPolicyFail, true, 0},
+	}
+
+	for _, test := range tests {
+		splunkServer := newWarningSplunkServer(t)
+		warnings := metrics.GetOrRegisterCounter(warningsMetric, metrics.DefaultRegistry)
+		warnings.Clear()
+
+		splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test", warningPolicy: test.policy})
+		_, metadata, err := splunkReader.GetTransactionsWithMetadata(monitoringQuery{})
+		if test.hasError {
+			// peer failures are not retried
+			assert.True(t, errors.Is(err, ErrSplunkPeer), test.policy)
+		} else {
+			assert.NoError(t, err, test.policy)
+		}
+		assert.Equal(t, int64(1), warnings.Count(), test.policy)
+		assert.Len(t, metadata.Warnings, test.expectedWarnings, test.policy)
+		splunkServer.Close()
+	}
+}
+
+func newWarningSplunkServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.RequestURI, "/results"):
+			w.WriteHeader(http.StatusOK)
+			inputJSON, err := ioutil.ReadFile("testdata/splunk_response_sample.json")
+			assert.NoError(t, err, "Unexpected error")
+			w.Write(inputJSON)
+		case strings.Contains(r.RequestURI, "_sid"):
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"entry":[{"content":{
+				"dispatchState":"DONE",
+				"isDone":true,
+				"isFinalized":false,
+				"earliestTime":"2017-09-19T13:50:00.000+00:00",
+				"latestTime":"2017-09-19T14:00:00.000+00:00",
+				"runDuration":1.25,
+				"messages":[{"type":"WARN","text":"Search results might be incomplete: the search process on peer idx-1 ended prematurely."}]
+			}}]}`))
+		default:
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"sid":"test_sid"}`))
+		}
+	}))
+}
+
+func TestSplunkService_GetTransactionsWithFilters(t *testing.T) {
+	tests := []struct {
+		query             monitoringQuery
+		expectedPredicate string
+		expectedCount     int
+	}{
+		{monitoringQuery{Services: []string{"nativerw"}, Levels: []string{"info"}}, `eval filter_match=if(in(level, "info") AND in(service_name, "nativerw"), 1, 0)`, 1},
+		{monitoringQuery{Services: []string{"nativerw"}, Events: []string{"Ingest"}}, `eval filter_match=if(in(event, "Ingest") AND in(service_name, "nativerw"), 1, 0)`, 0},
+		{monitoringQuery{Levels: []string{"error"}, IsValid: "false"}, `eval filter_match=if(in(level, "error") AND isValid="false", 1, 0)`, 0},
+	}
+
+	for _, test := range tests {
+		splunkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.Contains(r.RequestURI, "/results") && !strings.Contains(r.RequestURI, "_sid") {
+				r.ParseForm()
+				assert.Contains(t, r.Form.Get("search"), test.expectedPredicate)
+			}
+			writeResponse(w, r, func() {
+				w.WriteHeader(http.StatusOK)
+				inputJSON, err := ioutil.ReadFile("testdata/splunk_response_sample.json")
+				assert.NoError(t, err, "Unexpected error")
+				w.Write(inputJSON)
+			})
+		}))
+
+		splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
+		tx, err := splunkReader.GetTransactions(test.query)
+		assert.NoError(t, err)
+		// the test server ignores the search, so the filters applied by the service are checked here
+		assert.Len(t, tx, test.expectedCount)
+		splunkServer.Close()
+	}
+}
+
+func TestSplunkService_GetTransactionsWithCursor(t *testing.T) {
+	cursor := &eventCursor{IndexTime: 1505829588, Time: "2017-09-19T13:59:48.248989749Z"}
+
+	splunkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.RequestURI, "/results") && !strings.Contains(r.RequestURI, "_sid") {
+			r.ParseForm()
+			assert.Contains(t, r.Form.Get("search"), "where latest_indextime>=1505829588")
+			assert.Equal(t, "1505828988", r.Form.Get("earliest_time"))
+		}
+		writeResponse(w, r, func() {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"results":[
+				{"@time":"2017-09-19T13:59:49Z","content_type":"Annotations","event":"PublishEnd","indextime":"1505829590","transaction_id":"tid_1"},
+				{"@time":"2017-09-19T13:59:48.248989749Z","content_type":"Annotations","event":"PublishStart","indextime":"1505829588","transaction_id":"tid_2"},
+				{"@time":"2017-09-19T13:59:48.2Z","content_type":"Annotations","event":"PublishStart","indextime":"1505829588","transaction_id":"tid_3"},
+				{"@time":"2017-09-19T13:59:48.3Z","content_type":"Annotations","event":"PublishStart","indextime":"1505829588","transaction_id":"tid_4"}
+			]}`))
+		})
+	}))
+
+	defer splunkServer.Close()
+
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
+	tx, err := splunkReader.GetTransactions(monitoringQuery{ContentType: "annotations", Cursor: cursor})
+	assert.NoError(t, err)
+	// closed transactions are returned in cursor mode, so that consumers can drop them; the event time breaks the tie
+	// of the events indexed in the second of the cursor
+	closed := map[string]string{}
+	for _, transaction := range tx {
+		closed[transaction.TransactionID] = transaction.ClosedTxn
+	}
+	assert.Equal(t, map[string]string{"tid_1": "1", "tid_4": "0"}, closed)
+}
+
+func TestSplunkService_PollWithCursor(t *testing.T) {
+	event := func(eventTime string, event string, transactionID string, indexTime string) splunktest.Event {
+		return splunktest.Event{"@time": eventTime, "environment": "xp", "monitoring_event": "true", "content_type": "Annotations", "event": event, "transaction_id": transactionID, splunktest.IndexTimeField: indexTime}
+	}
+	splunkServer := splunktest.NewServer(
+		event("2017-09-19T14:01:00Z", "PublishStart", "tid_1", "1505829660"),
+		event("2017-09-19T14:01:00.5Z", "Map", "tid_1", "1505829661"),
+	)
+	defer splunkServer.Close()
+	splunkServer.Now = func() time.Time {
+		return time.Date(2017, 9, 19, 14, 5, 0, 0, time.UTC)
+	}
+
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "xp"})
+	tx, err := splunkReader.GetTransactions(monitoringQuery{ContentType: "annotations"})
+	assert.NoError(t, err)
+	assert.Len(t, tx, 1)
+	cursor := nextCursor(nil, tx)
+	assert.Equal(t, &eventCursor{IndexTime: 1505829661, Time: "2017-09-19T14:01:00.5Z"}, cursor)
+
+	tx, err = splunkReader.GetTransactions(monitoringQuery{ContentType: "annotations", Cursor: cursor})
+	assert.NoError(t, err)
+	assert.Empty(t, tx, "nothing was indexed since the first poll")
+	assert.Equal(t, cursor, nextCursor(cursor, tx))
+
+	// an event indexed in the second of the cursor, but logged after it
+	splunkServer.AddEvents(event("2017-09-19T14:01:00.75Z", "PublishEnd", "tid_1", "1505829661"))
+	tx, err = splunkReader.GetTransactions(monitoringQuery{ContentType: "annotations", Cursor: cursor})
+	assert.NoError(t, err)
+	if assert.Len(t, tx, 1) {
+		assert.Equal(t, "1", tx[0].ClosedTxn)
+		cursor = nextCursor(cursor, tx)
+	}
+
+	tx, err = splunkReader.GetTransactions(monitoringQuery{ContentType: "annotations", Cursor: cursor})
+	assert.NoError(t, err)
+	assert.Empty(t, tx)
+}
+
+func TestEventCursor(t *testing.T) {
+	transactions := []transactionEvent{{Events: []publishEvent{
+		{Time: "2017-09-19T13:59:48.248989749Z", IndexTime: "1505829590"},
+		{Time: "2017-09-19T13:59:53.443287108Z", IndexTime: "1505829595"},
+		{Time: "INVALID", IndexTime: "1505829599"},
+		{Time: "2017-09-19T13:59:50.000000000Z"},
+	}}}
+
+	cursor := nextCursor(nil, transactions)
+	assert.Equal(t, &eventCursor{IndexTime: 1505829595, Time: "2017-09-19T13:59:53.443287108Z"}, cursor)
+
+	decoded, err := decodeCursor(cursor.encode())
+	assert.NoError(t, err)
+	assert.Equal(t, cursor, decoded)
+
+	assert.Equal(t, cursor, nextCursor(cursor, nil))
+	assert.Nil(t, nextCursor(nil, nil))
+
+	_, err = decodeCursor("INVALID")
+	assert.Error(t, err)
+}
+
+func TestSplunkService_StreamEventsRealtime(t *testing.T) {
+	splunkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/services/search/jobs/export", r.URL.Path)
+		r.ParseForm()
+		assert.Equal(t, "realtime", r.Form.Get("search_mode"))
+		assert.Equal(t, "rt-1m", r.Form.Get("earliest_time"))
+		assert.Contains(t, r.Form.Get("search"), `service_name IN ("nativerw")`)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"preview":false,"offset":0,"result":{"event":"NativeSave","service_name":"nativerw","transaction_id":"tid_1"}}
+{"preview":false,"offset":1,"result":{"event":"NativeSave","service_name":"nativerw","transaction_id":"tid_2"}}
+`))
+	}))
+
+	defer splunkServer.Close()
+
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
+	var events []publishEvent
+	err := splunkReader.StreamEvents(context.Background(), monitoringQuery{ContentType: "annotations", Services: []string{"nativerw"}}, func(event publishEvent) error {
+		events = append(events, event)
+		return nil
+	}, func(warning string) {})
+	// the test server closes the stream, which a real-time search never does
+	assert.Error(t, err)
+	assert.Len(t, events, 2)
+}
+
+func TestSplunkService_StreamEventsPolling(t *testing.T) {
+	pollCount := 0
+	splunkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.RequestURI, "/results") && !strings.Contains(r.RequestURI, "_sid") {
+			r.ParseForm()
+			if pollCount > 0 {
+				assert.Contains(t, r.Form.Get("search"), "where indextime>=1505829595")
+				// the window follows the index time, whatever the poll interval
+				assert.Equal(t, "1505829295", r.Form.Get("earliest_time"))
+			}
+		}
+		writeResponse(w, r, func() {
+			pollCount++
+			w.WriteHeader(http.StatusOK)
+			if pollCount == 1 {
+				w.Write([]byte(`{"results":[{"event":"NativeSave","indextime":"1505829595","transaction_id":"tid_2"},{"event":"Ingest","indextime":"1505829590","transaction_id":"tid_1"}]}`))
+			} else {
+				w.Write([]byte(`{"results":[{"event":"PublishEnd","indextime":"1505829597","transaction_id":"tid_1"},{"event":"NativeSave","indextime":"1505829595","transaction_id":"tid_2"}]}`))
+			}
+		})
+	}))
+
+	defer splunkServer.Close()
+
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test", streamPollInterval: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	var events []string
+	err := splunkReader.StreamEvents(ctx, monitoringQuery{ContentType: "annotations"}, func(event publishEvent) error {
+		events = append(events, event.Event)
+		if len(events) == 3 {
+			cancel()
+		}
+		return nil
+	}, func(warning string) {})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Ingest", "NativeSave", "PublishEnd"}, events)
+}
+
+func TestSplunkService_CancelSearch(t *testing.T) {
+	deleted := make(chan string, 1)
+	splunkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "DELETE":
+			deleted <- r.URL.Path
+			w.WriteHeader(http.StatusOK)
+		case strings.Contains(r.RequestURI, "_sid"):
+			// the job runs until the search is cancelled
+			<-r.Context().Done()
+		default:
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"sid":"test_sid"}`))
+		}
+	}))
+
+	defer splunkServer.Close()
+
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"}).(*splunkService)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err := splunkReader.searchEvents(ctx, "search=search+index%3Dtest")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, "/services/search/jobs/test_sid", <-deleted)
+	assert.NoError(t, splunkReader.health().err, "a cancelled search does not affect the health")
+}
+
 func TestSplunkService_GetLastEvent(t *testing.T) {
 	var expectedEvent = &publishEvent{
 		Time:          "2017-09-19T15:11:31.795334198Z",
@@ -88,8 +530,8 @@ func TestSplunkService_GetLastEvent(t *testing.T) {
 
 	defer splunkServer.Close()
 
-	splunkReader := newSplunkService(splunkAccessConfig{restURL: splunkServer.URL, environment: "test"})
-	event, err := splunkReader.GetLastEvent(monitoringQuery{})
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
+	event, _, err := splunkReader.GetLastEvent(monitoringQuery{})
 	if err != nil {
 		t.Fail()
 	}
@@ -97,6 +539,87 @@ func TestSplunkService_GetLastEvent(t *testing.T) {
 	assert.Equal(t, expectedEvent, event)
 }
 
+func TestSplunkService_GetLastEvents(t *testing.T) {
+	splunkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.RequestURI, "/results") && !strings.Contains(r.RequestURI, "_sid") {
+			r.ParseForm()
+			assert.Contains(t, r.Form.Get("search"), `content_type="annotations" event IN ("Ingest") service_name IN ("native-ingester-metadata") level IN ("info") |`)
+			assert.True(t, strings.HasSuffix(r.Form.Get("search"), "| head 5"))
+		}
+		writeResponse(w, r, func() {
+			w.WriteHeader(http.StatusOK)
+			inputJSON, err := ioutil.ReadFile("testdata/splunk_publish_end_sample.json")
+			assert.NoError(t, err, "Unexpected error")
+			w.Write(inputJSON)
+		})
+	}))
+
+	defer splunkServer.Close()
+
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
+	events, _, err := splunkReader.GetLastEvents(monitoringQuery{ContentType: "annotations", Events: []string{"Ingest"}, Services: []string{"native-ingester-metadata"}, Levels: []string{"info"}}, 5)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+}
+
+func TestSplunkService_GetErrors(t *testing.T) {
+	splunkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.RequestURI, "/results") && !strings.Contains(r.RequestURI, "_sid") {
+			r.ParseForm()
+			// the error events without content type are only counted for the transactions of the content type
+			assert.Contains(t, r.Form.Get("search"), `(content_type="annotations" OR content_type="")`)
+			assert.Contains(t, r.Form.Get("search"), `| eventstats max(content_type_match) as txn_content_type_match by transaction_id | search txn_content_type_match=1 (level="error" OR isValid="false") | stats count, values(transaction_id) as transaction_ids, max(@time) as latest_time by service_name, event`)
+			assert.Equal(t, "-1h", r.Form.Get("earliest_time"))
+		}
+		writeResponse(w, r, func() {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"results":[
+				{"service_name":"annotations-rw-neo4j","event":"SaveNeo4j","count":"1","transaction_ids":"tid_2","latest_time":"2017-09-19T14:00:02Z"},
+				{"service_name":"annotations-mapper","event":"Map","count":"7","transaction_ids":["tid_1","tid_3","tid_4","tid_5","tid_6","tid_7"],"latest_time":"2017-09-19T14:00:03Z"}
+			]}`))
+		})
+	}))
+
+	defer splunkServer.Close()
+
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
+	reports, _, err := splunkReader.GetErrors(monitoringQuery{ContentType: "annotations", EarliestTime: "-1h"})
+	assert.NoError(t, err)
+	assert.Equal(t, []errorReport{
+		{ServiceName: "annotations-mapper", Event: "Map", Count: 7, SampleTransactionIDs: []string{"tid_1", "tid_3", "tid_4", "tid_5", "tid_6"}, LatestTime: "2017-09-19T14:00:03Z"},
+		{ServiceName: "annotations-rw-neo4j", Event: "SaveNeo4j", Count: 1, SampleTransactionIDs: []string{"tid_2"}, LatestTime: "2017-09-19T14:00:02Z"},
+	}, reports)
+}
+
+func TestSplunkService_GetThroughput(t *testing.T) {
+	splunkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.RequestURI, "/results") && !strings.Contains(r.RequestURI, "_sid") {
+			r.ParseForm()
+			assert.Contains(t, r.Form.Get("search"), "| timechart span=15m count as started")
+			assert.Equal(t, "-24h", r.Form.Get("earliest_time"))
+		}
+		writeResponse(w, r, func() {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"results":[
+				{"time":"1505829600","started":"3","completed":"2","open":"1"},
+				{"time":"1505830500","started":"0"}
+			]}`))
+		})
+	}))
+
+	defer splunkServer.Close()
+
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
+	series, metadata, err := splunkReader.GetThroughput(monitoringQuery{ContentType: "annotations", EarliestTime: "-24h"}, "15m")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test_sid"}, metadata.Sids)
+	assert.Equal(t, []timeSeries{
+		{Target: "started", Datapoints: [][2]float64{{3, 1505829600000}, {0, 1505830500000}}},
+		{Target: "completed", Datapoints: [][2]float64{{2, 1505829600000}, {0, 1505830500000}}},
+		{Target: "open", Datapoints: [][2]float64{{1, 1505829600000}, {0, 1505830500000}}},
+	}, series)
+}
+
 func TestSplunkService_GetLastEventError(t *testing.T) {
 	splunkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !strings.Contains(r.RequestURI, "/results") && !strings.Contains(r.RequestURI, "_sid") {
@@ -110,8 +633,8 @@ func TestSplunkService_GetLastEventError(t *testing.T) {
 
 	defer splunkServer.Close()
 
-	splunkReader := newSplunkService(splunkAccessConfig{restURL: splunkServer.URL, environment: "test"})
-	_, err := splunkReader.GetLastEvent(monitoringQuery{EarliestTime: "-5m"})
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
+	_, _, err := splunkReader.GetLastEvent(monitoringQuery{EarliestTime: "-5m"})
 	assert.Error(t, err)
 }
 
@@ -145,8 +668,8 @@ func TestSplunkService_GetLastEventRetry(t *testing.T) {
 
 	defer splunkServer.Close()
 
-	splunkReader := newSplunkService(splunkAccessConfig{restURL: splunkServer.URL, environment: "test"})
-	event, err := splunkReader.GetLastEvent(monitoringQuery{})
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
+	event, _, err := splunkReader.GetLastEvent(monitoringQuery{})
 	if err != nil {
 		t.Fail()
 	}
@@ -167,7 +690,7 @@ func TestSplunkService_IsHealthy(t *testing.T) {
 
 	defer splunkServer.Close()
 
-	splunkReader := newSplunkService(splunkAccessConfig{restURL: splunkServer.URL, environment: "test"})
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
 	health := splunkReader.IsHealthy()
 	assert.NoError(t, health.err)
 	assert.Equal(t, "Splunk is ok", health.message)
@@ -183,7 +706,7 @@ func TestSplunkService_IsHealthyFail(t *testing.T) {
 
 	defer splunkServer.Close()
 
-	splunkReader := newSplunkService(splunkAccessConfig{restURL: splunkServer.URL, environment: "test"})
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
 	health := splunkReader.IsHealthy()
 	assert.Error(t, health.err)
 	assert.Equal(t, "Splunk error", health.message)
@@ -208,7 +731,7 @@ func TestSplunkService_IsHealthyCached(t *testing.T) {
 
 	defer splunkServer.Close()
 
-	splunkReader := newSplunkService(splunkAccessConfig{restURL: splunkServer.URL, environment: "test"})
+	splunkReader := newSplunkService(splunkAccessConfig{restURLs: []string{splunkServer.URL}, environment: "test"})
 	splunkReader.GetTransactions(monitoringQuery{})
 	health := splunkReader.IsHealthy()
 	assert.NoError(t, health.err)
@@ -258,3 +781,71 @@ func writeResponse(w http.ResponseWriter, r *http.Request, mainResponse func())
 
 	}
 }
+
+func TestSplunkService_FakeServer(t *testing.T) {
+	sample, err := ioutil.ReadFile("testdata/splunk_response_sample.json")
+	assert.NoError(t, err)
+	response := struct {
+		Results []splunktest.Event `json:"results"`
+	}{}
+	assert.NoError(t, json.Unmarshal(sample, &response))
+	for _, event := range response.Results {
+		// the events of the publishing cluster are found through the environment prefix
+		if event["environment"] == "pub-xp" {
+			event["environment"] = "xp-publish"
+		}
+	}
+	splunkServer := splunktest.NewServer(response.Results...)
+	defer splunkServer.Close()
+	splunkServer.User = "user"
+	splunkServer.Password = "password"
+	splunkServer.Now = func() time.Time {
+		return time.Date(2017, 9, 19, 14, 5, 0, 0, time.UTC)
+	}
+
+	splunkReader := newSplunkService(splunkAccessConfig{user: "user", password: "password", restURLs: []string{splunkServer.URL}, environment: "xp"})
+	transactions, err := splunkReader.GetTransactions(monitoringQuery{ContentType: "annotations"})
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 1)
+	assert.Equal(t, "tid_hamoil09hg", transactions[0].TransactionID)
+	assert.Equal(t, 6, transactions[0].EventCount)
+	assert.NotEmpty(t, transactions[0].Events[0].IndexTime)
+
+	transactions, err = splunkReader.GetTransactions(monitoringQuery{ContentType: "annotations", UUIDs: []string{"0dd0a85f-2926-4371-a0d8-2ae13d738476"}})
+	assert.NoError(t, err)
+	assert.Empty(t, transactions)
+	transactions, err = splunkReader.GetTransactions(monitoringQuery{ContentType: "annotations", EarliestTime: "-1m"})
+	assert.NoError(t, err)
+	assert.Empty(t, transactions)
+
+	// the event filters keep the whole transactions having a matching event
+	transactions, err = splunkReader.GetTransactions(monitoringQuery{ContentType: "annotations", Services: []string{"nativerw"}, Levels: []string{"info"}})
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 1)
+	assert.Equal(t, 6, transactions[0].EventCount)
+	transactions, err = splunkReader.GetTransactions(monitoringQuery{ContentType: "annotations", Levels: []string{"error"}})
+	assert.NoError(t, err)
+	assert.Empty(t, transactions)
+
+	_, _, err = splunkReader.GetLastEvent(monitoringQuery{ContentType: "annotations"})
+	assert.True(t, errors.Is(err, ErrNoResults))
+	splunkServer.AddEvents(splunktest.Event{"@time": "2017-09-19T14:04:00Z", "environment": "xp", "monitoring_event": "true", "content_type": "Annotations", "event": "PublishEnd", "transaction_id": "tid_hamoil09hg"})
+	event, _, err := splunkReader.GetLastEvent(monitoringQuery{ContentType: "annotations"})
+	assert.NoError(t, err)
+	assert.Equal(t, "tid_hamoil09hg", event.TransactionID)
+
+	splunkServer.SetJobMessages(splunktest.Message{Type: "WARN", Text: "Search results might be incomplete"})
+	_, metadata, err := splunkReader.GetTransactionsWithMetadata(monitoringQuery{ContentType: "annotations", IncludeClosed: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Search results might be incomplete"}, metadata.Warnings)
+	_, metadata, err = splunkReader.GetLastEvent(monitoringQuery{ContentType: "annotations"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Search results might be incomplete"}, metadata.Warnings)
+	_, metadata, err = splunkReader.GetLastEvent(monitoringQuery{ContentType: "list"})
+	assert.True(t, errors.Is(err, ErrNoResults))
+	assert.Equal(t, []string{"Search results might be incomplete"}, metadata.Warnings, "the missing results may hold the event")
+
+	jobs := splunkServer.Jobs()
+	assert.Contains(t, jobs[0].Search, `content_type="annotations"`)
+	assert.Equal(t, defaultEarliestTime, jobs[0].EarliestTime)
+}
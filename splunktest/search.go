@@ -0,0 +1,808 @@
+package splunktest
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	relativeTimeRegex = regexp.MustCompile(`^-(\d+)([smhd])$`)
+	evalFieldRegex    = regexp.MustCompile(`^\s*(\w+)\s*=\s*(.+)$`)
+	asFieldRegex      = regexp.MustCompile(`(?i)\bas\s+(\w+)`)
+	fieldNameRegex    = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+	numberRegex       = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+	byClauseRegex     = regexp.MustCompile(`(?is)^(.*?)(?:\s+by\s+(.*))?$`)
+	functionRegex     = regexp.MustCompile(`(\w+)\(`)
+	quotedRegex       = regexp.MustCompile(`"[^"]*"`)
+	aggregationRegex  = regexp.MustCompile(`(?i)^(\w+)(?:\((\w*)\))?(?:\s+as\s+(\w+))?$`)
+)
+
+// search is a parsed SPL search: a pipeline of commands applied to the events of a time window
+type search struct {
+	commands []command
+}
+
+type command interface {
+	apply(events []Event) []Event
+}
+
+// condition filters the events, as a search or where command does
+type condition interface {
+	matches(event Event) bool
+}
+
+type filterCommand struct {
+	condition condition
+}
+
+type headCommand struct {
+	limit int
+}
+
+type dedupCommand struct {
+	fields []string
+}
+
+// evalCommand is the subset of eval assigning fields, literals and if(condition, value, value) expressions,
+// e.g. eval indextime=_indextime
+type evalCommand struct {
+	assignments []assignment
+}
+
+type assignment struct {
+	field string
+	value expression
+}
+
+// expression is the value of an eval assignment
+type expression interface {
+	evaluate(event Event) (interface{}, bool)
+}
+
+type fieldExpression string
+
+type literalExpression string
+
+type ifExpression struct {
+	condition condition
+	then      expression
+	otherwise expression
+}
+
+// eventstatsCommand adds the max, min, sum or count of the events sharing the by fields to each of them, e.g.
+// eventstats max(indextime) as latest_indextime by transaction_id
+type eventstatsCommand struct {
+	aggregations []aggregation
+	by           []string
+}
+
+type aggregation struct {
+	function string
+	field    string
+	as       string
+}
+
+type orCondition []condition
+
+type andCondition []condition
+
+type notCondition struct {
+	condition condition
+}
+
+type comparison struct {
+	field    string
+	operator string
+	values   []string
+}
+
+type freeText string
+
+// alwaysTrue is the condition of a search command without terms
+type alwaysTrue struct{}
+
+// parseSearch parses the search, where, eval, eventstats, head and dedup commands of an SPL query. The stats, timechart
+// and fields commands are ignored, as they only shape the results; filtering on the fields they compute, or on the
+// fields of eval expressions other than field copies, literals and if, fails, as do the other commands.
+func parseSearch(query string) (*search, error) {
+	query = strings.TrimSpace(query)
+	if !strings.HasPrefix(query, "|") && !strings.HasPrefix(strings.ToLower(query), "search ") {
+		query = "search " + query
+	}
+
+	parsed := &search{}
+	// unsupported holds the fields computed by the ignored commands and expressions, with what computes them
+	unsupported := make(map[string]string)
+	for _, segment := range splitPipeline(query) {
+		name, args := splitCommand(segment)
+		switch name {
+		case "search", "where":
+			if function, found := unsupportedFunction(args); name == "where" && found {
+				return nil, fmt.Errorf("unsupported function %s in %q", function, segment)
+			}
+			condition, err := parseCondition(args, unsupported)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s command %q: %v", name, segment, err)
+			}
+			parsed.commands = append(parsed.commands, filterCommand{condition: condition})
+		case "head":
+			limit := 10
+			if args != "" {
+				var err error
+				if limit, err = strconv.Atoi(args); err != nil {
+					return nil, fmt.Errorf("invalid head command %q", segment)
+				}
+			}
+			parsed.commands = append(parsed.commands, headCommand{limit: limit})
+		case "dedup":
+			parsed.commands = append(parsed.commands, dedupCommand{fields: strings.FieldsFunc(args, func(r rune) bool { return r == ',' || r == ' ' })})
+		case "eval":
+			eval := evalCommand{}
+			for _, part := range splitArgs(args, ',') {
+				match := evalFieldRegex.FindStringSubmatch(part)
+				if match == nil {
+					return nil, fmt.Errorf("invalid eval command %q", segment)
+				}
+				value, err := parseExpression(strings.TrimSpace(match[2]), unsupported)
+				if err != nil {
+					return nil, fmt.Errorf("invalid eval command %q: %v", segment, err)
+				}
+				if value == nil {
+					unsupported[match[1]] = fmt.Sprintf("eval expression %s", strings.TrimSpace(match[2]))
+					continue
+				}
+				delete(unsupported, match[1])
+				eval.assignments = append(eval.assignments, assignment{field: match[1], value: value})
+			}
+			parsed.commands = append(parsed.commands, eval)
+		case "eventstats":
+			eventstats, err := parseEventstats(args)
+			if err != nil {
+				return nil, fmt.Errorf("invalid eventstats command %q: %v", segment, err)
+			}
+			for _, aggregation := range eventstats.aggregations {
+				delete(unsupported, aggregation.as)
+			}
+			parsed.commands = append(parsed.commands, eventstats)
+		case "stats", "timechart":
+			for _, match := range asFieldRegex.FindAllStringSubmatch(args, -1) {
+				unsupported[match[1]] = name + " command"
+			}
+		case "fields", "":
+		default:
+			return nil, fmt.Errorf("unsupported command %s", name)
+		}
+	}
+	return parsed, nil
+}
+
+// parseExpression parses the value of an eval assignment; nil is returned for the unsupported expressions
+func parseExpression(value string, unsupported map[string]string) (expression, error) {
+	switch {
+	case numberRegex.MatchString(value):
+		return literalExpression(value), nil
+	case len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`):
+		return literalExpression(value[1 : len(value)-1]), nil
+	case fieldNameRegex.MatchString(value):
+		return fieldExpression(value), nil
+	case !strings.HasPrefix(strings.ToLower(value), "if(") || !strings.HasSuffix(value, ")"):
+		return nil, nil
+	}
+
+	args := splitArgs(value[len("if("):len(value)-1], ',')
+	if len(args) != 3 {
+		return nil, fmt.Errorf("if takes 3 arguments")
+	}
+	if _, found := unsupportedFunction(args[0]); found {
+		return nil, nil
+	}
+	condition, err := parseCondition(args[0], unsupported)
+	if err != nil {
+		return nil, err
+	}
+	then, err := parseExpression(args[1], unsupported)
+	if err != nil || then == nil {
+		return nil, err
+	}
+	otherwise, err := parseExpression(args[2], unsupported)
+	if err != nil || otherwise == nil {
+		return nil, err
+	}
+	return ifExpression{condition: condition, then: then, otherwise: otherwise}, nil
+}
+
+// parseEventstats parses comma separated max, min, sum and count aggregations, with an optional by clause
+func parseEventstats(args string) (eventstatsCommand, error) {
+	parts := byClauseRegex.FindStringSubmatch(args)
+	eventstats := eventstatsCommand{by: strings.FieldsFunc(parts[2], func(r rune) bool { return r == ',' || r == ' ' })}
+	for _, part := range splitArgs(parts[1], ',') {
+		match := aggregationRegex.FindStringSubmatch(part)
+		if match == nil {
+			return eventstats, fmt.Errorf("invalid aggregation %s", part)
+		}
+		function := strings.ToLower(match[1])
+		switch function {
+		case "max", "min", "sum":
+			if match[2] == "" {
+				return eventstats, fmt.Errorf("%s requires a field", function)
+			}
+		case "count":
+		default:
+			return eventstats, fmt.Errorf("unsupported function %s", function)
+		}
+		as := match[3]
+		if as == "" {
+			as = strings.TrimSuffix(fmt.Sprintf("%s(%s)", function, match[2]), "()")
+		}
+		eventstats.aggregations = append(eventstats.aggregations, aggregation{function: function, field: match[2], as: as})
+	}
+	if len(eventstats.aggregations) == 0 {
+		return eventstats, fmt.Errorf("missing aggregation")
+	}
+	return eventstats, nil
+}
+
+func (s *search) run(events []Event) []Event {
+	for _, command := range s.commands {
+		events = command.apply(events)
+	}
+	return events
+}
+
+// splitPipeline splits the query on the pipes that are not quoted
+func splitPipeline(query string) []string {
+	var segments []string
+	quoted := false
+	start := 0
+	for i, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == '|' && !quoted:
+			segments = append(segments, strings.TrimSpace(query[start:i]))
+			start = i + 1
+		}
+	}
+	return append(segments, strings.TrimSpace(query[start:]))
+}
+
+// unsupportedFunction returns the first function of the eval or where expression other than in
+func unsupportedFunction(expression string) (string, bool) {
+	for _, match := range functionRegex.FindAllStringSubmatch(quotedRegex.ReplaceAllString(expression, `""`), -1) {
+		if !strings.EqualFold(match[1], "in") {
+			return match[1], true
+		}
+	}
+	return "", false
+}
+
+// splitArgs splits the arguments on the separators that are neither quoted nor in parentheses, and trims them
+func splitArgs(args string, separator rune) []string {
+	var parts []string
+	quoted := false
+	depth := 0
+	start := 0
+	for i, r := range args {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == separator && depth == 0:
+			parts = append(parts, strings.TrimSpace(args[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(args[start:]); last != "" || len(parts) > 0 {
+		parts = append(parts, last)
+	}
+	return parts
+}
+
+func splitCommand(segment string) (string, string) {
+	parts := strings.SplitN(segment, " ", 2)
+	if len(parts) == 1 {
+		return strings.ToLower(parts[0]), ""
+	}
+	return strings.ToLower(parts[0]), strings.TrimSpace(parts[1])
+}
+
+func (c filterCommand) apply(events []Event) []Event {
+	var matching []Event
+	for _, event := range events {
+		if c.condition.matches(event) {
+			matching = append(matching, event)
+		}
+	}
+	return matching
+}
+
+func (c headCommand) apply(events []Event) []Event {
+	if len(events) > c.limit {
+		return events[:c.limit]
+	}
+	return events
+}
+
+func (c dedupCommand) apply(events []Event) []Event {
+	var deduped []Event
+	seen := make(map[string]bool)
+	for _, event := range events {
+		var key []string
+		for _, field := range c.fields {
+			value, found := event.value(field)
+			if !found {
+				key = nil
+				break
+			}
+			key = append(key, value)
+		}
+		if key == nil {
+			deduped = append(deduped, event)
+			continue
+		}
+		if k := strings.Join(key, "\x00"); !seen[k] {
+			seen[k] = true
+			deduped = append(deduped, event)
+		}
+	}
+	return deduped
+}
+
+func (c evalCommand) apply(events []Event) []Event {
+	if len(c.assignments) == 0 {
+		return events
+	}
+	evaluated := make([]Event, len(events))
+	for i, event := range events {
+		evaluated[i] = event.copy()
+		for _, assignment := range c.assignments {
+			// the assignments see the fields assigned before them
+			if value, found := assignment.value.evaluate(evaluated[i]); found {
+				evaluated[i][assignment.field] = value
+			} else {
+				delete(evaluated[i], assignment.field)
+			}
+		}
+	}
+	return evaluated
+}
+
+func (e fieldExpression) evaluate(event Event) (interface{}, bool) {
+	value, found := event[string(e)]
+	return value, found && value != nil
+}
+
+func (e literalExpression) evaluate(Event) (interface{}, bool) {
+	return string(e), true
+}
+
+func (e ifExpression) evaluate(event Event) (interface{}, bool) {
+	if e.condition.matches(event) {
+		return e.then.evaluate(event)
+	}
+	return e.otherwise.evaluate(event)
+}
+
+// apply adds the statistics of their group to the events; the events without all the by fields are left unchanged
+func (c eventstatsCommand) apply(events []Event) []Event {
+	groups := make(map[string][]int)
+	for i, event := range events {
+		if key, found := groupKey(event, c.by); found {
+			groups[key] = append(groups[key], i)
+		}
+	}
+
+	withStats := make([]Event, len(events))
+	copy(withStats, events)
+	for _, group := range groups {
+		stats := make(map[string]string)
+		for _, aggregation := range c.aggregations {
+			if value, found := aggregation.compute(events, group); found {
+				stats[aggregation.as] = value
+			}
+		}
+		for _, i := range group {
+			withStats[i] = events[i].copy()
+			for field, value := range stats {
+				withStats[i][field] = value
+			}
+		}
+	}
+	return withStats
+}
+
+// compute aggregates the numeric values of the field in the events of the group; count counts the events with the
+// field, or all of them if no field is given
+func (a aggregation) compute(events []Event, group []int) (string, bool) {
+	count := 0
+	var result float64
+	for _, i := range group {
+		if a.field == "" {
+			count++
+			continue
+		}
+		value, found := events[i].value(a.field)
+		if !found {
+			continue
+		}
+		if a.function == "count" {
+			count++
+			continue
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		switch {
+		case count == 0 || a.function == "max" && number > result || a.function == "min" && number < result:
+			result = number
+		case a.function == "sum":
+			result += number
+		}
+		count++
+	}
+	if a.function == "count" {
+		return strconv.Itoa(count), true
+	}
+	return strconv.FormatFloat(result, 'f', -1, 64), count > 0
+}
+
+// groupKey is the key of the by fields of the event, if it has all of them
+func groupKey(event Event, by []string) (string, bool) {
+	key := make([]string, 0, len(by))
+	for _, field := range by {
+		value, found := event.value(field)
+		if !found {
+			return "", false
+		}
+		key = append(key, value)
+	}
+	return strings.Join(key, "\x00"), true
+}
+
+func (c orCondition) matches(event Event) bool {
+	for _, condition := range c {
+		if condition.matches(event) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c andCondition) matches(event Event) bool {
+	for _, condition := range c {
+		if !condition.matches(event) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c notCondition) matches(event Event) bool {
+	return !c.condition.matches(event)
+}
+
+func (alwaysTrue) matches(Event) bool {
+	return true
+}
+
+// matches compares the field case insensitively, with * wildcards; field="" matches the events without the field,
+// and the events without index are in every index
+func (c comparison) matches(event Event) bool {
+	value, found := event.value(c.field)
+	if c.field == indexField && !found {
+		return true
+	}
+	switch c.operator {
+	case "=", "IN":
+		for _, expected := range c.values {
+			if expected == "" && !found {
+				return true
+			}
+			if found && matchesWildcard(value, expected) {
+				return true
+			}
+		}
+		return false
+	case "!=":
+		return found && !matchesWildcard(value, c.values[0])
+	}
+
+	actual, err := strconv.ParseFloat(value, 64)
+	if !found || err != nil {
+		return false
+	}
+	expected, err := strconv.ParseFloat(c.values[0], 64)
+	if err != nil {
+		return false
+	}
+	switch c.operator {
+	case ">":
+		return actual > expected
+	case ">=":
+		return actual >= expected
+	case "<":
+		return actual < expected
+	default:
+		return actual <= expected
+	}
+}
+
+// matches looks for the text in any field value
+func (c freeText) matches(event Event) bool {
+	for field := range event {
+		if value, _ := event.value(field); matchesWildcard(value, "*"+string(c)+"*") {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesWildcard(value string, pattern string) bool {
+	if !strings.Contains(pattern, "*") {
+		return strings.EqualFold(value, pattern)
+	}
+	expression := "(?is)^" + strings.Replace(regexp.QuoteMeta(pattern), `\*`, ".*", -1) + "$"
+	return regexp.MustCompile(expression).MatchString(value)
+}
+
+// conditionParser parses the conditions of a search or where command
+type conditionParser struct {
+	tokens      []token
+	pos         int
+	unsupported map[string]string
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+func parseCondition(args string, unsupported map[string]string) (condition, error) {
+	tokens, err := tokenize(args)
+	if err != nil {
+		return nil, err
+	}
+	parser := &conditionParser{tokens: tokens, unsupported: unsupported}
+	if len(tokens) == 0 {
+		return alwaysTrue{}, nil
+	}
+	condition, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(tokens) {
+		return nil, fmt.Errorf("unexpected %s", tokens[parser.pos].text)
+	}
+	return condition, nil
+}
+
+func tokenize(args string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(args); {
+		c := args[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"':
+			end := strings.IndexByte(args[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			tokens = append(tokens, token{text: args[i+1 : i+1+end], quoted: true})
+			i += end + 2
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, token{text: string(c)})
+			i++
+		case strings.HasPrefix(args[i:], "!=") || strings.HasPrefix(args[i:], ">=") || strings.HasPrefix(args[i:], "<="):
+			tokens = append(tokens, token{text: args[i : i+2]})
+			i += 2
+		case c == '=' || c == '>' || c == '<':
+			tokens = append(tokens, token{text: string(c)})
+			i++
+		default:
+			end := i
+			for end < len(args) && !strings.ContainsRune(" \t\n\"(),=!<>", rune(args[end])) {
+				end++
+			}
+			if end == i {
+				return nil, fmt.Errorf("unexpected %c", c)
+			}
+			tokens = append(tokens, token{text: args[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+func (p *conditionParser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *conditionParser) isKeyword(keyword string) bool {
+	t, ok := p.peek()
+	return ok && !t.quoted && strings.EqualFold(t.text, keyword)
+}
+
+func (p *conditionParser) parseOr() (condition, error) {
+	conditions := orCondition{}
+	for {
+		condition, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+		if !p.isKeyword("OR") {
+			break
+		}
+		p.pos++
+	}
+	if len(conditions) == 1 {
+		return conditions[0], nil
+	}
+	return conditions, nil
+}
+
+func (p *conditionParser) parseAnd() (condition, error) {
+	conditions := andCondition{}
+	for {
+		if p.isKeyword("AND") {
+			p.pos++
+		}
+		condition, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+		t, ok := p.peek()
+		if !ok || (!t.quoted && t.text == ")") || p.isKeyword("OR") {
+			break
+		}
+	}
+	if len(conditions) == 1 {
+		return conditions[0], nil
+	}
+	return conditions, nil
+}
+
+func (p *conditionParser) parseUnary() (condition, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end of search")
+	}
+	if p.isKeyword("NOT") {
+		p.pos++
+		condition, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notCondition{condition: condition}, nil
+	}
+	if p.isKeyword("in") && p.pos+1 < len(p.tokens) && !p.tokens[p.pos+1].quoted && p.tokens[p.pos+1].text == "(" {
+		return p.parseInFunction()
+	}
+	if !t.quoted && t.text == "(" {
+		p.pos++
+		condition, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := p.peek(); !ok || t.quoted || t.text != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return condition, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *conditionParser) parseComparison() (condition, error) {
+	field := p.tokens[p.pos]
+	p.pos++
+	operator, ok := p.peek()
+	if field.quoted || !ok || operator.quoted || !(strings.ContainsAny(operator.text, "=<>") || strings.EqualFold(operator.text, "IN")) {
+		return freeText(field.text), nil
+	}
+	p.pos++
+
+	compared := comparison{field: field.text, operator: strings.ToUpper(operator.text)}
+	if compared.operator == "IN" {
+		if t, ok := p.peek(); !ok || t.text != "(" {
+			return nil, fmt.Errorf("missing ( after IN")
+		}
+		p.pos++
+		for {
+			t, ok := p.peek()
+			if !ok {
+				return nil, fmt.Errorf("missing )")
+			}
+			p.pos++
+			if !t.quoted && t.text == ")" {
+				break
+			}
+			if !t.quoted && t.text == "," {
+				continue
+			}
+			compared.values = append(compared.values, t.text)
+		}
+	} else {
+		value, ok := p.peek()
+		if !ok {
+			return nil, fmt.Errorf("missing value of %s", field.text)
+		}
+		p.pos++
+		compared.values = []string{value.text}
+	}
+
+	return p.checkField(compared)
+}
+
+// parseInFunction parses the in(field, "value", ...) function of eval and where
+func (p *conditionParser) parseInFunction() (condition, error) {
+	p.pos += 2
+	field, ok := p.peek()
+	if !ok || field.quoted {
+		return nil, fmt.Errorf("missing field of in")
+	}
+	p.pos++
+	compared := comparison{field: field.text, operator: "IN"}
+	for {
+		t, ok := p.peek()
+		if !ok {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		if !t.quoted && t.text == ")" {
+			break
+		}
+		if !t.quoted && t.text == "," {
+			continue
+		}
+		compared.values = append(compared.values, t.text)
+	}
+	return p.checkField(compared)
+}
+
+// checkField fails the comparisons on the fields the search cannot compute, which would match every event otherwise
+func (p *conditionParser) checkField(compared comparison) (condition, error) {
+	if computedBy, found := p.unsupported[compared.field]; found {
+		return nil, fmt.Errorf("field %s is computed by the unsupported %s", compared.field, computedBy)
+	}
+	return compared, nil
+}
+
+// parseTime returns the time of a Splunk time modifier: now, a relative time like -10m, optionally real-time (rt-1m),
+// epoch seconds or RFC3339; the zero time is returned if not set
+func parseTime(value string, now time.Time) (time.Time, error) {
+	// real-time searches end with rt, which is left open
+	value = strings.TrimPrefix(value, "rt")
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if value == "now" {
+		return now, nil
+	}
+	if match := relativeTimeRegex.FindStringSubmatch(value); match != nil {
+		amount, _ := strconv.Atoi(match[1])
+		unit := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}[match[2]]
+		return now.Add(-time.Duration(amount) * unit), nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %s", value)
+	}
+	return t, nil
+}
@@ -0,0 +1,100 @@
+package splunktest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var searchEvents = []Event{
+	{"@time": "2017-09-19T14:00:03Z", "content_type": "Annotations", "event": "PublishEnd", "transaction_id": "tid_1", "uuid": "uuid_1", "monitoring_event": "true", "_indextime": "1505829605"},
+	{"@time": "2017-09-19T14:00:02Z", "content_type": "", "event": "Map", "transaction_id": "tid_1", "uuid": "uuid_1", "monitoring_event": "true", "_indextime": "1505829604", "index": "heroku"},
+	{"@time": "2017-09-19T14:00:01Z", "content_type": "Annotations", "event": "PublishStart", "transaction_id": "tid_2", "uuid": "uuid_2", "monitoring_event": "true", "_indextime": "1505829602"},
+	{"@time": "2017-09-19T14:00:00Z", "event": "PublishStart", "transaction_id": "SYNTHETIC-REQ-MON_1", "monitoring_event": true, "level": "info"},
+}
+
+func transactionIDs(events []Event) []string {
+	var ids []string
+	for _, event := range events {
+		ids = append(ids, event["transaction_id"].(string))
+	}
+	return ids
+}
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{`search monitoring_event=true`, []string{"tid_1", "tid_1", "tid_2", "SYNTHETIC-REQ-MON_1"}},
+		{`search index="heroku" (content_type="annotations" OR content_type="") transaction_id!="SYNTHETIC*"`, []string{"tid_1", "tid_1", "tid_2"}},
+		{`search index="upp" event IN ("PublishStart","PublishEnd",) NOT uuid=uuid_2`, []string{"tid_1", "SYNTHETIC-REQ-MON_1"}},
+		{`search (content_type="annotations" OR content_type="") | dedup transaction_id`, []string{"tid_1", "tid_2", "SYNTHETIC-REQ-MON_1"}},
+		{`event=Publish* | head 2`, []string{"tid_1", "tid_2"}},
+		{`search SYNTHETIC`, []string{"SYNTHETIC-REQ-MON_1"}},
+		{`search monitoring_event=true | eval indextime=_indextime | where indextime>=1505829604`, []string{"tid_1", "tid_1"}},
+		{`search monitoring_event=true | eval match=if(event="Map", 1, 0) | eventstats max(match) as txn_match by transaction_id | where txn_match=1 | fields - match`, []string{"tid_1", "tid_1"}},
+		{`search monitoring_event=true | eval match=if(in(event, "Map","PublishEnd") AND uuid="uuid_1", 1, 0) | eventstats max(match) as txn_match by transaction_id | where txn_match=0`, []string{"tid_2", "SYNTHETIC-REQ-MON_1"}},
+		{`search monitoring_event=true | eval indextime=_indextime | eventstats max(indextime) as latest_indextime, count as events by transaction_id | where latest_indextime>=1505829602 AND events=1`, []string{"tid_2"}},
+		{`search monitoring_event=true | eval indextime=_indextime | eventstats min(indextime) as first_indextime by transaction_id | where first_indextime<1505829603`, []string{"tid_2"}},
+		{`search monitoring_event=true | eval source="store", copied=source | where copied="store" | head 1`, []string{"tid_1"}},
+		{`search level="info" AND uuid=""`, []string{"SYNTHETIC-REQ-MON_1"}},
+	}
+	for _, test := range tests {
+		parsed, err := parseSearch(test.query)
+		assert.NoError(t, err, test.query)
+		assert.Equal(t, test.expected, transactionIDs(parsed.run(searchEvents)), test.query)
+	}
+
+	parsed, _ := parseSearch(`search uuid=uuid_1 | eval indextime=_indextime`)
+	results := parsed.run(searchEvents)
+	assert.Equal(t, "1505829605", results[0]["indextime"])
+	_, found := searchEvents[0]["indextime"]
+	assert.False(t, found, "events of the store are not changed")
+
+	for _, query := range []string{`search (event=Map`, `search event="Map`, `search event IN "Map"`, `search event=`, `search a=b | head ten`} {
+		_, err := parseSearch(query)
+		assert.Error(t, err, query)
+	}
+
+	unsupported := []string{
+		`search monitoring_event=true | transaction transaction_id`,
+		`search monitoring_event=true | stats count as events by transaction_id | where events>1`,
+		`search monitoring_event=true | eval open=1-closed | where open=1`,
+		`search monitoring_event=true | eval match=if(len(uuid)>3, 1, 0) | search match=1`,
+		`search monitoring_event=true | eventstats dc(uuid) as uuids by transaction_id`,
+		`search monitoring_event=true | where like(event, "Publish%")`,
+	}
+	for _, query := range unsupported {
+		_, err := parseSearch(query)
+		assert.Error(t, err, "unsupported SPL fails instead of matching every event: %s", query)
+	}
+	_, err := parseSearch(`search monitoring_event=true | stats count as events by transaction_id | eval time=_time | fields time, events`)
+	assert.NoError(t, err, "the commands shaping the results are ignored")
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2017, 9, 19, 14, 5, 0, 0, time.UTC)
+	tests := []struct {
+		value    string
+		expected time.Time
+	}{
+		{"", time.Time{}},
+		{"rt", time.Time{}},
+		{"now", now},
+		{"-10m", now.Add(-10 * time.Minute)},
+		{"rt-1m", now.Add(-time.Minute)},
+		{"-2d", now.Add(-48 * time.Hour)},
+		{"1505829604", time.Unix(1505829604, 0)},
+		{"2017-09-19T14:00:04Z", time.Date(2017, 9, 19, 14, 0, 4, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		actual, err := parseTime(test.value, now)
+		assert.NoError(t, err, test.value)
+		assert.True(t, test.expected.Equal(actual), test.value)
+	}
+
+	_, err := parseTime("@d", now)
+	assert.Error(t, err)
+}
@@ -0,0 +1,472 @@
+// Package splunktest provides a fake Splunk server for tests, implementing the subset of the Splunk REST API used by
+// splunk-event-reader: search jobs (create, status, results and cancel), export and login.
+//
+// Searches run on an in-memory event store: the search, where, head and dedup commands are applied, as are the eval
+// assignments of fields, literals and if(condition, then, else), and the max, min, sum and count aggregations of eventstats,
+// with the field comparisons of SPL (=, !=, IN, in(), numeric comparisons, * wildcards, AND, OR and NOT). The stats,
+// timechart and fields commands are ignored, as are the other eval expressions, and the fields they compute cannot be
+// filtered on. Any other command or function fails the job creation with a 400 and a FATAL message.
+package splunktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// JobsPath is the path of the search jobs endpoint
+	JobsPath = "/services/search/jobs"
+	// ExportPath is the path of the export endpoint
+	ExportPath = JobsPath + "/export"
+	// LoginPath is the path of the login endpoint
+	LoginPath = "/services/auth/login"
+
+	// TimeField is the event field holding the event time, in RFC3339
+	TimeField = "@time"
+	// IndexTimeField is the event field holding the index time, in epoch seconds; it is set when the event is added
+	IndexTimeField = "_indextime"
+
+	indexField          = "index"
+	defaultResultCount  = 100
+	dispatchStateDone   = "DONE"
+	dispatchStateFailed = "FAILED"
+	exportBuffer        = 100
+)
+
+// Event is a Splunk event, as a map of its fields
+type Event map[string]interface{}
+
+// Message is a message of a search job, e.g. a WARN for a failed indexer peer
+type Message struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Job is a search job created on the server
+type Job struct {
+	Sid          string
+	Search       string
+	EarliestTime string
+	LatestTime   string
+	Messages     []Message
+	Cancelled    bool
+	results      []Event
+}
+
+// Server is a fake Splunk server; the events are searched in the same way by all the endpoints
+type Server struct {
+	*httptest.Server
+	// User and Password are the credentials the requests must have, either with basic auth or with the session key
+	// returned by login; all the requests are accepted if User is not set
+	User     string
+	Password string
+	// Now returns the time relative search times are resolved from; time.Now is used if not set
+	Now func() time.Time
+
+	lock        sync.Mutex
+	events      []Event
+	jobs        []*Job
+	jobMessages []Message
+	failures    []failure
+	sessionKeys map[string]bool
+	subscribers map[chan Event]bool
+	done        chan struct{}
+}
+
+type failure struct {
+	status  int
+	message string
+}
+
+// NewServer starts a server holding the events
+func NewServer(events ...Event) *Server {
+	s := &Server{sessionKeys: make(map[string]bool), subscribers: make(map[chan Event]bool), done: make(chan struct{})}
+	s.AddEvents(events...)
+
+	router := mux.NewRouter()
+	router.HandleFunc(LoginPath, s.login).Methods("POST")
+	router.HandleFunc(ExportPath, s.authenticated(s.export)).Methods("GET", "POST")
+	router.HandleFunc(JobsPath, s.authenticated(s.createJob)).Methods("POST")
+	router.HandleFunc(JobsPath+"/{sid}", s.authenticated(s.jobStatus)).Methods("GET")
+	router.HandleFunc(JobsPath+"/{sid}", s.authenticated(s.cancelJob)).Methods("DELETE")
+	router.HandleFunc(JobsPath+"/{sid}/control", s.authenticated(s.controlJob)).Methods("POST")
+	router.HandleFunc(JobsPath+"/{sid}/results", s.authenticated(s.jobResults)).Methods("GET")
+	s.Server = httptest.NewServer(router)
+	return s
+}
+
+// Close ends the real-time exports, and shuts the server down
+func (s *Server) Close() {
+	close(s.done)
+	s.Server.CloseClientConnections()
+	s.Server.Close()
+}
+
+// AddEvents adds events to the store, which are sent to the running real-time exports they match
+func (s *Server) AddEvents(events ...Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	indexTime := strconv.FormatInt(s.now().Unix(), 10)
+	for _, event := range events {
+		event = event.copy()
+		if _, found := event[IndexTimeField]; !found {
+			event[IndexTimeField] = indexTime
+		}
+		s.events = append(s.events, event)
+		for subscriber := range s.subscribers {
+			select {
+			case subscriber <- event:
+			default:
+			}
+		}
+	}
+}
+
+// Jobs returns the search jobs created so far, oldest first
+func (s *Server) Jobs() []Job {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	jobs := make([]Job, len(s.jobs))
+	for i, job := range s.jobs {
+		jobs[i] = *job
+	}
+	return jobs
+}
+
+// SetJobMessages sets the messages of the jobs created from now on; a job with an ERROR message has failed
+func (s *Server) SetJobMessages(messages ...Message) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.jobMessages = messages
+}
+
+// Fail makes the next count requests fail with the status and an ERROR message
+func (s *Server) Fail(count int, status int, message string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := 0; i < count; i++ {
+		s.failures = append(s.failures, failure{status: status, message: message})
+	}
+}
+
+// now is called with the lock held
+func (s *Server) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func (s *Server) authenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		var failed *failure
+		if len(s.failures) > 0 {
+			failed = &s.failures[0]
+			s.failures = s.failures[1:]
+		}
+		authorized := s.User == "" || s.sessionKeys[strings.TrimPrefix(r.Header.Get("Authorization"), "Splunk ")]
+		if user, password, ok := r.BasicAuth(); ok && user == s.User && password == s.Password {
+			authorized = true
+		}
+		s.lock.Unlock()
+
+		switch {
+		case failed != nil:
+			writeMessages(w, failed.status, Message{Type: "ERROR", Text: failed.message})
+		case !authorized:
+			writeMessages(w, http.StatusUnauthorized, Message{Type: "WARN", Text: "call not properly authenticated"})
+		default:
+			handler(w, r)
+		}
+	}
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.User != "" && (r.FormValue("username") != s.User || r.FormValue("password") != s.Password) {
+		writeMessages(w, http.StatusUnauthorized, Message{Type: "WARN", Text: "Login failed"})
+		return
+	}
+	sessionKey := fmt.Sprintf("session_%d", len(s.sessionKeys)+1)
+	s.sessionKeys[sessionKey] = true
+	if r.FormValue("output_mode") == "json" {
+		writeJSON(w, http.StatusOK, map[string]string{"sessionKey": sessionKey})
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, "<response>\n  <sessionKey>%s</sessionKey>\n</response>\n", sessionKey)
+}
+
+func (s *Server) createJob(w http.ResponseWriter, r *http.Request) {
+	job := &Job{Search: r.FormValue("search"), EarliestTime: r.FormValue("earliest_time"), LatestTime: r.FormValue("latest_time")}
+	results, err := s.search(job.Search, job.EarliestTime, job.LatestTime)
+	if err != nil {
+		writeMessages(w, http.StatusBadRequest, Message{Type: "FATAL", Text: err.Error()})
+		return
+	}
+
+	s.lock.Lock()
+	job.Sid = fmt.Sprintf("sid_%d", len(s.jobs)+1)
+	job.Messages = s.jobMessages
+	job.results = results
+	s.jobs = append(s.jobs, job)
+	s.lock.Unlock()
+
+	writeJSON(w, http.StatusCreated, map[string]string{"sid": job.Sid})
+}
+
+func (s *Server) jobStatus(w http.ResponseWriter, r *http.Request) {
+	job, found := s.job(mux.Vars(r)["sid"])
+	if !found {
+		writeUnknownSid(w)
+		return
+	}
+	dispatchState := dispatchStateDone
+	for _, message := range job.Messages {
+		if message.Type == "ERROR" {
+			dispatchState = dispatchStateFailed
+		}
+	}
+	messages := job.Messages
+	if messages == nil {
+		messages = []Message{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"entry": []interface{}{map[string]interface{}{
+			"name": job.Sid,
+			"content": map[string]interface{}{
+				"sid":           job.Sid,
+				"dispatchState": dispatchState,
+				"isDone":        true,
+				"isFailed":      dispatchState == dispatchStateFailed,
+				"isFinalized":   false,
+				"messages":      messages,
+				"eventCount":    len(job.results),
+				"resultCount":   len(job.results),
+				"earliestTime":  job.EarliestTime,
+				"latestTime":    job.LatestTime,
+				"runDuration":   0.001,
+			},
+		}},
+	})
+}
+
+func (s *Server) jobResults(w http.ResponseWriter, r *http.Request) {
+	job, found := s.job(mux.Vars(r)["sid"])
+	if !found {
+		writeUnknownSid(w)
+		return
+	}
+	offset, _ := strconv.Atoi(r.FormValue("offset"))
+	count := defaultResultCount
+	if value := r.FormValue("count"); value != "" {
+		count, _ = strconv.Atoi(value)
+	}
+
+	results := job.results
+	if offset >= len(results) {
+		results = nil
+	} else {
+		results = results[offset:]
+	}
+	if count > 0 && len(results) > count {
+		results = results[:count]
+	}
+	if results == nil {
+		results = []Event{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+}
+
+func (s *Server) cancelJob(w http.ResponseWriter, r *http.Request) {
+	if !s.cancel(mux.Vars(r)["sid"]) {
+		writeUnknownSid(w)
+		return
+	}
+	writeMessages(w, http.StatusOK, Message{Type: "INFO", Text: "Search job cancelled."})
+}
+
+func (s *Server) controlJob(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("action") != "cancel" {
+		writeMessages(w, http.StatusBadRequest, Message{Type: "ERROR", Text: fmt.Sprintf("Unsupported action %s", r.FormValue("action"))})
+		return
+	}
+	s.cancelJob(w, r)
+}
+
+// export streams the results as lines of JSON; a real-time export goes on with the events added later,
+// until the client or the server closes the connection
+func (s *Server) export(w http.ResponseWriter, r *http.Request) {
+	query, earliestTime, latestTime := r.FormValue("search"), r.FormValue("earliest_time"), r.FormValue("latest_time")
+	realtime := r.FormValue("search_mode") == "realtime" || strings.HasPrefix(earliestTime, "rt")
+
+	var added chan Event
+	if realtime {
+		added = make(chan Event, exportBuffer)
+		s.lock.Lock()
+		s.subscribers[added] = true
+		s.lock.Unlock()
+		defer func() {
+			s.lock.Lock()
+			delete(s.subscribers, added)
+			s.lock.Unlock()
+		}()
+	}
+
+	results, err := s.search(query, earliestTime, latestTime)
+	if err != nil {
+		writeMessages(w, http.StatusBadRequest, Message{Type: "FATAL", Text: err.Error()})
+		return
+	}
+	// the search is valid, so it is parsed again for the events added later
+	parsed, _ := parseSearch(query)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flush := func() {
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	flush()
+	encoder := json.NewEncoder(w)
+	offset := 0
+	write := func(event Event) {
+		encoder.Encode(map[string]interface{}{"preview": false, "offset": offset, "result": event})
+		offset++
+		flush()
+	}
+	// export returns the oldest results first
+	for i := len(results) - 1; i >= 0; i-- {
+		write(results[i])
+	}
+	if !realtime {
+		return
+	}
+
+	for {
+		select {
+		case event := <-added:
+			for _, result := range parsed.run([]Event{event}) {
+				write(result)
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// search returns the events of the time window matching the query, latest first
+func (s *Server) search(query string, earliestTime string, latestTime string) ([]Event, error) {
+	parsed, err := parseSearch(query)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	now := s.now()
+	events := make([]Event, len(s.events))
+	copy(events, s.events)
+	s.lock.Unlock()
+
+	earliest, err := parseTime(earliestTime, now)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := parseTime(latestTime, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var window []Event
+	for _, event := range events {
+		t, hasTime := event.time()
+		if hasTime && ((!earliest.IsZero() && t.Before(earliest)) || (!latest.IsZero() && t.After(latest))) {
+			continue
+		}
+		window = append(window, event)
+	}
+	sort.SliceStable(window, func(i, j int) bool {
+		ti, _ := window[i].time()
+		tj, _ := window[j].time()
+		return ti.After(tj)
+	})
+	return parsed.run(window), nil
+}
+
+func (s *Server) job(sid string) (Job, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, job := range s.jobs {
+		if job.Sid == sid && !job.Cancelled {
+			return *job, true
+		}
+	}
+	return Job{}, false
+}
+
+func (s *Server) cancel(sid string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, job := range s.jobs {
+		if job.Sid == sid && !job.Cancelled {
+			job.Cancelled = true
+			return true
+		}
+	}
+	return false
+}
+
+// value returns the field value as a string, as SPL compares it
+func (e Event) value(field string) (string, bool) {
+	value, found := e[field]
+	if !found || value == nil {
+		return "", false
+	}
+	if s, ok := value.(string); ok {
+		return s, true
+	}
+	return fmt.Sprint(value), true
+}
+
+// time returns the event time, if it has a valid one
+func (e Event) time() (time.Time, bool) {
+	value, _ := e.value(TimeField)
+	t, err := time.Parse(time.RFC3339Nano, value)
+	return t, err == nil
+}
+
+func (e Event) copy() Event {
+	copied := make(Event, len(e))
+	for field, value := range e {
+		copied[field] = value
+	}
+	return copied
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeMessages(w http.ResponseWriter, status int, messages ...Message) {
+	writeJSON(w, status, map[string]interface{}{"messages": messages})
+}
+
+func writeUnknownSid(w http.ResponseWriter) {
+	writeMessages(w, http.StatusNotFound, Message{Type: "FATAL", Text: "Unknown sid."})
+}
@@ -0,0 +1,203 @@
+package splunktest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer() *Server {
+	server := NewServer(searchEvents...)
+	server.Now = func() time.Time {
+		return time.Date(2017, 9, 19, 14, 5, 0, 0, time.UTC)
+	}
+	return server
+}
+
+func post(t *testing.T, server *Server, path string, form url.Values) *http.Response {
+	req, err := http.NewRequest("POST", server.URL+path, strings.NewReader(form.Encode()))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("user", "password")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	return resp
+}
+
+func get(t *testing.T, server *Server, path string, result interface{}) int {
+	req, err := http.NewRequest("GET", server.URL+path, nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth("user", "password")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(result))
+	return resp.StatusCode
+}
+
+func TestServer_Jobs(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	resp := post(t, server, JobsPath, url.Values{"search": {`search event=Publish*`}, "earliest_time": {"1505829601"}, "exec_mode": {"blocking"}})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	sid := struct {
+		Sid string `json:"sid"`
+	}{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sid))
+	resp.Body.Close()
+	assert.Equal(t, "sid_1", sid.Sid)
+
+	status := struct {
+		Entry []struct {
+			Content struct {
+				DispatchState string    `json:"dispatchState"`
+				IsDone        bool      `json:"isDone"`
+				Messages      []Message `json:"messages"`
+				ResultCount   int       `json:"resultCount"`
+			} `json:"content"`
+		} `json:"entry"`
+	}{}
+	assert.Equal(t, http.StatusOK, get(t, server, JobsPath+"/sid_1?output_mode=json", &status))
+	assert.Equal(t, "DONE", status.Entry[0].Content.DispatchState)
+	assert.True(t, status.Entry[0].Content.IsDone)
+	assert.Empty(t, status.Entry[0].Content.Messages)
+	// the SYNTHETIC event is before the earliest time
+	assert.Equal(t, 2, status.Entry[0].Content.ResultCount)
+
+	results := struct {
+		Results []Event `json:"results"`
+	}{}
+	assert.Equal(t, http.StatusOK, get(t, server, JobsPath+"/sid_1/results?output_mode=json&count=1&offset=1", &results))
+	assert.Equal(t, []string{"tid_2"}, transactionIDs(results.Results))
+	assert.Equal(t, http.StatusOK, get(t, server, JobsPath+"/sid_1/results?output_mode=json&count=0", &results))
+	assert.Equal(t, []string{"tid_1", "tid_2"}, transactionIDs(results.Results))
+
+	assert.Equal(t, []Job{{Sid: "sid_1", Search: `search event=Publish*`, EarliestTime: "1505829601", results: results.Results}}, server.Jobs())
+
+	resp = post(t, server, JobsPath+"/sid_1/control", url.Values{"action": {"cancel"}})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, server.Jobs()[0].Cancelled)
+	assert.Equal(t, http.StatusNotFound, get(t, server, JobsPath+"/sid_1/results", &results))
+}
+
+func TestServer_JobMessagesAndFailures(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	server.SetJobMessages(Message{Type: "ERROR", Text: "Streamed search execute failed"})
+	resp := post(t, server, JobsPath, url.Values{"search": {`search *`}})
+	resp.Body.Close()
+	status := struct {
+		Entry []struct {
+			Content struct {
+				DispatchState string    `json:"dispatchState"`
+				Messages      []Message `json:"messages"`
+			} `json:"content"`
+		} `json:"entry"`
+	}{}
+	get(t, server, JobsPath+"/sid_1", &status)
+	assert.Equal(t, "FAILED", status.Entry[0].Content.DispatchState)
+	assert.Equal(t, []Message{{Type: "ERROR", Text: "Streamed search execute failed"}}, status.Entry[0].Content.Messages)
+
+	server.Fail(1, http.StatusServiceUnavailable, "Search head is busy")
+	resp = post(t, server, JobsPath, url.Values{"search": {`search *`}})
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.JSONEq(t, `{"messages":[{"type":"ERROR","text":"Search head is busy"}]}`, string(body))
+
+	resp = post(t, server, JobsPath, url.Values{"search": {`search (event=Map`}})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = post(t, server, JobsPath, url.Values{"search": {`search * | transaction transaction_id`}})
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.JSONEq(t, `{"messages":[{"type":"FATAL","text":"unsupported command transaction"}]}`, string(body))
+	assert.Len(t, server.Jobs(), 1)
+}
+
+func TestServer_Authentication(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+	server.User = "admin"
+	server.Password = "changeme"
+
+	resp := post(t, server, JobsPath, url.Values{"search": {`search *`}})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = post(t, server, LoginPath, url.Values{"username": {"admin"}, "password": {"wrong"}})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = post(t, server, LoginPath, url.Values{"username": {"admin"}, "password": {"changeme"}, "output_mode": {"json"}})
+	login := struct {
+		SessionKey string `json:"sessionKey"`
+	}{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&login))
+	resp.Body.Close()
+	assert.NotEmpty(t, login.SessionKey)
+
+	req, _ := http.NewRequest("POST", server.URL+JobsPath, strings.NewReader("search=search+*"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Splunk "+login.SessionKey)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+}
+
+func TestServer_Export(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	resp := post(t, server, ExportPath, url.Values{"search": {`search content_type=annotations`}, "output_mode": {"json"}})
+	var results []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := struct {
+			Result Event `json:"result"`
+		}{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		results = append(results, line.Result["event"].(string))
+	}
+	resp.Body.Close()
+	// oldest first
+	assert.Equal(t, []string{"PublishStart", "PublishEnd"}, results)
+}
+
+func TestServer_ExportRealtime(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	form := url.Values{"search": {`search event=Map`}, "search_mode": {"realtime"}, "earliest_time": {"rt-1m"}, "latest_time": {"rt"}}
+	req, _ := http.NewRequest("POST", server.URL+ExportPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	server.AddEvents(Event{"@time": "2017-09-19T14:05:00Z", "event": "PublishEnd", "transaction_id": "tid_3"}, Event{"@time": "2017-09-19T14:05:00Z", "event": "Map", "transaction_id": "tid_3"})
+	line := struct {
+		Preview bool  `json:"preview"`
+		Result  Event `json:"result"`
+	}{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&line))
+	assert.False(t, line.Preview)
+	assert.Equal(t, "tid_3", line.Result["transaction_id"])
+	assert.Equal(t, "1505829900", line.Result[IndexTimeField])
+}